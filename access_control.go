@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix"
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+)
+
+// serverName returns the part of a Matrix identifier after the first colon, or
+// an empty string if there is none.
+func serverName(identifier string) string {
+	if i := strings.IndexByte(identifier, ':'); i >= 0 {
+		return strings.ToLower(identifier[i+1:])
+	}
+	return ""
+}
+
+func containsDomain(domains []string, domain string) bool {
+	for _, d := range domains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDomainAllowed returns whether users and rooms on the given homeserver can
+// use the bot.
+func (c *AccessControlConfiguration) IsDomainAllowed(domain string) bool {
+	return !containsDomain(c.DeniedDomains, domain)
+}
+
+// IsUserAllowed returns whether the given user can invite the bot and use its
+// commands. The denylists are checked first. If no allowlists are configured,
+// everyone else is allowed.
+func (c *AccessControlConfiguration) IsUserAllowed(userID mid.UserID) bool {
+	domain := serverName(userID.String())
+	for _, denied := range c.DeniedUsers {
+		if denied == userID {
+			return false
+		}
+	}
+	if !c.IsDomainAllowed(domain) {
+		return false
+	}
+	if len(c.AllowedUsers) == 0 && len(c.AllowedDomains) == 0 {
+		return true
+	}
+	for _, allowed := range c.AllowedUsers {
+		if allowed == userID {
+			return true
+		}
+	}
+	return containsDomain(c.AllowedDomains, domain)
+}
+
+// canUseBot returns whether the user can use the bot. Admins can always use it.
+func canUseBot(userID mid.UserID) bool {
+	config := currentConfiguration()
+	return config.IsAdmin(userID) || config.AccessControl.IsUserAllowed(userID)
+}
+
+// isInRequiredSpace returns whether the room is a child of the configured
+// space, or true if no space is configured.
+func isInRequiredSpace(roomID mid.RoomID) bool {
+	space := currentConfiguration().AccessControl.RequiredSpace
+	if space == "" {
+		return true
+	}
+	var child mevent.SpaceChildEventContent
+	if err := matrixClient.StateEvent(space, mevent.StateSpaceChild, roomID.String(), &child); err != nil {
+		log.Debugf("Could not get the %s child of %s: %v", roomID, space, err)
+		return false
+	}
+	// A space child event without via servers means that the room was removed.
+	return len(child.Via) > 0
+}
+
+// checkSendRoom returns why the given room cannot be used as a send room, or
+// an empty string if it can be.
+func checkSendRoom(lang, roomIDorAlias string) string {
+	accessControl := currentConfiguration().AccessControl
+	if domain := serverName(roomIDorAlias); domain != "" && !accessControl.IsDomainAllowed(domain) {
+		return T(lang, "access.room_domain_denied", roomIDorAlias, domain)
+	}
+	if accessControl.RequiredSpace == "" {
+		return ""
+	}
+
+	roomID := mid.RoomID(roomIDorAlias)
+	if strings.HasPrefix(roomIDorAlias, "#") {
+		resp, err := DoRetry("resolve alias", func() (interface{}, error) {
+			return matrixClient.ResolveAlias(mid.RoomAlias(roomIDorAlias))
+		})
+		if err != nil {
+			return T(lang, "room.join_failed", roomIDorAlias, err)
+		}
+		roomID = resp.(*mautrix.RespAliasResolve).RoomID
+	}
+	if !isInRequiredSpace(roomID) {
+		return T(lang, "access.room_not_in_space", roomIDorAlias, accessControl.RequiredSpace)
+	}
+	return ""
+}
+
+// HandleInvite joins the room that the bot was invited to if the inviter is
+// allowed to use the bot, and rejects the invite otherwise.
+func HandleInvite(event *mevent.Event) {
+	if !canUseBot(event.Sender) {
+		log.Warnf("Rejecting the invite from %s to %s", event.Sender, event.RoomID)
+		_, err := DoRetry("reject invite", func() (interface{}, error) {
+			return matrixClient.LeaveRoom(event.RoomID, &mautrix.ReqLeave{Reason: T(defaultLanguage, "access.invite_rejected")})
+		})
+		if err != nil {
+			log.Errorf("Could not reject the invite to %s. Error %+v", event.RoomID, err)
+		}
+		return
+	}
+
+	log.Info("Joining ", event.RoomID)
+	_, err := DoRetry("join room", func() (interface{}, error) {
+		return matrixClient.JoinRoom(event.RoomID.String(), "", nil)
+	})
+	if err != nil {
+		log.Errorf("Could not join channel %s. Error %+v", event.RoomID.String(), err)
+	} else {
+		log.Infof("Joined %s sucessfully", event.RoomID.String())
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+)
+
+func TestIsUserAllowed(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		config   AccessControlConfiguration
+		userID   mid.UserID
+		expected bool
+	}{
+		{"no lists", AccessControlConfiguration{}, "@alice:example.com", true},
+		{"allowed user", AccessControlConfiguration{AllowedUsers: []mid.UserID{"@alice:example.com"}}, "@alice:example.com", true},
+		{"not allowed user", AccessControlConfiguration{AllowedUsers: []mid.UserID{"@alice:example.com"}}, "@bob:example.com", false},
+		{"allowed domain", AccessControlConfiguration{AllowedDomains: []string{"Example.com"}}, "@bob:example.com", true},
+		{"not allowed domain", AccessControlConfiguration{AllowedDomains: []string{"example.com"}}, "@bob:example.org", false},
+		{"denied user", AccessControlConfiguration{AllowedDomains: []string{"example.com"}, DeniedUsers: []mid.UserID{"@bob:example.com"}}, "@bob:example.com", false},
+		{"denied domain", AccessControlConfiguration{AllowedUsers: []mid.UserID{"@bob:spam.example"}, DeniedDomains: []string{"spam.example"}}, "@bob:spam.example", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if allowed := test.config.IsUserAllowed(test.userID); allowed != test.expected {
+				t.Errorf("Expected IsUserAllowed(%s) to be %t, got %t", test.userID, test.expected, allowed)
+			}
+		})
+	}
+}
+
+func TestInvites(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	configuration.AccessControl.DeniedDomains = []string{"spam.example"}
+
+	HandleInvite(&mevent.Event{RoomID: "!spam:spam.example", Sender: "@spammer:spam.example"})
+	if len(hs.leftRooms) != 1 || hs.leftRooms[0] != "!spam:spam.example" || len(hs.joinedRooms) != 0 {
+		t.Errorf("Expected the bot to reject the invite, left %v and joined %v", hs.leftRooms, hs.joinedRooms)
+	}
+
+	HandleInvite(&mevent.Event{RoomID: testConfigRoom, Sender: testUser})
+	if len(hs.joinedRooms) != 1 || hs.joinedRooms[0] != testConfigRoom {
+		t.Errorf("Expected the bot to join %s, joined %v", testConfigRoom, hs.joinedRooms)
+	}
+}
+
+func TestIgnoresDeniedUsers(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	configuration.AccessControl.DeniedUsers = []mid.UserID{testUser}
+
+	hs.sendText("!su help")
+	if messages := hs.messages(testConfigRoom); len(messages) != 0 {
+		t.Errorf("Expected the message of a denied user to be ignored, got %d messages", len(messages))
+	}
+}
+
+func TestRemovedUsers(t *testing.T) {
+	hs := setupReminderTest(t, tuesday)
+	hs.sendText("!su room " + testSendRoom.String())
+	hs.sendText("!su new")
+	answerQuestion(t, hs, "What did you do yesterday?", "Wrote tests")
+	answerQuestion(t, hs, "What are you planning to do today?", "Fix bugs")
+	answerQuestion(t, hs, "Do you have any blockers?")
+	answerQuestion(t, hs, "Do you have any other notes?")
+	preview := hs.lastMessage(t, testConfigRoom)
+
+	// Users who are no longer allowed cannot send their post and are not
+	// reminded.
+	configuration.AccessControl.DeniedUsers = []mid.UserID{testUser}
+	hs.react(preview.ID, CHECKMARK)
+	if messages := hs.messages(testSendRoom); len(messages) != 0 {
+		t.Errorf("Expected the post not to be sent, got %d messages", len(messages))
+	}
+	sendDueReminders(tuesday)
+	if sent := reminders(hs); len(sent) != 0 {
+		t.Errorf("Expected no reminders, got %q", sent)
+	}
+}
+
+func TestSendRoomAccessControl(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	configuration.AccessControl.DeniedDomains = []string{"spam.example"}
+	configuration.AccessControl.RequiredSpace = "!space:example.com"
+
+	hs.sendText("!su room !other:spam.example")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "rooms on spam.example are not allowed")
+
+	hs.sendText("!su room " + testSendRoom.String())
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "it is not in the space !space:example.com")
+	if len(hs.joinedRooms) != 0 {
+		t.Errorf("Expected the bot not to join any rooms, joined %v", hs.joinedRooms)
+	}
+
+	hs.aliases["#standup:example.com"] = testSendRoom
+	hs.SendStateEvent("!space:example.com", mevent.StateSpaceChild, testSendRoom.String(), mevent.SpaceChildEventContent{Via: []string{"example.com"}})
+	hs.sendText("!su room #standup:example.com")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "Joined #standup:example.com and set that as your send room")
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+)
+
+var startTime = time.Now()
+
+// The Unix time of the last sync response, or 0 if there has not been one.
+var lastSyncAt int64
+
+var adminCommand = &Command{
+	Name:        "admin",
+	Description: "command.admin",
+	AdminOnly:   true,
+	Subcommands: []*Command{
+		{
+			Name:        "users",
+			Description: "command.admin.users",
+			Handler:     HandleAdminUsers,
+		},
+		{
+			Name:        "rooms",
+			Description: "command.admin.rooms",
+			Handler:     HandleAdminRooms,
+		},
+		{
+			Name:        "leave",
+			Args:        []CommandArgument{{Name: "room ID"}},
+			Description: "command.admin.leave",
+			Handler:     HandleAdminLeave,
+		},
+		{
+			Name:        "broadcast",
+			Args:        []CommandArgument{{Name: "message", Rest: true}},
+			Description: "command.admin.broadcast",
+			Details:     "command.admin.broadcast.details",
+			Handler:     HandleAdminBroadcast,
+		},
+		{
+			Name:        "status",
+			Description: "command.admin.status",
+			Handler:     HandleAdminStatus,
+		},
+		{
+			Name:        "reload",
+			Description: "command.admin.reload",
+			Details:     "command.admin.reload.details",
+			Handler:     HandleAdminReload,
+		},
+	},
+}
+
+func sendNotice(roomID mid.RoomID, text string) {
+	SendMessage(roomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: text})
+}
+
+func HandleAdminUsers(event *mevent.Event, args []string) {
+	lang := languageFor(event.Sender)
+	users := stateStore.GetKnownUsers()
+	if len(users) == 0 {
+		sendNotice(event.RoomID, T(lang, "admin.users.none"))
+		return
+	}
+
+	unset := T(lang, "admin.unset")
+	lines := []string{T(lang, "admin.users.header", len(users))}
+	for _, user := range users {
+		settings := []string{T(lang, "admin.users.config_room", user.ConfigRoomID)}
+		sendRoom, timezone, notify, threads, language := unset, unset, unset, unset, unset
+		if user.SendRoomID != "" {
+			sendRoom = user.SendRoomID.String()
+		}
+		if user.Timezone != "" {
+			timezone = user.Timezone
+		}
+		if user.NotifyMinutesAfterMidnight != nil {
+			notify = fmt.Sprintf("%02d:%02d", *user.NotifyMinutesAfterMidnight/60, *user.NotifyMinutesAfterMidnight%60)
+		}
+		if user.UseThreads != nil {
+			threads = fmt.Sprintf("%t", *user.UseThreads)
+		}
+		if user.Language != "" {
+			language = user.Language
+		}
+		settings = append(settings,
+			T(lang, "admin.users.send_room", sendRoom),
+			T(lang, "admin.users.timezone", timezone),
+			T(lang, "admin.users.notify", notify),
+			T(lang, "admin.users.threads", threads),
+			T(lang, "admin.users.language", language))
+		lines = append(lines, fmt.Sprintf("* %s: %s", user.UserID, strings.Join(settings, ", ")))
+	}
+	sendNotice(event.RoomID, strings.Join(lines, "\n"))
+}
+
+func HandleAdminRooms(event *mevent.Event, args []string) {
+	lang := languageFor(event.Sender)
+	resp, err := matrixClient.JoinedRooms()
+	if err != nil {
+		sendNotice(event.RoomID, T(lang, "admin.rooms.failed", err))
+		return
+	}
+
+	lines := []string{T(lang, "admin.rooms.header", len(resp.JoinedRooms))}
+	for _, roomID := range resp.JoinedRooms {
+		lines = append(lines, fmt.Sprintf("* %s (%s)", roomID, T(lang, "admin.rooms.members", len(stateStore.GetRoomMembers(roomID)))))
+	}
+	sendNotice(event.RoomID, strings.Join(lines, "\n"))
+}
+
+func HandleAdminLeave(event *mevent.Event, args []string) {
+	lang := languageFor(event.Sender)
+	roomID := mid.RoomID(args[0])
+	log.Infof("%s asked to leave %s", event.Sender, roomID)
+	_, err := DoRetry("leave room", func() (interface{}, error) {
+		return matrixClient.LeaveRoom(roomID)
+	})
+	if err != nil {
+		sendNotice(event.RoomID, T(lang, "admin.leave.failed", roomID, err))
+	} else if roomID != event.RoomID {
+		sendNotice(event.RoomID, T(lang, "admin.leave.done", roomID))
+	}
+}
+
+func HandleAdminBroadcast(event *mevent.Event, args []string) {
+	lang := languageFor(event.Sender)
+	configRooms := map[mid.RoomID]bool{}
+	for _, user := range stateStore.GetKnownUsers() {
+		configRooms[user.ConfigRoomID] = true
+	}
+
+	log.Infof("%s is broadcasting a message to %d rooms", event.Sender, len(configRooms))
+	failed := 0
+	for roomID := range configRooms {
+		if _, err := SendMessage(roomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: args[0]}); err != nil {
+			failed++
+		}
+	}
+	sendNotice(event.RoomID, T(lang, "admin.broadcast.done", len(configRooms)-failed, len(configRooms)))
+}
+
+func HandleAdminStatus(event *mevent.Event, args []string) {
+	lang := languageFor(event.Sender)
+	var syncStatus string
+	if configuration.Appservice.Enabled() {
+		syncStatus = T(lang, "admin.status.appservice")
+	} else if lastSync := atomic.LoadInt64(&lastSyncAt); lastSync == 0 {
+		syncStatus = T(lang, "admin.status.never_synced")
+	} else {
+		syncStatus = T(lang, "admin.status.synced", time.Since(time.Unix(lastSync, 0)).Round(time.Second))
+	}
+
+	status := []string{
+		T(lang, "admin.status.version", VERSION),
+		T(lang, "admin.status.uptime", time.Since(startTime).Round(time.Second)),
+		syncStatus,
+	}
+	if failures := atomic.LoadInt64(&syncFailures); failures > 0 {
+		status = append(status, T(lang, "admin.status.sync_failing", failures, lastSyncError.Load()))
+	}
+	status = append(status, T(lang, "admin.status.users", len(stateStore.UserConfigRooms)))
+	sendNotice(event.RoomID, strings.Join(status, "\n"))
+}
+
+func HandleAdminReload(event *mevent.Event, args []string) {
+	lang := languageFor(event.Sender)
+	if err := ReloadConfiguration(); err != nil {
+		log.Errorf("Failed to reload the configuration: %+v", err)
+		sendNotice(event.RoomID, T(lang, "admin.reload.failed", err))
+		return
+	}
+	sendNotice(event.RoomID, T(lang, "admin.reload.done"))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	mid "maunium.net/go/mautrix/id"
+)
+
+func TestAdminCommandsRequireAdmin(t *testing.T) {
+	hs := setupTest(t, tuesday)
+
+	hs.sendText("!su admin status")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "Unknown command admin.")
+
+	configuration.Admins = []mid.UserID{testUser}
+	hs.sendText("!su admin status")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "Version: "+VERSION, "Uptime: ", "no successful sync yet")
+
+	hs.sendText("!su help")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "admin <subcommand>")
+}
+
+func TestAdminSubcommands(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	configuration.Admins = []mid.UserID{testUser}
+
+	hs.sendText("!su admin")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "Usage: !su admin <subcommand>", "* admin leave <room ID>")
+
+	hs.sendText("!su admin stauts")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "Unknown command stauts.", "Did you mean admin status?")
+
+	hs.sendText("!su room " + testSendRoom.String())
+	hs.sendText("!su tz Europe/Berlin")
+	hs.sendText("!su admin users")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body,
+		"1 known users:", "* @alice:example.com: config room !config:example.com, send room !send:example.com, timezone Europe/Berlin, notification time not set")
+
+	hs.sendText("!su admin rooms")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "!config:example.com", "!send:example.com")
+
+	hs.sendText("!su notify 0:00")
+	hs.sendText("!su admin users")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "notification time 00:00")
+
+	hs.sendText("!su admin broadcast The bot will restart soon.\n\n  Downtime:  ~5 minutes")
+	messages := hs.messages(testConfigRoom)
+	if body := messages[len(messages)-2].Content.AsMessage().Body; body != "The bot will restart soon.\n\n  Downtime:  ~5 minutes" {
+		t.Errorf("Expected the broadcast to keep its layout, got %q", body)
+	}
+	assertContains(t, messages[len(messages)-1].Content.AsMessage().Body, "Sent the message to 1 of 1 config rooms.")
+
+	hs.sendText("!su admin leave !other:example.com")
+	if len(hs.leftRooms) != 1 || hs.leftRooms[0] != "!other:example.com" {
+		t.Errorf("Expected the bot to leave !other:example.com, left %v", hs.leftRooms)
+	}
+}
+
+func TestAdminReload(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	configuration.Admins = []mid.UserID{testUser}
+
+	configPath = filepath.Join(t.TempDir(), "config.json")
+	config := `{"Homeserver": "https://matrix.example.com", "Username": "@standupbot:example.com", "Admins": ["@alice:example.com"], "CommandPrefixes": ["!standup"]}`
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	hs.sendText("!su admin reload")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "Reloaded the configuration.")
+	if prefixes := configuration.GetCommandPrefixes(); len(prefixes) != 1 || prefixes[0] != "!standup" {
+		t.Errorf("Expected the command prefixes to be reloaded, got %v", prefixes)
+	}
+
+	hs.sendText("!standup admin status")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "Version: "+VERSION)
+}
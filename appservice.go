@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/appservice"
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+)
+
+var appService *appservice.AppService
+
+// cryptoSyncer only syncs the to-device events, device lists and one-time key
+// counts that the OlmMachine needs. In appservice mode, the room events are
+// pushed to the bot by the homeserver in transactions.
+type cryptoSyncer struct {
+	*mautrix.DefaultSyncer
+}
+
+func (s cryptoSyncer) GetFilterJSON(userID mid.UserID) *mautrix.Filter {
+	everything := []mevent.Type{{Type: "*"}}
+	return &mautrix.Filter{
+		Presence:    mautrix.FilterPart{NotTypes: everything},
+		AccountData: mautrix.FilterPart{NotTypes: everything},
+		Room: mautrix.RoomFilter{
+			IncludeLeave: false,
+			Ephemeral:    mautrix.FilterPart{NotTypes: everything},
+			AccountData:  mautrix.FilterPart{NotTypes: everything},
+			State:        mautrix.FilterPart{NotTypes: everything},
+			Timeline:     mautrix.FilterPart{NotTypes: everything},
+		},
+	}
+}
+
+func GenerateRegistration(path string) error {
+	username := mid.UserID(configuration.Username)
+	localpart, homeserver, err := username.Parse()
+	if err != nil {
+		return fmt.Errorf("invalid username %s: %w", username, err)
+	}
+
+	registration := appservice.CreateRegistration()
+	registration.ID = configuration.Appservice.ID
+	if registration.ID == "" {
+		registration.ID = "standupbot"
+	}
+	registration.URL = configuration.Appservice.Address
+	registration.SenderLocalpart = localpart
+	rateLimited := false
+	registration.RateLimited = &rateLimited
+
+	registration.Namespaces.RegisterUserIDs(regexp.MustCompile(fmt.Sprintf("^%s$", regexp.QuoteMeta(username.String()))), true)
+	if configuration.Appservice.PuppetUsers {
+		// Puppeting requires being able to masquerade as all of the users on
+		// the homeserver, so register a non-exclusive namespace for them.
+		registration.Namespaces.RegisterUserIDs(regexp.MustCompile(fmt.Sprintf("^@.*:%s$", regexp.QuoteMeta(homeserver))), false)
+	}
+
+	return registration.Save(path)
+}
+
+func InitAppservice(deviceID mid.DeviceID) (*mautrix.Client, error) {
+	username := mid.UserID(configuration.Username)
+	_, homeserver, err := username.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid username %s: %w", username, err)
+	}
+
+	appService = appservice.Create()
+	appService.HomeserverURL = configuration.Homeserver
+	appService.HomeserverDomain = homeserver
+	appService.RegistrationPath = configuration.Appservice.RegistrationFile
+	appService.Host.Hostname = configuration.Appservice.Hostname
+	appService.Host.Port = configuration.Appservice.Port
+	appService.LogConfig.Directory = filepath.Join(configuration.GetDataDir(), "logs")
+	if _, err := appService.Init(); err != nil {
+		return nil, err
+	}
+	if appService.Registration.SenderLocalpart == "" {
+		return nil, fmt.Errorf("registration %s has no sender_localpart", configuration.Appservice.RegistrationFile)
+	}
+
+	if err := appService.BotIntent().EnsureRegistered(); err != nil {
+		return nil, err
+	}
+
+	// Log in to get a device for the bot so that it can participate in
+	// encrypted rooms.
+	botClient := appService.BotClient()
+	_, err = DoRetry("appservice login", func() (interface{}, error) {
+		return botClient.Login(&mautrix.ReqLogin{
+			Type: mautrix.AuthTypeAppservice,
+			Identifier: mautrix.UserIdentifier{
+				Type: mautrix.IdentifierTypeUser,
+				User: username.String(),
+			},
+			InitialDeviceDisplayName: "standupbot",
+			DeviceID:                 deviceID,
+			StoreCredentials:         true,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	botClient.Syncer = cryptoSyncer{mautrix.NewDefaultSyncer()}
+	return botClient, nil
+}
+
+// StartAppservice starts receiving transactions from the homeserver and
+// dispatching them to the event handlers. It blocks until the appservice
+// HTTP server is stopped by the shutdown.
+func StartAppservice(registerHandlers func(on func(mevent.Type, func(*mevent.Event)))) {
+	eventProcessor := appservice.NewEventProcessor(appService)
+	registerHandlers(func(eventType mevent.Type, handler func(*mevent.Event)) {
+		eventProcessor.On(eventType, handler)
+	})
+	go eventProcessor.Start()
+
+	// The homeserver does not send to-device events to appservices, so run
+	// a sync loop for the bot device to feed the OlmMachine.
+	go RunSyncLoop(shutdownCtx, "crypto sync")
+
+	appService.Ready = true
+	appService.Start()
+	eventProcessor.Stop()
+}
+
+// puppeting returns whether the bot acts as the given user in the room rather
+// than as itself.
+func puppeting(user *mid.UserID, roomID mid.RoomID) bool {
+	if user == nil || appService == nil || !configuration.Appservice.PuppetUsers || stateStore.IsEncrypted(roomID) {
+		return false
+	}
+	_, homeserver, err := user.Parse()
+	return err == nil && homeserver == appService.HomeserverDomain
+}
+
+// clientFor returns the client to use for acting as the given user in the
+// room. If the bot is puppeting users, this is a client for the user, who is
+// registered and joined to the room first. Otherwise it is the bot's client.
+func clientFor(user *mid.UserID, roomID mid.RoomID) (MatrixClient, error) {
+	if !puppeting(user, roomID) {
+		return matrixClient, nil
+	}
+	intent := appService.Intent(*user)
+	if err := intent.EnsureJoined(roomID); err != nil {
+		return nil, err
+	}
+	return intent.Client, nil
+}
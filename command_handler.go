@@ -1,8 +1,6 @@
 package main
 
 import (
-	"errors"
-	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
@@ -12,8 +10,10 @@ import (
 	log "github.com/sirupsen/logrus"
 	"maunium.net/go/mautrix"
 	mevent "maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
 	mid "maunium.net/go/mautrix/id"
 
+	"github.com/beeper/standupbot/store"
 	"github.com/beeper/standupbot/types"
 )
 
@@ -34,6 +34,12 @@ const (
 	ThreadsFriday
 )
 
+// acceptsItems returns whether the bot is waiting for the user to send items
+// for a section of the post in this state.
+func (s StandupFlowState) acceptsItems() bool {
+	return s >= Yesterday && s <= Notes
+}
+
 type StandupItem struct {
 	EventID       mid.EventID
 	Body          string
@@ -89,7 +95,7 @@ func BlankStandupFlow() *StandupFlow {
 
 func SendReaction(roomId mid.RoomID, eventID mid.EventID, reaction string) (resp *mautrix.RespSendEvent, err error) {
 	r, err := DoRetry("send reaction", func() (interface{}, error) {
-		return client.SendReaction(roomId, eventID, reaction)
+		return matrixClient.SendReaction(roomId, eventID, reaction)
 	})
 	if err != nil {
 		// give up
@@ -99,76 +105,36 @@ func SendReaction(roomId mid.RoomID, eventID mid.EventID, reaction string) (resp
 	return r.(*mautrix.RespSendEvent), err
 }
 
-func SendHelp(roomId mid.RoomID) {
-	// send message to channel confirming join (retry 3 times)
-	noticeText := `COMMANDS:
-* new -- prepare a new standup post
-* show -- show the current standup post
-* edit [Friday|Weekend|Yesterday|Today|Blockers|Notes] -- edit the given section of the standup post
-* cancel -- cancel the current standup post
-* undo -- undo sending the current standup post to the send room
-* help -- show this help
-* vanquish -- tell the bot to leave the room
-* tz [timezone] -- show or set the timezone to use for configuring notifications
-* notify [time]|stop -- show or set the time at which the standup notification will be sent
-* room [room alias or ID] -- show or set the room where your standup notification will be sent
-* threads [true|false] -- whether or not to use threads for composing standup posts
-
-Version %s. Source code: https://gitlab.com/beeper/standupbot/`
-	noticeHtml := `<b>COMMANDS:</b>
-<ul>
-<li><b>new</b> &mdash; prepare a new standup post</li>
-<li><b>show</b> &mdash; show the current standup post</li>
-<li><b>edit [Friday|Weekend|Yesterday|Today|Blockers|Notes]</b> &mdash; edit the given section of the standup post</li>
-<li><b>cancel</b> &mdash; cancel the current standup post</li>
-<li><b>undo</b> &mdash; undo sending the current standup post to the send room</li>
-<li><b>help</b> &mdash; show this help</li>
-<li><b>vanquish</b> &mdash; tell the bot to leave the room</li>
-<li><b>tz [timezone]</b> &mdash; show or set the timezone to use for configuring notifications</li>
-<li><b>notify [time]|stop</b> &mdash; show or set the time at which the standup notification will be sent</li>
-<li><b>room [room alias or ID]</b> &mdash; show or set the room where your standup notification will be sent</li>
-<li><b>threads [true|false]</b> &mdash; whether or not to use threads for composing standup posts</li>
-</ul>
-
-Version %s. <a href="https://gitlab.com/beeper/standupbot/">Source code</a>.`
-
-	SendMessage(roomId, &mevent.MessageEventContent{
-		MsgType:       mevent.MsgNotice,
-		Body:          fmt.Sprintf(noticeText, VERSION),
-		Format:        mevent.FormatHTML,
-		FormattedBody: fmt.Sprintf(noticeHtml, VERSION),
-	})
-}
-
 // Timezone
 func HandleTimezone(roomId mid.RoomID, sender mid.UserID, params []string) {
+	lang := languageFor(sender)
 	stateKey := strings.TrimPrefix(sender.String(), "@")
 	if len(params) == 0 {
-		tzStr := "not set"
+		tzStr := T(lang, "tz.not_set")
 
 		var tzSettingEventContent types.TzSettingEventContent
-		err := client.StateEvent(roomId, types.StateTzSetting, stateKey, &tzSettingEventContent)
+		err := matrixClient.StateEvent(roomId, types.StateTzSetting, stateKey, &tzSettingEventContent)
 		if err == nil {
 			tzStr = tzSettingEventContent.TzString
 		}
 
-		noticeText := fmt.Sprintf("Timezone is set to %s", tzStr)
+		noticeText := T(lang, "tz.show", tzStr)
 		SendMessage(roomId, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: noticeText})
 		return
 	}
 
 	location, err := time.LoadLocation(params[0])
 	if err != nil {
-		errorMessageText := fmt.Sprintf("%s is not a recognized timezone. Use the name corresponding to a file in the IANA Time Zone database, such as 'America/New_York'", params[0])
+		errorMessageText := T(lang, "tz.invalid", params[0])
 		SendMessage(roomId, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: errorMessageText})
 	}
 
-	_, err = client.SendStateEvent(roomId, types.StateTzSetting, stateKey, types.TzSettingEventContent{
+	_, err = matrixClient.SendStateEvent(roomId, types.StateTzSetting, stateKey, types.TzSettingEventContent{
 		TzString: location.String(),
 	})
-	noticeText := fmt.Sprintf("Timezone set to %s", location.String())
+	noticeText := T(lang, "tz.set", location.String())
 	if err != nil {
-		noticeText = fmt.Sprintf("Failed setting timezone: %s\nCheck to make sure that standupbot is a mod/admin in the room!", err)
+		noticeText = T(lang, "tz.failed", err)
 	} else {
 		stateStore.SetTimezone(sender, location.String())
 	}
@@ -177,17 +143,18 @@ func HandleTimezone(roomId mid.RoomID, sender mid.UserID, params []string) {
 
 // Notify
 func HandleNotify(roomId mid.RoomID, sender mid.UserID, params []string) {
+	lang := languageFor(sender)
 	stateKey := strings.TrimPrefix(sender.String(), "@")
 	if len(params) == 0 {
 		var notifyEventContent types.NotifyEventContent
-		err := client.StateEvent(roomId, types.StateNotify, stateKey, &notifyEventContent)
+		err := matrixClient.StateEvent(roomId, types.StateNotify, stateKey, &notifyEventContent)
 		var noticeText string
 		if err != nil || notifyEventContent.MinutesAfterMidnight == nil {
-			noticeText = "Notification time is not set"
+			noticeText = T(lang, "notify.not_set")
 		} else {
 			offset := time.Minute * time.Duration(*notifyEventContent.MinutesAfterMidnight)
 			offset = offset.Round(time.Minute)
-			noticeText = fmt.Sprintf("Notification time is set to %02d:%02d", int(offset.Hours()), int(offset.Minutes())%60)
+			noticeText = T(lang, "notify.show", int(offset.Hours()), int(offset.Minutes())%60)
 		}
 
 		SendMessage(roomId, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: noticeText})
@@ -195,10 +162,10 @@ func HandleNotify(roomId mid.RoomID, sender mid.UserID, params []string) {
 	}
 
 	if params[0] == "stop" {
-		_, err := client.SendStateEvent(roomId, types.StateNotify, stateKey, struct{}{})
-		noticeText := "Notifications successfully disabled"
+		_, err := matrixClient.SendStateEvent(roomId, types.StateNotify, stateKey, struct{}{})
+		noticeText := T(lang, "notify.disabled")
 		if err != nil {
-			noticeText = "Failed to disable notifications"
+			noticeText = T(lang, "notify.disable_failed")
 		}
 		stateStore.RemoveNotify(sender)
 
@@ -206,44 +173,52 @@ func HandleNotify(roomId mid.RoomID, sender mid.UserID, params []string) {
 		return
 	}
 
-	timeRe := regexp.MustCompile(`(\d\d?):?(\d\d)`)
-	groups := timeRe.FindStringSubmatch(params[0])
 	noticeText := ""
-	if groups == nil {
-		noticeText = fmt.Sprintf("%s is not a valid time. Please specify it in 24-hour time like: 13:30.", params[0])
+	if hours, minutes, ok := parseNotifyTime(params[0]); !ok {
+		noticeText = T(lang, "notify.invalid", params[0])
 	} else {
-		hours, hoursErr := strconv.Atoi(groups[1])
-		minutes, minutesErr := strconv.Atoi(groups[2])
-
-		if hoursErr != nil || minutesErr != nil || hours < 0 || hours > 24 || minutes < 0 || minutes > 60 {
-			noticeText = fmt.Sprintf("%s is not a valid time. Please specify it in 24-hour time like: 13:30.", params[0])
+		noticeText = T(lang, "notify.set", hours, minutes)
+		minutesAfterMidnight := minutes + hours*60
+		_, err := matrixClient.SendStateEvent(roomId, types.StateNotify, stateKey, types.NotifyEventContent{
+			MinutesAfterMidnight: &minutesAfterMidnight,
+		})
+		if err != nil {
+			noticeText = T(lang, "notify.failed", err)
 		} else {
-			noticeText = fmt.Sprintf("Notification time set to %02d:%02d", hours, minutes)
-			minutesAfterMidnight := minutes + hours*60
-			_, err := client.SendStateEvent(roomId, types.StateNotify, stateKey, types.NotifyEventContent{
-				MinutesAfterMidnight: &minutesAfterMidnight,
-			})
-			if err != nil {
-				noticeText = fmt.Sprintf("Failed setting notification time: %s\nCheck to make sure that standupbot is a mod/admin in the room!", err)
-			} else {
-				stateStore.SetNotify(sender, minutesAfterMidnight)
-			}
+			stateStore.SetNotify(sender, minutesAfterMidnight)
 		}
 	}
 	SendMessage(roomId, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: noticeText})
 }
 
+var notifyTimeRe = regexp.MustCompile(`(\d\d?):?(\d\d)`)
+
+// parseNotifyTime parses a 24-hour time like 08:00 or 0800.
+func parseNotifyTime(s string) (hours, minutes int, ok bool) {
+	groups := notifyTimeRe.FindStringSubmatch(s)
+	if groups == nil {
+		return 0, 0, false
+	}
+	hours, hoursErr := strconv.Atoi(groups[1])
+	minutes, minutesErr := strconv.Atoi(groups[2])
+	if hoursErr != nil || minutesErr != nil || hours < 0 || hours > 24 || minutes < 0 || minutes > 60 {
+		return 0, 0, false
+	}
+	return hours, minutes, true
+}
+
 // Threads
 func HandleThreads(roomID mid.RoomID, sender mid.UserID, params []string) {
+	lang := languageFor(sender)
 	if len(params) == 0 {
 		useThreads, err := stateStore.GetUseThreads(sender)
 		var noticeText string
 		if err != nil {
-			noticeText = "Use threads setting is not set. Will default to not using threads."
+			noticeText = T(lang, "threads.not_set")
 		} else if useThreads {
-			noticeText = "Using threads is enabled."
+			noticeText = T(lang, "threads.enabled")
 		} else {
-			noticeText = "Using threads is not enabled."
+			noticeText = T(lang, "threads.disabled")
 		}
 
 		SendMessage(roomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: noticeText})
@@ -257,35 +232,111 @@ func HandleThreads(roomID mid.RoomID, sender mid.UserID, params []string) {
 	} else if useThreadsStr == "false" {
 		useThreads = false
 	} else {
-		noticeText := fmt.Sprintf("Failed setting use threads option: %s is not valid. Use 'true' or 'false'.", useThreadsStr)
+		noticeText := T(lang, "threads.invalid", useThreadsStr)
 		SendMessage(roomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: noticeText})
 		return
 	}
 
 	stateKey := strings.TrimPrefix(sender.String(), "@")
-	_, err := client.SendStateEvent(roomID, types.StateUseThreads, stateKey, types.UseThreadsEventContent{
+	_, err := matrixClient.SendStateEvent(roomID, types.StateUseThreads, stateKey, types.UseThreadsEventContent{
 		UseThreads: useThreads,
 	})
 	var noticeText string
 	if err != nil {
-		noticeText = fmt.Sprintf("Failed setting use threads option: %+v", err)
+		noticeText = T(lang, "threads.failed", err)
 	} else {
-		noticeText = fmt.Sprintf("Set use threads option to %s", useThreadsStr)
+		noticeText = T(lang, "threads.set", useThreadsStr)
 		stateStore.SetUseThreads(sender, useThreads)
 	}
 	SendMessage(roomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: noticeText})
 }
 
+// Language
+func HandleLanguage(event *mevent.Event, params []string) {
+	lang := languageFor(event.Sender)
+	if len(params) == 0 {
+		noticeText := T(lang, "lang.show", lang, supportedLanguages())
+		SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: noticeText})
+		return
+	}
+
+	newLang := strings.ToLower(params[0])
+	if !isSupportedLanguage(newLang) {
+		noticeText := T(lang, "lang.invalid", params[0], supportedLanguages())
+		SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: noticeText})
+		return
+	}
+
+	stateKey := strings.TrimPrefix(event.Sender.String(), "@")
+	_, err := matrixClient.SendStateEvent(event.RoomID, types.StateLanguage, stateKey, types.LanguageEventContent{
+		Language: newLang,
+	})
+	var noticeText string
+	if err != nil {
+		noticeText = T(lang, "lang.failed", err)
+	} else {
+		stateStore.SetLanguage(event.Sender, newLang)
+		noticeText = T(newLang, "lang.set", T(newLang, "language.name"))
+	}
+	SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: noticeText})
+}
+
+func HandleRoomLanguage(event *mevent.Event, params []string) {
+	lang := languageFor(event.Sender)
+	sendRoomID, err := stateStore.GetSendRoomId(event.Sender)
+	if err != nil {
+		content := format.RenderMarkdown(T(lang, "roomlang.no_send_room"), true, false)
+		SendMessage(event.RoomID, &content)
+		return
+	}
+
+	if len(params) == 0 {
+		var noticeText string
+		if roomLang := stateStore.GetRoomLanguage(sendRoomID); isSupportedLanguage(roomLang) {
+			noticeText = T(lang, "roomlang.show", sendRoomID, T(roomLang, "language.name"))
+		} else {
+			noticeText = T(lang, "roomlang.not_set", sendRoomID)
+		}
+		SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: noticeText})
+		return
+	}
+
+	newLang := strings.ToLower(params[0])
+	var content interface{} = types.LanguageEventContent{Language: newLang}
+	if newLang == "none" {
+		newLang = ""
+		content = struct{}{}
+	} else if !isSupportedLanguage(newLang) {
+		noticeText := T(lang, "lang.invalid", params[0], supportedLanguages())
+		SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: noticeText})
+		return
+	}
+
+	var noticeText string
+	if _, err := matrixClient.SendStateEvent(sendRoomID, types.StateRoomLanguage, "", content); err != nil {
+		noticeText = T(lang, "roomlang.failed", err)
+	} else {
+		stateStore.SetRoomLanguage(sendRoomID, newLang)
+		if newLang == "" {
+			noticeText = T(lang, "roomlang.cleared", sendRoomID)
+		} else {
+			noticeText = T(lang, "roomlang.set", sendRoomID, T(newLang, "language.name"))
+		}
+	}
+	SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: noticeText})
+}
+
 // Room
 func HandleRoom(roomID mid.RoomID, event *mevent.Event, params []string) {
+	lang := languageFor(event.Sender)
 	stateKey := strings.TrimPrefix(event.Sender.String(), "@")
 	if len(params) == 0 {
 		sendRoomID, err := stateStore.GetSendRoomId(event.Sender)
 		var noticeText string
 		if err != nil {
-			noticeText = "Send room not set"
+			noticeText = T(lang, "room.not_set")
 		} else {
-			noticeText = fmt.Sprintf("Send room is set to %s", sendRoomID)
+			noticeText = T(lang, "room.show", sendRoomID)
 		}
 
 		SendMessage(roomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: noticeText})
@@ -298,21 +349,27 @@ func HandleRoom(roomID mid.RoomID, event *mevent.Event, params []string) {
 		serverName = params[1]
 	}
 
+	if noticeText := checkSendRoom(lang, roomIdToJoin); noticeText != "" {
+		log.Infof("Refusing to use %s as the send room of %s", roomIdToJoin, event.Sender)
+		SendMessage(roomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: noticeText})
+		return
+	}
+
 	log.Info("Joining ", roomIdToJoin)
 	respJoinRoom, err := DoRetry("join room", func() (interface{}, error) {
-		return client.JoinRoom(roomIdToJoin, serverName, nil)
+		return matrixClient.JoinRoom(roomIdToJoin, serverName, nil)
 	})
 	noticeText := ""
 	if err != nil {
-		noticeText = fmt.Sprintf("Could not join room %s: %s", roomIdToJoin, err)
+		noticeText = T(lang, "room.join_failed", roomIdToJoin, err)
 	} else {
 		sendRoomID := respJoinRoom.(*mautrix.RespJoinRoom).RoomID
-		noticeText = fmt.Sprintf("Joined %s and set that as your send room", roomIdToJoin)
-		_, err := client.SendStateEvent(roomID, types.StateSendRoom, stateKey, types.SendRoomEventContent{
+		noticeText = T(lang, "room.joined", roomIdToJoin)
+		_, err := matrixClient.SendStateEvent(roomID, types.StateSendRoom, stateKey, types.SendRoomEventContent{
 			SendRoomID: sendRoomID,
 		})
 		if err != nil {
-			noticeText = fmt.Sprintf("Failed setting send room: %s\nCheck to make sure that standupbot is a mod/admin in the room!", err)
+			noticeText = T(lang, "room.failed", err)
 		} else {
 			stateStore.SetSendRoomId(event.Sender, sendRoomID)
 		}
@@ -321,11 +378,62 @@ func HandleRoom(roomID mid.RoomID, event *mevent.Event, params []string) {
 	SendMessage(roomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: noticeText})
 
 	if currentFlow, found := currentStandupFlows[event.Sender]; found && currentFlow.State == Confirm {
-		client.RedactEvent(event.RoomID, currentFlow.PreviewEventId)
+		matrixClient.RedactEvent(event.RoomID, currentFlow.PreviewEventId)
 		ShowMessagePreview(event.RoomID, event.Sender, currentFlow, false)
 	}
 }
 
+// HandleWeeklyRoom shows or sets the room that the user's weekly summaries
+// are sent to. With none, they are sent to the send room.
+func HandleWeeklyRoom(event *mevent.Event, params []string) {
+	lang := languageFor(event.Sender)
+	stateKey := strings.TrimPrefix(event.Sender.String(), "@")
+	if len(params) == 0 {
+		noticeText := T(lang, "weekly_room.not_set")
+		if weeklyRoomID := stateStore.GetWeeklyRoomId(event.Sender); weeklyRoomID != "" {
+			noticeText = T(lang, "weekly_room.show", weeklyRoomID)
+		}
+		SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: noticeText})
+		return
+	}
+
+	var weeklyRoomID mid.RoomID
+	noticeText := T(lang, "weekly_room.cleared")
+	if !strings.EqualFold(params[0], "none") {
+		roomIdToJoin := params[0]
+		serverName := ""
+		if len(params) > 1 {
+			serverName = params[1]
+		}
+		if noticeText := checkSendRoom(lang, roomIdToJoin); noticeText != "" {
+			log.Infof("Refusing to use %s as the weekly room of %s", roomIdToJoin, event.Sender)
+			SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: noticeText})
+			return
+		}
+
+		log.Info("Joining ", roomIdToJoin)
+		respJoinRoom, err := DoRetry("join room", func() (interface{}, error) {
+			return matrixClient.JoinRoom(roomIdToJoin, serverName, nil)
+		})
+		if err != nil {
+			SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: T(lang, "room.join_failed", roomIdToJoin, err)})
+			return
+		}
+		weeklyRoomID = respJoinRoom.(*mautrix.RespJoinRoom).RoomID
+		noticeText = T(lang, "weekly_room.joined", roomIdToJoin)
+	}
+
+	_, err := matrixClient.SendStateEvent(event.RoomID, types.StateWeeklyRoom, stateKey, types.WeeklyRoomEventContent{
+		WeeklyRoomID: weeklyRoomID,
+	})
+	if err != nil {
+		noticeText = T(lang, "weekly_room.failed", err)
+	} else {
+		stateStore.SetWeeklyRoomId(event.Sender, weeklyRoomID)
+	}
+	SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: noticeText})
+}
+
 func EditPreview(roomID mid.RoomID, userID mid.UserID, flow *StandupFlow) []mid.EventID {
 	newPost := FormatPost(userID, flow, true, true, false)
 	resp, _ := SendMessage(roomID, &mevent.MessageEventContent{
@@ -342,49 +450,6 @@ func EditPreview(roomID mid.RoomID, userID mid.UserID, flow *StandupFlow) []mid.
 	return append(flow.ReactableEvents, resp.EventID)
 }
 
-func getCommandParts(body string) ([]string, error) {
-	userId := mid.UserID(configuration.Username)
-	localpart, _, _ := userId.ParseAndDecode()
-
-	// Valid command strings include:
-	// standupbot: foo
-	// !su foo
-	// !standupbot foo
-	// @standupbot foo
-	// @standupbot: foo
-
-	validCommandRegexes := []*regexp.Regexp{
-		regexp.MustCompile(fmt.Sprintf("^%s:(.*)$", localpart)),
-		regexp.MustCompile(fmt.Sprintf("^@%s:?(.*)$", localpart)),
-		regexp.MustCompile("^!standupbot$"),
-		regexp.MustCompile("^!standupbot:? (.*)$"),
-		regexp.MustCompile("^!su$"),
-		regexp.MustCompile("^!su:? (.*)$"),
-	}
-
-	body = strings.TrimSpace(body)
-
-	isCommand := false
-	commandParts := []string{}
-	for _, commandRe := range validCommandRegexes {
-		match := commandRe.FindStringSubmatch(body)
-		if match != nil {
-			isCommand = true
-			if len(match) > 1 {
-				commandParts = strings.Split(match[1], " ")
-			} else {
-				commandParts = []string{"help"}
-			}
-			break
-		}
-	}
-	if !isCommand {
-		return []string{}, errors.New("Not a command")
-	}
-
-	return commandParts, nil
-}
-
 func tryEditListItem(standupList []StandupItem, editEventID mid.EventID, newContent *mevent.MessageEventContent) bool {
 	for i, item := range standupList {
 		if item.EventID == editEventID {
@@ -416,7 +481,7 @@ func HandleEdit(event *mevent.Event, standupFlow *StandupFlow) {
 		} else if standupFlow.State == Confirm {
 			standupFlow.ReactableEvents = EditPreview(event.RoomID, event.Sender, standupFlow)
 		} else if standupFlow.State == Sent {
-			client.RedactEvent(event.RoomID, standupFlow.PreviewEventId)
+			matrixClient.RedactEvent(event.RoomID, standupFlow.PreviewEventId)
 			ShowMessagePreview(event.RoomID, event.Sender, standupFlow, true)
 		}
 	}
@@ -490,11 +555,12 @@ func HandleReply(event *mevent.Event, standupFlow *StandupFlow) {
 		standupFlow.ReactableEvents = EditPreview(event.RoomID, event.Sender, standupFlow)
 
 		if standupFlow.State == Sent && standupFlow.ResendEventId == nil {
+			sendEdit := T(languageFor(event.Sender), "preview.send_edit", CHECKMARK, RED_X)
 			resp, err := SendMessage(event.RoomID, &mevent.MessageEventContent{
 				MsgType:       mevent.MsgText,
-				Body:          fmt.Sprintf("Send Edit (%s) or Cancel (%s)?", CHECKMARK, RED_X),
+				Body:          sendEdit,
 				Format:        mevent.FormatHTML,
-				FormattedBody: fmt.Sprintf("Send Edit (%s) or Cancel (%s)?", CHECKMARK, RED_X),
+				FormattedBody: sendEdit,
 			})
 			if err != nil {
 				log.Error("Failed to ask user if the want to send an edit")
@@ -508,17 +574,27 @@ func HandleReply(event *mevent.Event, standupFlow *StandupFlow) {
 	}
 }
 
-func HandleMessage(_ mautrix.EventSource, event *mevent.Event) {
+func HandleMessage(event *mevent.Event) {
 	userId := mid.UserID(configuration.Username)
-	if event.Sender == userId {
+	if event.Sender == userId || !canUseBot(event.Sender) {
 		return
 	}
 
 	messageEventContent := event.Content.AsMessage()
 
-	commandParts, err := getCommandParts(messageEventContent.Body)
+	commandBody, commandParts, err := getCommandParts(&event.Content)
+	if err == ErrNotCommand {
+		commandBody, commandParts, err = getPrefixlessCommandParts(event)
+	}
 
-	if err != nil {
+	if err != nil && err != ErrNotCommand {
+		defer matrixClient.MarkRead(event.RoomID, event.ID)
+		SendMessage(event.RoomID, &mevent.MessageEventContent{
+			MsgType: mevent.MsgNotice,
+			Body:    T(languageFor(event.Sender), "command.parse_failed", err),
+		})
+		return
+	} else if err != nil {
 		// This message is not a command.
 		if stateStore.GetConfigRoomId(event.Sender) != event.RoomID {
 			// Ignore non-command messages if not in config room.
@@ -527,7 +603,7 @@ func HandleMessage(_ mautrix.EventSource, event *mevent.Event) {
 
 		if val, found := currentStandupFlows[event.Sender]; found {
 			// Mark the message as read after we've handled it.
-			defer client.MarkRead(event.RoomID, event.ID)
+			defer matrixClient.MarkRead(event.RoomID, event.ID)
 
 			// Handle edits and thread replies
 			relatesTo := messageEventContent.RelatesTo
@@ -577,137 +653,138 @@ func HandleMessage(_ mautrix.EventSource, event *mevent.Event) {
 	}
 
 	// Mark the message as read after we've handled it.
-	defer client.MarkRead(event.RoomID, event.ID)
+	defer matrixClient.MarkRead(event.RoomID, event.ID)
 
 	stateStore.SetConfigRoom(event.Sender, event.RoomID)
 
-	switch strings.ToLower(commandParts[0]) {
-	case "vanquish":
-		DoRetry("leave room", func() (interface{}, error) {
-			return client.LeaveRoom(event.RoomID)
+	HandleCommand(event, commandBody, commandParts)
+}
+
+func HandleVanquish(event *mevent.Event, args []string) {
+	DoRetry("leave room", func() (interface{}, error) {
+		return matrixClient.LeaveRoom(event.RoomID)
+	})
+}
+
+func HandleNew(event *mevent.Event, args []string) {
+	currentStandupFlows[event.Sender] = BlankStandupFlow()
+	CreatePost(event.RoomID, event.Sender)
+}
+
+func HandleShow(event *mevent.Event, args []string) {
+	if currentFlow, found := currentStandupFlows[event.Sender]; found && currentFlow.State != FlowNotStarted {
+		SendMessage(event.RoomID, FormatPost(event.Sender, currentFlow, true, false, false))
+	} else {
+		SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgText, Body: T(languageFor(event.Sender), "show.nothing")})
+	}
+}
+
+func HandleEditCommand(event *mevent.Event, args []string) {
+	lang := languageFor(event.Sender)
+	if useThreads, _ := stateStore.GetUseThreads(event.Sender); useThreads {
+		SendMessage(event.RoomID, &mevent.MessageEventContent{
+			MsgType: mevent.MsgNotice,
+			Body:    T(lang, "edit.threads"),
 		})
-		break
-	case "tz":
-		HandleTimezone(event.RoomID, event.Sender, commandParts[1:])
-		break
-	case "notify":
-		HandleNotify(event.RoomID, event.Sender, commandParts[1:])
-		break
-	case "threads":
-		HandleThreads(event.RoomID, event.Sender, commandParts[1:])
-		break
-	case "new":
-		currentStandupFlows[event.Sender] = BlankStandupFlow()
-		CreatePost(event.RoomID, event.Sender)
-		break
-	case "show":
-		if currentFlow, found := currentStandupFlows[event.Sender]; found && currentFlow.State != FlowNotStarted {
-			SendMessage(event.RoomID, FormatPost(event.Sender, currentFlow, true, false, false))
-		} else {
-			SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgText, Body: "No standup post to show."})
-		}
-		break
-	case "edit":
-		if useThreads, _ := stateStore.GetUseThreads(event.Sender); useThreads {
-			SendMessage(event.RoomID, &mevent.MessageEventContent{
-				MsgType: mevent.MsgNotice,
-				Body:    fmt.Sprintf("You cannot use !edit when using threads. Just reply to the corresponding thread."),
-			})
+		return
+	}
+	if currentFlow, found := currentStandupFlows[event.Sender]; !found || currentFlow.State == FlowNotStarted {
+		SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: T(lang, "edit.nothing")})
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "friday":
+		if stateStore.GetCurrentWeekdayInUserTimezone(event.Sender) != time.Monday {
+			SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: T(lang, "edit.not_monday")})
 			return
 		}
-		if len(commandParts) != 2 {
-			SendMessage(event.RoomID, &mevent.MessageEventContent{
-				MsgType: mevent.MsgNotice,
-				Body:    fmt.Sprintf("Invalid item to edit! Must be one of Friday, Weekend, Yesterday, Today, Blockers, or Notes"),
-			})
+		GoToStateAndNotify(event.RoomID, event.Sender, Friday)
+		break
+	case "weekend":
+		if stateStore.GetCurrentWeekdayInUserTimezone(event.Sender) != time.Monday {
+			SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: T(lang, "edit.not_monday_wkend")})
 			return
 		}
-		if currentFlow, found := currentStandupFlows[event.Sender]; !found || currentFlow.State == FlowNotStarted {
-			SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: "No standup post to edit."})
-		}
-
-		switch strings.ToLower(commandParts[1]) {
-		case "friday":
-			if stateStore.GetCurrentWeekdayInUserTimezone(event.Sender) != time.Monday {
-				SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: "It's not Monday, so you can't go back to edit Friday."})
-				return
-			}
-			GoToStateAndNotify(event.RoomID, event.Sender, Friday)
-			break
-		case "weekend":
-			if stateStore.GetCurrentWeekdayInUserTimezone(event.Sender) != time.Monday {
-				SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: "It's not Monday, so you can't go back to edit the weekend."})
-				return
-			}
-			GoToStateAndNotify(event.RoomID, event.Sender, Weekend)
-			break
-		case "yesterday":
-			if stateStore.GetCurrentWeekdayInUserTimezone(event.Sender) == time.Monday {
-				SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: "It's Monday, so you can't go back to edit yesterday. Edit Friday or Weekend instead."})
-				return
-			}
-			GoToStateAndNotify(event.RoomID, event.Sender, Yesterday)
-			break
-		case "today":
-			GoToStateAndNotify(event.RoomID, event.Sender, Today)
-			break
-		case "blockers":
-			GoToStateAndNotify(event.RoomID, event.Sender, Blockers)
-			break
-		case "notes":
-			GoToStateAndNotify(event.RoomID, event.Sender, Notes)
-			break
-		}
+		GoToStateAndNotify(event.RoomID, event.Sender, Weekend)
 		break
-	case "undo":
-		if val, found := currentStandupFlows[event.Sender]; !found || val.State != Sent {
-			SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: "No sent standup post to undo."})
-		} else {
-			sendRoomID, err := stateStore.GetSendRoomId(event.Sender)
-			if err != nil {
-				log.Debugf("No send room configured for %s, can't undo anything", event.Sender)
-				SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: "No send room configured. Can't undo anything."})
-			}
-
-			stateKey := strings.TrimPrefix(event.Sender.String(), "@")
-			var previousPostEventContent PreviousPostEventContent
-			err = client.StateEvent(event.RoomID, StatePreviousPost, stateKey, &previousPostEventContent)
-			if err != nil {
-				log.Debug("Couldn't find previous post info.")
-				SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: "No previous standup post to undo."})
-			}
-			_, err = client.RedactEvent(sendRoomID, previousPostEventContent.EditEventID)
-			if err != nil {
-				SendMessage(event.RoomID, &mevent.MessageEventContent{Body: "Failed to redact the standup post!"})
-			} else {
-				SendMessage(event.RoomID, &mevent.MessageEventContent{
-					Body: fmt.Sprintf("Redacted standup post with ID: %s in %s", previousPostEventContent.EditEventID, event.RoomID),
-				})
-				currentStandupFlows[event.Sender].State = Confirm
-				client.SendStateEvent(event.RoomID, StatePreviousPost, stateKey, struct{}{})
-			}
+	case "yesterday":
+		if stateStore.GetCurrentWeekdayInUserTimezone(event.Sender) == time.Monday {
+			SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: T(lang, "edit.monday")})
+			return
 		}
+		GoToStateAndNotify(event.RoomID, event.Sender, Yesterday)
 		break
-	case "cancel":
-		if val, found := currentStandupFlows[event.Sender]; !found || val.State == FlowNotStarted {
-			SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: "No standup post to cancel."})
-		} else {
-			currentStandupFlows[event.Sender] = BlankStandupFlow()
-			SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: "Standup post cancelled"})
-		}
+	case "today":
+		GoToStateAndNotify(event.RoomID, event.Sender, Today)
 		break
-	case "room":
-		HandleRoom(event.RoomID, event, commandParts[1:])
+	case "blockers":
+		GoToStateAndNotify(event.RoomID, event.Sender, Blockers)
 		break
-	default:
-		SendHelp(event.RoomID)
+	case "notes":
+		GoToStateAndNotify(event.RoomID, event.Sender, Notes)
 		break
 	}
 }
 
-func HandleRedaction(_ mautrix.EventSource, event *mevent.Event) {
+func HandleUndo(event *mevent.Event, args []string) {
+	lang := languageFor(event.Sender)
+	if val, found := currentStandupFlows[event.Sender]; !found || val.State != Sent {
+		SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: T(lang, "undo.nothing")})
+		return
+	}
+
+	sendRoomID, err := stateStore.GetSendRoomId(event.Sender)
+	if err != nil {
+		log.Debugf("No send room configured for %s, can't undo anything", event.Sender)
+		SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: T(lang, "undo.no_send_room")})
+	}
+
+	stateKey := strings.TrimPrefix(event.Sender.String(), "@")
+	var previousPostEventContent PreviousPostEventContent
+	err = matrixClient.StateEvent(event.RoomID, types.StatePreviousPost, stateKey, &previousPostEventContent)
+	if err != nil {
+		log.Debug("Couldn't find previous post info.")
+		SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: T(lang, "undo.no_previous")})
+	}
+	sender, err := clientFor(&event.Sender, sendRoomID)
+	if err == nil {
+		_, err = sender.RedactEvent(sendRoomID, previousPostEventContent.EditEventID)
+	}
+	if err != nil {
+		SendMessage(event.RoomID, &mevent.MessageEventContent{Body: T(lang, "undo.failed")})
+	} else {
+		SendMessage(event.RoomID, &mevent.MessageEventContent{
+			Body: T(lang, "undo.done", previousPostEventContent.EditEventID, event.RoomID),
+		})
+		currentStandupFlows[event.Sender].State = Confirm
+		matrixClient.SendStateEvent(event.RoomID, types.StatePreviousPost, stateKey, struct{}{})
+		if err := stateStore.RemovePost(event.Sender, previousPostEventContent.FlowID.String()); err != nil {
+			log.Errorf("Failed to remove the post of %s from the post history: %+v", event.Sender, err)
+		}
+	}
+}
+
+func HandleWeekly(event *mevent.Event, args []string) {
+	ShowWeeklySummary(event.Sender, event.RoomID, store.Now(), true)
+}
+
+func HandleCancel(event *mevent.Event, args []string) {
+	lang := languageFor(event.Sender)
+	if val, found := currentStandupFlows[event.Sender]; !found || val.State == FlowNotStarted {
+		SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: T(lang, "cancel.nothing")})
+	} else {
+		currentStandupFlows[event.Sender] = BlankStandupFlow()
+		SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: T(lang, "cancel.done")})
+	}
+}
+
+func HandleRedaction(event *mevent.Event) {
+	if !canUseBot(event.Sender) {
+		return
+	}
+
 	// Mark the redaction as read after we've handled it.
-	defer client.MarkRead(event.RoomID, event.ID)
+	defer matrixClient.MarkRead(event.RoomID, event.ID)
 
 	// Handle redactions
 	if val, found := currentStandupFlows[event.Sender]; found {
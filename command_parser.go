@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+)
+
+// The command prefixes that are used if CommandPrefixes is not configured.
+var defaultCommandPrefixes = []string{"!standupbot", "!su"}
+
+// ErrNotCommand is returned by getCommandParts if the message is not a bot
+// command.
+var ErrNotCommand = errors.New("not a command")
+
+var (
+	// commandPatterns match messages that start with a command prefix or with
+	// the bot's name. The first submatch is the command and its arguments.
+	commandPatterns []*regexp.Regexp
+	// pillPattern matches an HTML mention of the bot at the start of the
+	// formatted body. The first submatch is the text of the mention.
+	pillPattern *regexp.Regexp
+	// mentionPattern matches a plain text mention at the start of the body,
+	// such as "Standup Bot: ".
+	mentionPattern = regexp.MustCompile(`^([^:\n]+):\s*`)
+)
+
+// CompileCommandPatterns compiles the patterns that are used to recognise
+// commands. It must be called after the configuration is loaded, and again
+// after it is reloaded.
+func CompileCommandPatterns() {
+	config := currentConfiguration()
+	userID := mid.UserID(config.Username)
+	localpart, server, _ := userID.ParseAndDecode()
+	quotedLocalpart := regexp.QuoteMeta(localpart)
+
+	// Valid command strings include:
+	// standupbot: foo
+	// !su foo
+	// !standupbot foo
+	// @standupbot foo
+	// @standupbot:example.com: foo
+	patterns := []*regexp.Regexp{
+		regexp.MustCompile(fmt.Sprintf(`(?s)^%s:\s*(.*)$`, quotedLocalpart)),
+		regexp.MustCompile(fmt.Sprintf(`(?s)^@%s(?::%s)?:?(?:\s+(.*))?$`, quotedLocalpart, regexp.QuoteMeta(server))),
+	}
+	for _, prefix := range config.GetCommandPrefixes() {
+		patterns = append(patterns,
+			regexp.MustCompile(fmt.Sprintf(`(?s)^%s(?::?\s+(.*))?$`, regexp.QuoteMeta(prefix))))
+	}
+
+	// Clients escape the user ID in the link to varying degrees.
+	userIDPatterns := []string{
+		regexp.QuoteMeta(userID.String()),
+		regexp.QuoteMeta(url.PathEscape(userID.String())),
+		regexp.QuoteMeta(url.QueryEscape(userID.String())),
+	}
+	pill := regexp.MustCompile(fmt.Sprintf(`(?is)^\s*<a\s+href=["']https://matrix\.to/#/(?:%s)(?:\?[^"']*)?["'][^>]*>(.*?)</a>`,
+		strings.Join(userIDPatterns, "|")))
+
+	configLock.Lock()
+	defer configLock.Unlock()
+	commandPatterns, pillPattern = patterns, pill
+}
+
+// botMentioned returns whether the bot is one of the users in the m.mentions
+// of the message.
+func botMentioned(content *mevent.Content) bool {
+	mentions, ok := content.Raw["m.mentions"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	userIDs, ok := mentions["user_ids"].([]interface{})
+	if !ok {
+		return false
+	}
+	for _, userID := range userIDs {
+		if userID == configuration.Username {
+			return true
+		}
+	}
+	return false
+}
+
+// stripMention removes a mention of the bot from the start of the message
+// body. It returns false if the message does not start with a mention of the
+// bot.
+func stripMention(content *mevent.Content) (string, bool) {
+	messageEventContent := content.AsMessage()
+	body := strings.TrimSpace(messageEventContent.Body)
+
+	configLock.RLock()
+	pill := pillPattern
+	configLock.RUnlock()
+	if messageEventContent.Format == mevent.FormatHTML {
+		if match := pill.FindStringSubmatch(messageEventContent.FormattedBody); match != nil {
+			// The body contains the text of the pill instead of the link.
+			pillText := strings.TrimSpace(html.UnescapeString(match[1]))
+			if strings.HasPrefix(body, pillText) {
+				return strings.TrimLeft(strings.TrimPrefix(strings.TrimPrefix(body, pillText), ":"), " \t\n"), true
+			}
+		}
+	}
+
+	if botMentioned(content) {
+		if match := mentionPattern.FindStringSubmatch(body); match != nil {
+			return body[len(match[0]):], true
+		}
+	}
+	return "", false
+}
+
+// getCommandParts returns the text of the command and the command and its
+// arguments if the message is a command for the bot, or ErrNotCommand if it is
+// not.
+func getCommandParts(content *mevent.Content) (string, []string, error) {
+	commandBody, mentioned := stripMention(content)
+	if !mentioned {
+		body := strings.TrimSpace(content.AsMessage().Body)
+		isCommand := false
+		configLock.RLock()
+		patterns := commandPatterns
+		configLock.RUnlock()
+		for _, commandRe := range patterns {
+			if match := commandRe.FindStringSubmatch(body); match != nil {
+				commandBody = match[1]
+				isCommand = true
+				break
+			}
+		}
+		if !isCommand {
+			return "", nil, ErrNotCommand
+		}
+	}
+
+	commandParts, err := splitArguments(commandBody)
+	if err != nil {
+		return "", nil, err
+	} else if len(commandParts) == 0 {
+		return "help", []string{"help"}, nil
+	}
+	return commandBody, commandParts, nil
+}
+
+// getPrefixlessCommandParts returns the command and its arguments if the
+// message is a command without a prefix, which is only allowed in the user's
+// config room while the bot is not waiting for standup items.
+func getPrefixlessCommandParts(event *mevent.Event) (string, []string, error) {
+	content := event.Content.AsMessage()
+	if content.RelatesTo != nil || stateStore.GetConfigRoomId(event.Sender) != event.RoomID {
+		return "", nil, ErrNotCommand
+	}
+	if flow, found := currentStandupFlows[event.Sender]; found && flow.State.acceptsItems() {
+		return "", nil, ErrNotCommand
+	}
+
+	commandParts, err := splitArguments(content.Body)
+	if err != nil || len(commandParts) == 0 || findCommand(commandParts[0]) == nil {
+		return "", nil, ErrNotCommand
+	}
+	return content.Body, commandParts, nil
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+)
+
+// CommandArgument describes one argument of a command.
+type CommandArgument struct {
+	Name     string
+	Optional bool
+	// If set, the argument must be one of these values (case-insensitive).
+	Choices []string
+	// If set, the argument is the rest of the command as it was written,
+	// including its whitespace and quotes. It must be the last argument.
+	Rest bool
+}
+
+func (a CommandArgument) String() string {
+	text := a.Name
+	if len(a.Choices) > 0 {
+		text = strings.Join(a.Choices, "|")
+	}
+	if a.Rest {
+		text += "..."
+	}
+	if a.Optional {
+		return "[" + text + "]"
+	}
+	return "<" + text + ">"
+}
+
+// Command is a bot command that can be run using `!su <name> [arguments]`.
+type Command struct {
+	Name    string
+	Aliases []string
+	Args    []CommandArgument
+	// The message keys of the description and of the details that are shown
+	// in addition to the description by `!su help <name>`.
+	Description string
+	Details     string
+	Handler     func(event *mevent.Event, args []string)
+
+	// Whether the command is only available to the admins in the
+	// configuration.
+	AdminOnly bool
+	// If set, the command is a group and its first argument is the name of
+	// one of these subcommands.
+	Subcommands []*Command
+	parent      *Command
+}
+
+// Usage returns the command name followed by its arguments.
+func (c *Command) Usage() string {
+	usage := []string{c.Name}
+	for parent := c.parent; parent != nil; parent = parent.parent {
+		usage = append([]string{parent.Name}, usage...)
+	}
+	if len(c.Subcommands) > 0 {
+		usage = append(usage, "<subcommand>")
+	}
+	for _, arg := range c.Args {
+		usage = append(usage, arg.String())
+	}
+	return strings.Join(usage, " ")
+}
+
+// availableTo returns whether the user is allowed to use the command.
+func (c *Command) availableTo(userID mid.UserID) bool {
+	config := currentConfiguration()
+	for command := c; command != nil; command = command.parent {
+		if command.AdminOnly && !config.IsAdmin(userID) {
+			return false
+		}
+	}
+	return true
+}
+
+// joinRest replaces the arguments that belong to the last argument of the
+// command with the rest of the command body if it is a Rest argument.
+func (c *Command) joinRest(commandBody string, commandParts []string, args []string) []string {
+	if len(c.Args) == 0 || !c.Args[len(c.Args)-1].Rest || len(args) < len(c.Args) {
+		return args
+	}
+	last := len(c.Args) - 1
+	// The arguments are at the end of the command parts.
+	start := argumentOffsets(commandBody)[len(commandParts)-len(args)+last]
+	return append(args[:last:last], strings.TrimSpace(commandBody[start:]))
+}
+
+// checkArgs returns an error describing the problem if the arguments do not
+// match the argument spec of the command.
+func (c *Command) checkArgs(lang string, args []string) error {
+	required := 0
+	for _, arg := range c.Args {
+		if !arg.Optional {
+			required++
+		}
+	}
+	if len(args) < required {
+		return errors.New(T(lang, "args.not_enough"))
+	} else if len(args) > len(c.Args) && (len(c.Args) == 0 || !c.Args[len(c.Args)-1].Rest) {
+		return errors.New(T(lang, "args.too_many"))
+	}
+
+	for i, value := range args {
+		choices := c.Args[i].Choices
+		if len(choices) == 0 {
+			continue
+		}
+		valid := false
+		for _, choice := range choices {
+			if strings.EqualFold(value, choice) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return errors.New(T(lang, "args.invalid_choice", value, strings.Join(choices, ", ")))
+		}
+	}
+	return nil
+}
+
+// commands is the list of commands in the order that they are shown in the
+// help. It is populated in init to avoid an initialization cycle with the
+// help command.
+var commands []*Command
+
+func init() {
+	commands = []*Command{
+		{
+			Name:        "new",
+			Description: "command.new",
+			Handler:     HandleNew,
+		},
+		{
+			Name:        "show",
+			Description: "command.show",
+			Handler:     HandleShow,
+		},
+		{
+			Name: "edit",
+			Args: []CommandArgument{
+				{Name: "section", Choices: []string{"Friday", "Weekend", "Yesterday", "Today", "Blockers", "Notes"}},
+			},
+			Description: "command.edit",
+			Details:     "command.edit.details",
+			Handler:     HandleEditCommand,
+		},
+		{
+			Name:        "cancel",
+			Description: "command.cancel",
+			Handler:     HandleCancel,
+		},
+		{
+			Name:        "undo",
+			Description: "command.undo",
+			Handler:     HandleUndo,
+		},
+		{
+			Name:        "help",
+			Args:        []CommandArgument{{Name: "command", Optional: true}},
+			Description: "command.help",
+			Details:     "command.help.details",
+			Handler:     HandleHelp,
+		},
+		{
+			Name:        "vanquish",
+			Aliases:     []string{"leave"},
+			Description: "command.vanquish",
+			Handler:     HandleVanquish,
+		},
+		{
+			Name:        "tz",
+			Aliases:     []string{"timezone"},
+			Args:        []CommandArgument{{Name: "timezone", Optional: true}},
+			Description: "command.tz",
+			Details:     "command.tz.details",
+			Handler: func(event *mevent.Event, args []string) {
+				HandleTimezone(event.RoomID, event.Sender, args)
+			},
+		},
+		{
+			Name:        "notify",
+			Args:        []CommandArgument{{Name: "time|stop", Optional: true}},
+			Description: "command.notify",
+			Details:     "command.notify.details",
+			Handler: func(event *mevent.Event, args []string) {
+				HandleNotify(event.RoomID, event.Sender, args)
+			},
+		},
+		{
+			Name:        "room",
+			Args:        []CommandArgument{{Name: "room alias or ID", Optional: true}, {Name: "server name", Optional: true}},
+			Description: "command.room",
+			Details:     "command.room.details",
+			Handler: func(event *mevent.Event, args []string) {
+				HandleRoom(event.RoomID, event, args)
+			},
+		},
+		{
+			Name:        "lang",
+			Aliases:     []string{"language"},
+			Args:        []CommandArgument{{Name: "language code", Optional: true}},
+			Description: "command.lang",
+			Details:     "command.lang.details",
+			Handler:     HandleLanguage,
+		},
+		{
+			Name:        "roomlang",
+			Args:        []CommandArgument{{Name: "language code|none", Optional: true}},
+			Description: "command.roomlang",
+			Details:     "command.roomlang.details",
+			Handler:     HandleRoomLanguage,
+		},
+		{
+			Name:        "threads",
+			Args:        []CommandArgument{{Name: "enabled", Optional: true, Choices: []string{"true", "false"}}},
+			Description: "command.threads",
+			Handler: func(event *mevent.Event, args []string) {
+				HandleThreads(event.RoomID, event.Sender, args)
+			},
+		},
+		{
+			Name:        "weekly",
+			Description: "command.weekly",
+			Details:     "command.weekly.details",
+			Handler:     HandleWeekly,
+		},
+		{
+			Name:        "weeklyroom",
+			Args:        []CommandArgument{{Name: "room alias or ID|none", Optional: true}, {Name: "server name", Optional: true}},
+			Description: "command.weeklyroom",
+			Details:     "command.weeklyroom.details",
+			Handler:     HandleWeeklyRoom,
+		},
+		adminCommand,
+	}
+	setParents(commands, nil)
+}
+
+func setParents(commands []*Command, parent *Command) {
+	for _, command := range commands {
+		command.parent = parent
+		setParents(command.Subcommands, command)
+	}
+}
+
+// findCommand returns the top-level command with the given name or alias.
+func findCommand(name string) *Command {
+	return findIn(commands, name)
+}
+
+// findIn returns the command with the given name or alias in the list.
+func findIn(commands []*Command, name string) *Command {
+	name = strings.ToLower(name)
+	for _, command := range commands {
+		if command.Name == name {
+			return command
+		}
+		for _, alias := range command.Aliases {
+			if alias == name {
+				return command
+			}
+		}
+	}
+	return nil
+}
+
+// HandleCommand runs the command with the given name and arguments.
+// commandParts are the arguments that commandBody was split into.
+func HandleCommand(event *mevent.Event, commandBody string, commandParts []string) {
+	lang := languageFor(event.Sender)
+	command, args := resolveCommand(event, lang, commands, commandParts)
+	if command == nil {
+		return
+	}
+
+	args = command.joinRest(commandBody, commandParts, args)
+	if err := command.checkArgs(lang, args); err != nil {
+		SendMessage(event.RoomID, &mevent.MessageEventContent{
+			MsgType: mevent.MsgNotice,
+			Body:    fmt.Sprintf("%s\n%s: !su %s", T(lang, "command.invalid_args", command.Name, err), T(lang, "help.usage"), command.Usage()),
+		})
+		return
+	}
+	command.Handler(event, args)
+}
+
+// resolveCommand finds the command for the command parts, descending into
+// command groups, and returns it with its arguments. If there is no such
+// command or the user is not allowed to use it, it tells the user and returns
+// nil.
+func resolveCommand(event *mevent.Event, lang string, available []*Command, commandParts []string) (*Command, []string) {
+	command := findIn(available, commandParts[0])
+	if command == nil || !command.availableTo(event.Sender) {
+		noticeText := T(lang, "command.unknown", commandParts[0])
+		if suggestion := suggestCommand(event.Sender, available, commandParts[0]); suggestion != "" {
+			noticeText += " " + T(lang, "command.did_you_mean", suggestion)
+		}
+		noticeText += " " + T(lang, "command.see_help")
+		SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: noticeText})
+		return nil, nil
+	}
+
+	args := commandParts[1:]
+	if len(command.Subcommands) == 0 {
+		return command, args
+	} else if len(args) == 0 {
+		SendCommandHelp(event.RoomID, lang, command)
+		return nil, nil
+	}
+	return resolveCommand(event, lang, command.Subcommands, args)
+}
+
+// suggestCommand returns the full name of the command in the list that is
+// closest to the given name, or an empty string if none of them are close.
+func suggestCommand(userID mid.UserID, commands []*Command, name string) string {
+	name = strings.ToLower(name)
+	suggestion := ""
+	bestDistance := 3
+	for _, command := range commands {
+		if !command.availableTo(userID) {
+			continue
+		}
+		for _, candidate := range append([]string{command.Name}, command.Aliases...) {
+			if distance := editDistance(name, candidate); distance < bestDistance {
+				suggestion = command.fullName()
+				bestDistance = distance
+			}
+		}
+	}
+	return suggestion
+}
+
+// fullName returns the name of the command prefixed with the names of the
+// groups that it is in.
+func (c *Command) fullName() string {
+	if c.parent == nil {
+		return c.Name
+	}
+	return c.parent.fullName() + " " + c.Name
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	previous := make([]int, len(br)+1)
+	current := make([]int, len(br)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		current[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(br)]
+}
+
+func minInt(values ...int) int {
+	result := values[0]
+	for _, value := range values[1:] {
+		if value < result {
+			result = value
+		}
+	}
+	return result
+}
+
+// splitArguments splits a command into arguments on whitespace. Arguments can
+// be quoted with single or double quotes to include whitespace, and a
+// backslash escapes the next character.
+func splitArguments(command string) ([]string, error) {
+	args, _, err := splitArgumentsAt(command)
+	return args, err
+}
+
+// argumentOffsets returns the byte offsets in the command at which the
+// arguments start.
+func argumentOffsets(command string) []int {
+	_, offsets, _ := splitArgumentsAt(command)
+	return offsets
+}
+
+func splitArgumentsAt(command string) ([]string, []int, error) {
+	args := make([]string, 0)
+	offsets := make([]int, 0)
+	var current strings.Builder
+	inArg := false
+	escaped := false
+	var quote rune
+	for i, r := range command {
+		if !inArg && !unicode.IsSpace(r) {
+			offsets = append(offsets, i)
+		}
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, nil, fmt.Errorf("missing closing %c", quote)
+	}
+	if escaped {
+		current.WriteRune('\\')
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, offsets, nil
+}
+
+func HandleHelp(event *mevent.Event, args []string) {
+	lang := languageFor(event.Sender)
+	if len(args) == 0 {
+		SendHelp(event.RoomID, event.Sender)
+		return
+	}
+
+	available := commands
+	var command *Command
+	for _, name := range args {
+		command = findIn(available, name)
+		if command == nil || !command.availableTo(event.Sender) {
+			noticeText := T(lang, "command.unknown", name)
+			if suggestion := suggestCommand(event.Sender, available, name); suggestion != "" {
+				noticeText += " " + T(lang, "command.did_you_mean", suggestion)
+			}
+			SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: noticeText})
+			return
+		}
+		available = command.Subcommands
+	}
+	SendCommandHelp(event.RoomID, lang, command)
+}
+
+// SendCommandHelp sends the detailed usage of the command, including its
+// subcommands if it is a group.
+func SendCommandHelp(roomID mid.RoomID, lang string, command *Command) {
+	description := capitalize(T(lang, command.Description)) + "."
+	noticeText := fmt.Sprintf("%s: !su %s\n\n%s", T(lang, "help.usage"), command.Usage(), description)
+	noticeHtml := fmt.Sprintf("<b>%s:</b> <code>!su %s</code><br><br>%s", T(lang, "help.usage"), html.EscapeString(command.Usage()), html.EscapeString(description))
+	if command.Details != "" {
+		noticeText += " " + T(lang, command.Details)
+		noticeHtml += " " + html.EscapeString(T(lang, command.Details))
+	}
+	if len(command.Subcommands) > 0 {
+		plain, formatted := formatCommandList(lang, command.Subcommands)
+		noticeText += "\n\n" + plain
+		noticeHtml += "<br>" + formatted
+	}
+	if len(command.Aliases) > 0 {
+		aliases := strings.Join(command.Aliases, ", ")
+		noticeText += fmt.Sprintf("\n\n%s: %s", T(lang, "help.aliases"), aliases)
+		noticeHtml += fmt.Sprintf("<br><br><b>%s:</b> %s", T(lang, "help.aliases"), html.EscapeString(aliases))
+	}
+	SendMessage(roomID, &mevent.MessageEventContent{
+		MsgType:       mevent.MsgNotice,
+		Body:          noticeText,
+		Format:        mevent.FormatHTML,
+		FormattedBody: noticeHtml,
+	})
+}
+
+// formatCommandList returns the list of commands with their descriptions as
+// plain text and HTML.
+func formatCommandList(lang string, commands []*Command) (string, string) {
+	plain := ""
+	formatted := "<ul>\n"
+	for _, command := range commands {
+		plain += fmt.Sprintf("* %s -- %s\n", command.Usage(), T(lang, command.Description))
+		formatted += fmt.Sprintf("<li><b>%s</b> &mdash; %s</li>\n", html.EscapeString(command.Usage()), html.EscapeString(T(lang, command.Description)))
+	}
+	return plain, formatted + "</ul>\n"
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	return strings.ToUpper(string(r[0])) + string(r[1:])
+}
+
+func SendHelp(roomId mid.RoomID, userID mid.UserID) {
+	lang := languageFor(userID)
+	available := make([]*Command, 0, len(commands))
+	for _, command := range commands {
+		if command.availableTo(userID) {
+			available = append(available, command)
+		}
+	}
+	plain, formatted := formatCommandList(lang, available)
+	noticeText := T(lang, "help.commands") + "\n" + plain
+	noticeHtml := "<b>" + T(lang, "help.commands") + "</b>\n" + formatted
+	noticeText += fmt.Sprintf("\n%s\n\n%s %s: https://gitlab.com/beeper/standupbot/",
+		T(lang, "help.usage_hint", "help [command]"), T(lang, "help.version", VERSION), T(lang, "help.source_code"))
+	noticeHtml += fmt.Sprintf("\n%s<br><br>%s <a href=\"https://gitlab.com/beeper/standupbot/\">%s</a>.",
+		T(lang, "help.usage_hint", "<b>help [command]</b>"), T(lang, "help.version", VERSION), T(lang, "help.source_code"))
+
+	SendMessage(roomId, &mevent.MessageEventContent{
+		MsgType:       mevent.MsgNotice,
+		Body:          noticeText,
+		Format:        mevent.FormatHTML,
+		FormattedBody: noticeHtml,
+	})
+}
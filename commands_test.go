@@ -0,0 +1,167 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	mevent "maunium.net/go/mautrix/event"
+)
+
+func TestSplitArguments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"", []string{}},
+		{"new", []string{"new"}},
+		{"  room   !abc:example.com  ", []string{"room", "!abc:example.com"}},
+		{`tz "America/New York"`, []string{"tz", "America/New York"}},
+		{`room 'it''s'`, []string{"room", "its"}},
+		{`room it\'s`, []string{"room", "it's"}},
+		{`room ""`, []string{"room", ""}},
+	}
+	for _, test := range tests {
+		args, err := splitArguments(test.input)
+		if err != nil {
+			t.Errorf("splitArguments(%q) failed: %v", test.input, err)
+		} else if !reflect.DeepEqual(args, test.expected) {
+			t.Errorf("splitArguments(%q) = %q, expected %q", test.input, args, test.expected)
+		}
+	}
+
+	if _, err := splitArguments(`tz "America/New_York`); err == nil {
+		t.Error("Expected an error for an unterminated quote")
+	}
+}
+
+func TestGetCommandParts(t *testing.T) {
+	setupTest(t, tuesday)
+	tests := []struct {
+		content  *mevent.MessageEventContent
+		mentions []string
+		expected []string
+	}{
+		{&mevent.MessageEventContent{Body: "!su"}, nil, []string{"help"}},
+		{&mevent.MessageEventContent{Body: "!su new"}, nil, []string{"new"}},
+		{&mevent.MessageEventContent{Body: "!standupbot: edit today"}, nil, []string{"edit", "today"}},
+		{&mevent.MessageEventContent{Body: "standupbot: show"}, nil, []string{"show"}},
+		{&mevent.MessageEventContent{Body: "@standupbot notify stop"}, nil, []string{"notify", "stop"}},
+		{&mevent.MessageEventContent{Body: "@standupbot:example.com: tz"}, nil, []string{"tz"}},
+		{
+			&mevent.MessageEventContent{
+				Body:          "Standup Bot: new",
+				Format:        mevent.FormatHTML,
+				FormattedBody: `<a href="https://matrix.to/#/@standupbot:example.com">Standup Bot</a>: new`,
+			},
+			nil,
+			[]string{"new"},
+		},
+		{
+			&mevent.MessageEventContent{
+				Body:          "Standup Bot show",
+				Format:        mevent.FormatHTML,
+				FormattedBody: `<a href="https://matrix.to/#/%40standupbot%3Aexample.com">Standup Bot</a> show`,
+			},
+			nil,
+			[]string{"show"},
+		},
+		{&mevent.MessageEventContent{Body: "Standup Bot: cancel"}, []string{testBotUser.String()}, []string{"cancel"}},
+	}
+	for _, test := range tests {
+		content := mevent.Content{Parsed: test.content}
+		if test.mentions != nil {
+			userIDs := make([]interface{}, 0)
+			for _, userID := range test.mentions {
+				userIDs = append(userIDs, userID)
+			}
+			content.Raw = map[string]interface{}{"m.mentions": map[string]interface{}{"user_ids": userIDs}}
+		}
+		_, commandParts, err := getCommandParts(&content)
+		if err != nil {
+			t.Errorf("getCommandParts(%q) failed: %v", test.content.Body, err)
+		} else if !reflect.DeepEqual(commandParts, test.expected) {
+			t.Errorf("getCommandParts(%q) = %q, expected %q", test.content.Body, commandParts, test.expected)
+		}
+	}
+
+	for _, body := range []string{"new", "!sunew", "Standup Bot: new", "standupbotfoo"} {
+		content := mevent.Content{Parsed: &mevent.MessageEventContent{Body: body}}
+		if _, _, err := getCommandParts(&content); err != ErrNotCommand {
+			t.Errorf("Expected ErrNotCommand for %q, got %v", body, err)
+		}
+	}
+}
+
+func TestCommandPrefixes(t *testing.T) {
+	setupTest(t, tuesday)
+	configuration.CommandPrefixes = []string{"?standup"}
+	CompileCommandPatterns()
+
+	content := mevent.Content{Parsed: &mevent.MessageEventContent{Body: "?standup show"}}
+	if _, commandParts, err := getCommandParts(&content); err != nil || !reflect.DeepEqual(commandParts, []string{"show"}) {
+		t.Errorf("Expected the configured prefix to work, got %q, %v", commandParts, err)
+	}
+	content = mevent.Content{Parsed: &mevent.MessageEventContent{Body: "!su show"}}
+	if _, _, err := getCommandParts(&content); err != ErrNotCommand {
+		t.Errorf("Expected the default prefix to be disabled, got %v", err)
+	}
+}
+
+func TestPrefixlessCommands(t *testing.T) {
+	hs := setupTest(t, tuesday)
+
+	// Prefixless commands only work once the config room is known.
+	hs.sendText("show")
+	if len(hs.messages(testConfigRoom)) != 0 {
+		t.Fatal("Expected prefixless commands to be ignored outside of the config room")
+	}
+
+	hs.sendText("!su show")
+	hs.sendText("new")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "What did you do yesterday?")
+
+	// While answering questions, messages are standup items.
+	items := answerQuestion(t, hs, "What did you do yesterday?", "show")
+	if len(items) != 1 || currentStandupFlows[testUser].Yesterday[0].Body != "show" {
+		t.Fatal("Expected show to be added as an item")
+	}
+}
+
+func TestHelp(t *testing.T) {
+	hs := setupTest(t, tuesday)
+
+	hs.sendText("!su help")
+	body := hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body
+	for _, command := range commands {
+		if !command.AdminOnly {
+			assertContains(t, body, command.Usage())
+		}
+	}
+	assertNotContains(t, body, "admin")
+
+	hs.sendText("!su help timezone")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "Usage: !su tz [timezone]", "Aliases: timezone")
+}
+
+func TestUnknownCommand(t *testing.T) {
+	hs := setupTest(t, tuesday)
+
+	hs.sendText("!su shwo")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "Unknown command shwo.", "Did you mean show?")
+
+	hs.sendText("!su frobnicate")
+	assertNotContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "Did you mean")
+}
+
+func TestInvalidArguments(t *testing.T) {
+	hs := setupTest(t, tuesday)
+
+	hs.sendText("!su edit")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "not enough arguments", "Usage: !su edit <Friday|Weekend|Yesterday|Today|Blockers|Notes>")
+
+	hs.sendText("!su edit tomorrow")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "tomorrow is not valid")
+
+	hs.sendText(`!su tz "America/New_York`)
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "Could not parse the command")
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+)
+
+func TestLoadYAMLConfiguration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yamlConfig := `
+homeserver: https://matrix.example.com
+username: "@standupbot:example.com"
+datadir: /data
+accesscontrol:
+  allowedusers:
+    - "@alice:example.com"
+appservice:
+  port: 29333
+defaults:
+  notifytime: "08:30"
+`
+	if err := os.WriteFile(path, []byte(yamlConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfiguration(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Homeserver != "https://matrix.example.com" || config.Username != "@standupbot:example.com" {
+		t.Errorf("Unexpected homeserver or username: %+v", config)
+	}
+	if config.GetDataDir() != "/data" || config.GetDatabaseURI() != "sqlite:///data/standupbot.db" {
+		t.Errorf("Unexpected data directory or database: %s %s", config.GetDataDir(), config.GetDatabaseURI())
+	}
+	if !reflect.DeepEqual(config.AccessControl.AllowedUsers, []mid.UserID{"@alice:example.com"}) {
+		t.Errorf("Unexpected allowed users: %v", config.AccessControl.AllowedUsers)
+	}
+	if config.Appservice.Port != 29333 {
+		t.Errorf("Unexpected appservice port: %d", config.Appservice.Port)
+	}
+	if defaults := config.Defaults.UserDefaults(); defaults.NotifyMinutesAfterMidnight != 8*60+30 {
+		t.Errorf("Unexpected default notify time: %d", defaults.NotifyMinutesAfterMidnight)
+	}
+}
+
+func TestEnvironmentOverrides(t *testing.T) {
+	env := map[string]string{
+		"STANDUPBOT_PASSWORD_FILE":                  "/run/secrets/password",
+		"STANDUPBOT_SSO_LISTEN_ADDRESS":             "0.0.0.0:29334",
+		"STANDUPBOT_ACCESS_CONTROL_ALLOWED_DOMAINS": "example.com, example.org",
+		"STANDUPBOT_APPSERVICE_PORT":                "8080",
+		"STANDUPBOT_DEFAULTS_USE_THREADS":           "true",
+	}
+	lookupEnv := func(name string) (string, bool) {
+		value, found := env[name]
+		return value, found
+	}
+
+	config := Configuration{PasswordFile: "/password", Homeserver: "https://matrix.example.com"}
+	if err := applyEnvironment(reflect.ValueOf(&config).Elem(), envPrefix, lookupEnv); err != nil {
+		t.Fatal(err)
+	}
+	if config.PasswordFile != "/run/secrets/password" || config.SSOListenAddress != "0.0.0.0:29334" {
+		t.Errorf("Strings were not overridden: %+v", config)
+	}
+	if config.Homeserver != "https://matrix.example.com" {
+		t.Errorf("Homeserver should not have changed: %s", config.Homeserver)
+	}
+	if !reflect.DeepEqual(config.AccessControl.AllowedDomains, []string{"example.com", "example.org"}) {
+		t.Errorf("Unexpected allowed domains: %q", config.AccessControl.AllowedDomains)
+	}
+	if config.Appservice.Port != 8080 || !config.Defaults.UseThreads {
+		t.Errorf("Numbers or booleans were not overridden: %+v", config)
+	}
+
+	env["STANDUPBOT_APPSERVICE_PORT"] = "not a port"
+	if err := applyEnvironment(reflect.ValueOf(&config).Elem(), envPrefix, lookupEnv); err == nil {
+		t.Error("Expected an error for an invalid port")
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	for fieldName, expected := range map[string]string{
+		"Homeserver":                  "HOMESERVER",
+		"DeviceID":                    "DEVICE_ID",
+		"SSOListenAddress":            "SSO_LISTEN_ADDRESS",
+		"DatabaseURI":                 "DATABASE_URI",
+		"CrossSigningRecoveryKeyFile": "CROSS_SIGNING_RECOVERY_KEY_FILE",
+	} {
+		if name := envName(fieldName); name != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, fieldName, name)
+		}
+	}
+}
+
+func TestValidateDefaults(t *testing.T) {
+	config := Configuration{
+		Homeserver:   "https://matrix.example.com",
+		Username:     "@standupbot:example.com",
+		DatabaseURI:  "sqlite:///data/standupbot.db",
+		DatabasePath: "/data/standupbot.db",
+		Defaults: DefaultsConfiguration{
+			Timezone:   "Mars/Olympus_Mons",
+			NotifyTime: "breakfast",
+			Language:   "tlh",
+		},
+		Appservice: AppserviceConfiguration{RegistrationFile: "/data/registration.yaml"},
+	}
+	var problems []string
+	for _, problem := range config.Validate() {
+		problems = append(problems, problem.Error())
+	}
+	if len(problems) != 6 {
+		t.Fatalf("Expected 6 problems, got %q", problems)
+	}
+	assertContains(t, problems[0], "only one of DatabaseURI and DatabasePath")
+	assertContains(t, problems[1], "Defaults.Timezone")
+	assertContains(t, problems[2], "Defaults.NotifyTime")
+	assertContains(t, problems[3], "Defaults.Language")
+	assertContains(t, problems[4], "Appservice.Address")
+	assertContains(t, problems[5], "Appservice.Port")
+}
+
+func TestUserDefaults(t *testing.T) {
+	setupTest(t, time.Date(2022, 3, 14, 12, 0, 0, 0, time.UTC))
+	configuration.Defaults = DefaultsConfiguration{Timezone: "America/Chicago", NotifyTime: "9:15", Language: "de"}
+	stateStore.SetDefaults(configuration.Defaults.UserDefaults())
+
+	if minutes, err := stateStore.GetNotify(testUser); err != nil || minutes != 9*60+15 {
+		t.Errorf("Expected the default notify time, got %d (%v)", minutes, err)
+	}
+	if location := stateStore.GetTimezone(testUser); location.String() != "America/Chicago" {
+		t.Errorf("Expected the default timezone, got %s", location)
+	}
+	if lang := languageFor(testUser); lang != "de" {
+		t.Errorf("Expected the default language, got %s", lang)
+	}
+
+	stateStore.SetNotify(testUser, 7*60)
+	if minutes, _ := stateStore.GetNotify(testUser); minutes != 7*60 {
+		t.Errorf("The user's notify time should take precedence, got %d", minutes)
+	}
+}
+
+func TestReloadConfiguration(t *testing.T) {
+	setupTest(t, time.Date(2022, 3, 14, 12, 0, 0, 0, time.UTC))
+	t.Cleanup(func() { log.SetLevel(log.DebugLevel) })
+	configuration.Homeserver = "https://matrix.example.com"
+
+	configPath = filepath.Join(t.TempDir(), "config.yaml")
+	config := `
+Homeserver: https://matrix.example.com
+Username: "@standupbot:example.com"
+LogLevel: warn
+Admins: ["@alice:example.com"]
+Defaults:
+  Timezone: Europe/Berlin
+`
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadConfiguration(); err != nil {
+		t.Fatal(err)
+	}
+	if log.GetLevel() != log.WarnLevel {
+		t.Errorf("Expected the log level to be reloaded, got %s", log.GetLevel())
+	}
+	if !configuration.IsAdmin("@alice:example.com") {
+		t.Errorf("Expected the admins to be reloaded, got %v", configuration.Admins)
+	}
+	if stateStore.Defaults().Timezone != "Europe/Berlin" {
+		t.Errorf("Expected the defaults to be reloaded, got %+v", stateStore.Defaults())
+	}
+	if configuration.Username != testBotUser.String() {
+		t.Errorf("The username should only change after a restart, got %s", configuration.Username)
+	}
+
+	// A config with problems is not applied.
+	if err := os.WriteFile(configPath, []byte(strings.Replace(config, "@alice:example.com", "alice", 1)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadConfiguration(); err == nil {
+		t.Error("Expected an error for an invalid admin")
+	}
+	if !configuration.IsAdmin("@alice:example.com") {
+		t.Errorf("The admins should not have changed, got %v", configuration.Admins)
+	}
+}
+
+func TestReloadConfigurationWhileHandlingEvents(t *testing.T) {
+	setupTest(t, time.Date(2022, 3, 14, 12, 0, 0, 0, time.UTC))
+	t.Cleanup(func() { log.SetLevel(log.DebugLevel) })
+	configuration.Homeserver = "https://matrix.example.com"
+
+	configPath = filepath.Join(t.TempDir(), "config.yaml")
+	config := `
+Homeserver: https://matrix.example.com
+Username: "@standupbot:example.com"
+CommandPrefixes: ["!standup"]
+Admins: ["@alice:example.com"]
+AccessControl:
+  AllowedDomains: ["example.com"]
+Defaults:
+  Timezone: Europe/Berlin
+  Language: de
+`
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Run with -race to check that the handlers do not race with the reload.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		content := &mevent.Content{Parsed: &mevent.MessageEventContent{MsgType: mevent.MsgText, Body: "!standup help"}}
+		for i := 0; i < 100; i++ {
+			canUseBot(testUser)
+			getCommandParts(content)
+			stateStore.Defaults()
+			languageFor(testBotUser)
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		if err := ReloadConfiguration(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	content := &mevent.Content{Parsed: &mevent.MessageEventContent{MsgType: mevent.MsgText, Body: "!standup help"}}
+	if _, parts, err := getCommandParts(content); err != nil || len(parts) != 1 || parts[0] != "help" {
+		t.Errorf("Expected the new command prefix to be used, got %v (%v)", parts, err)
+	}
+}
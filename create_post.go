@@ -11,14 +11,14 @@ import (
 	mevent "maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/format"
 	mid "maunium.net/go/mautrix/id"
+
+	"github.com/beeper/standupbot/types"
 )
 
 const CHECKMARK = "✅"
 const RED_X = "❌"
 
 // Previous Post
-var StatePreviousPost = mevent.Type{Type: "com.nevarro.standupbot.previous_post", Class: mevent.StateEventType}
-
 type PreviousPostEventContent struct {
 	EditEventID mid.EventID
 	FlowID      uuid.UUID
@@ -35,41 +35,42 @@ func sendMessageWithCheckmarkReaction(roomID mid.RoomID, message *mevent.Message
 	return resp, nil
 }
 
-func sendThreadRootMessage(roomID mid.RoomID, header string) (*mautrix.RespSendEvent, error) {
-	content := format.RenderMarkdown(fmt.Sprintf("**%s** *(thread)*", header), true, false)
+func sendThreadRootMessage(roomID mid.RoomID, lang string, section string) (*mautrix.RespSendEvent, error) {
+	content := format.RenderMarkdown(fmt.Sprintf("**%s** *(%s)*", T(lang, "section."+section), T(lang, "threads.thread")), true, false)
 	return SendMessage(roomID, &content)
 }
 
 func GoToStateAndNotify(roomID mid.RoomID, userID mid.UserID, state StandupFlowState) {
+	lang := languageFor(userID)
 	var question string
 	switch state {
 	case Friday:
-		question = "What did you do Friday?"
+		question = T(lang, "question.friday")
 		break
 	case Weekend:
-		question = "What did you do over the weekend?"
+		question = T(lang, "question.weekend")
 		break
 	case Yesterday:
-		question = "What did you do yesterday?"
+		question = T(lang, "question.yesterday")
 		break
 	case Today:
-		question = "What are you planning to do today?"
+		question = T(lang, "question.today")
 		break
 	case Blockers:
-		question = "Do you have any blockers?"
+		question = T(lang, "question.blockers")
 		break
 	case Notes:
-		question = "Do you have any other notes?"
+		question = T(lang, "question.notes")
 		break
 	}
 
 	var resp *mautrix.RespSendEvent
 	var err error
 	if state == Threads || state == ThreadsFriday {
-		content := format.RenderMarkdown("**Fill out the standup post by replying in each thread.** *Enter one item per message.*", true, false)
+		content := format.RenderMarkdown(T(lang, "threads.intro"), true, false)
 		resp, err = SendMessage(roomID, &content)
 	} else {
-		content := format.RenderMarkdown(fmt.Sprintf("%s *Enter one item per message. React with ✅ when done.*", question), true, false)
+		content := format.RenderMarkdown(fmt.Sprintf("%s *%s*", question, T(lang, "question.hint")), true, false)
 		resp, err = sendMessageWithCheckmarkReaction(roomID, &content)
 	}
 	if err != nil {
@@ -85,21 +86,21 @@ func GoToStateAndNotify(roomID mid.RoomID, userID mid.UserID, state StandupFlowS
 
 	if state == Threads || state == ThreadsFriday {
 		if state == ThreadsFriday {
-			resp, err := sendThreadRootMessage(roomID, "Friday")
+			resp, err := sendThreadRootMessage(roomID, lang, "friday")
 			if err != nil {
 				log.Error("Unable to send thread root for Friday")
 				return
 			}
 			currentStandupFlows[userID].FridayThreadEvents = []mid.EventID{resp.EventID}
 
-			resp, err = sendThreadRootMessage(roomID, "Weekend")
+			resp, err = sendThreadRootMessage(roomID, lang, "weekend")
 			if err != nil {
 				log.Error("Unable to send thread root for Weekend")
 				return
 			}
 			currentStandupFlows[userID].WeekendThreadEvents = []mid.EventID{resp.EventID}
 		} else {
-			resp, err = sendThreadRootMessage(roomID, "Yesterday")
+			resp, err = sendThreadRootMessage(roomID, lang, "yesterday")
 			if err != nil {
 				log.Error("Unable to send thread root for Yesterday")
 				return
@@ -107,21 +108,21 @@ func GoToStateAndNotify(roomID mid.RoomID, userID mid.UserID, state StandupFlowS
 			currentStandupFlows[userID].YesterdayThreadEvents = []mid.EventID{resp.EventID}
 		}
 
-		resp, err = sendThreadRootMessage(roomID, "Today")
+		resp, err = sendThreadRootMessage(roomID, lang, "today")
 		if err != nil {
 			log.Error("Unable to send thread root for Today")
 			return
 		}
 		currentStandupFlows[userID].TodayThreadEvents = []mid.EventID{resp.EventID}
 
-		resp, err = sendThreadRootMessage(roomID, "Blockers")
+		resp, err = sendThreadRootMessage(roomID, lang, "blockers")
 		if err != nil {
 			log.Error("Unable to send thread root for Blockers")
 			return
 		}
 		currentStandupFlows[userID].BlockersThreadEvents = []mid.EventID{resp.EventID}
 
-		resp, err = sendThreadRootMessage(roomID, "Notes")
+		resp, err = sendThreadRootMessage(roomID, lang, "notes")
 		if err != nil {
 			log.Error("Unable to send thread root for Notes")
 			return
@@ -136,7 +137,7 @@ func GoToStateAndNotify(roomID mid.RoomID, userID mid.UserID, state StandupFlowS
 func CreatePost(roomID mid.RoomID, userID mid.UserID) {
 	stateKey := strings.TrimPrefix(userID.String(), "@")
 	var previousPostEventContent PreviousPostEventContent
-	err := client.StateEvent(roomID, StatePreviousPost, stateKey, &previousPostEventContent)
+	err := matrixClient.StateEvent(roomID, types.StatePreviousPost, stateKey, &previousPostEventContent)
 	if err != nil {
 		log.Debug("Couldn't find previous post info.")
 	} else {
@@ -176,52 +177,60 @@ func formatList(items []StandupItem) (string, string) {
 }
 
 func FormatPost(userID mid.UserID, standupFlow *StandupFlow, preview bool, sendConfirmation bool, isEditOfExisting bool) *mevent.MessageEventContent {
-	postText := fmt.Sprintf(`%s's standup post:\n\n`, userID)
-	postHtml := fmt.Sprintf(`<a href="https://matrix.to/#/%s">%s</a>'s standup post:<br><br>`, userID, userID)
-
-	if len(standupFlow.Yesterday) > 0 {
-		plain, html := formatList(standupFlow.Yesterday)
-		postText += "**Yesterday**\n" + plain
-		postHtml += "<b>Yesterday</b><br><ul>" + html + "</ul>"
-	}
-	if len(standupFlow.Friday) > 0 {
-		plain, html := formatList(standupFlow.Friday)
-		postText += "\n**Friday**\n" + plain
-		postHtml += "<b>Friday</b><br><ul>" + html + "</ul>"
-	}
-	if len(standupFlow.Weekend) > 0 {
-		plain, html := formatList(standupFlow.Weekend)
-		postText += "\n**Weekend**\n" + plain
-		postHtml += "<b>Weekend</b><br><ul>" + html + "</ul>"
-	}
-	if len(standupFlow.Today) > 0 {
-		plain, html := formatList(standupFlow.Today)
-		postText += "\n**Today**\n" + plain
-		postHtml += "<b>Today</b><br><ul>" + html + "</ul>"
+	lang := languageFor(userID)
+	postLang := postLanguageFor(userID)
+	postText := T(postLang, "post.header", userID) + "\n\n"
+	postHtml := T(postLang, "post.header", fmt.Sprintf(`<a href="https://matrix.to/#/%s">%s</a>`, userID, userID)) + "<br><br>"
+
+	sections := []struct {
+		key   string
+		items []StandupItem
+	}{
+		{"yesterday", standupFlow.Yesterday},
+		{"friday", standupFlow.Friday},
+		{"weekend", standupFlow.Weekend},
+		{"today", standupFlow.Today},
+		{"blockers", standupFlow.Blockers},
+		{"notes", standupFlow.Notes},
 	}
-	if len(standupFlow.Blockers) > 0 {
-		plain, html := formatList(standupFlow.Blockers)
-		postText += "\n**Blockers**\n" + plain
-		postHtml += "<b>Blockers</b><br><ul>" + html + "</ul>"
-	}
-	if len(standupFlow.Notes) > 0 {
-		plain, html := formatList(standupFlow.Notes)
-		postText += "\n**Notes**\n" + plain
-		postHtml += "<b>Notes</b><br><ul>" + html + "</ul>"
+	for i, section := range sections {
+		if len(section.items) == 0 {
+			continue
+		}
+		if i > 0 {
+			postText += "\n"
+		}
+		header := T(postLang, "section."+section.key)
+		plain, html := formatList(section.items)
+		postText += "**" + header + "**\n" + plain
+		postHtml += "<b>" + header + "</b><br><ul>" + html + "</ul>"
 	}
 
+	previewHeader, confirm := "", ""
 	if preview {
-		postText = fmt.Sprintf("Standup post preview:\n----------------------------------------\n" + postText)
-		postHtml = fmt.Sprintf("<i>Standup post preview:</i><hr>" + postHtml)
+		previewHeader = T(lang, "preview.header")
 	}
 	if sendConfirmation {
+		confirmKey := "preview.send"
 		if isEditOfExisting {
-			postText = fmt.Sprintf("%s\n----------------------------------------\nSend Edit (%s) or Cancel (%s)?", postText, CHECKMARK, RED_X)
-			postHtml = fmt.Sprintf("%s<hr><b>Send Edit (%s) or Cancel (%s)?</b>", postHtml, CHECKMARK, RED_X)
-		} else {
-			postText = fmt.Sprintf("%s\n----------------------------------------\nSend (%s) or Cancel (%s)?", postText, CHECKMARK, RED_X)
-			postHtml = fmt.Sprintf("%s<hr><b>Send (%s) or Cancel (%s)?</b>", postHtml, CHECKMARK, RED_X)
+			confirmKey = "preview.send_edit"
 		}
+		confirm = T(lang, confirmKey, CHECKMARK, RED_X)
+	}
+	return previewContent(postText, postHtml, previewHeader, confirm)
+}
+
+// previewContent makes a message out of the post. The preview header is added
+// above the post and the confirmation question below it, unless they are
+// empty.
+func previewContent(postText, postHtml, previewHeader, confirm string) *mevent.MessageEventContent {
+	if previewHeader != "" {
+		postText = previewHeader + "\n----------------------------------------\n" + postText
+		postHtml = "<i>" + previewHeader + "</i><hr>" + postHtml
+	}
+	if confirm != "" {
+		postText = fmt.Sprintf("%s\n----------------------------------------\n%s", postText, confirm)
+		postHtml = fmt.Sprintf("%s<hr><b>%s</b>", postHtml, confirm)
 	}
 
 	return &mevent.MessageEventContent{
@@ -243,21 +252,16 @@ func ShowMessagePreview(roomID mid.RoomID, userID mid.UserID, currentFlow *Stand
 }
 
 func SendMessageToSendRoom(event *mevent.Event, currentFlow *StandupFlow, editEventID *mid.EventID) {
+	lang := languageFor(event.Sender)
 	sendRoomID, err := stateStore.GetSendRoomId(event.Sender)
 	if err != nil {
-		content := format.RenderMarkdown("No send room set! Set one using `!standupbot room [room ID or alias]`.", true, false)
+		content := format.RenderMarkdown(T(lang, "send.no_room"), true, false)
 		SendMessage(event.RoomID, &content)
 		return
 	}
 
-	found := false
-	for _, userID := range stateStore.GetRoomMembers(sendRoomID) {
-		if event.Sender == userID {
-			found = true
-		}
-	}
-	if !found {
-		content := format.RenderMarkdown(fmt.Sprintf("**You are not a member of the configured send room!** Refusing to send a message to the room. Set a new one using `!standupbot room [room ID or alias]`."), true, false)
+	if !isRoomMember(sendRoomID, event.Sender) {
+		content := format.RenderMarkdown(T(lang, "send.not_member"), true, false)
 		SendMessage(event.RoomID, &content)
 		return
 	}
@@ -265,7 +269,7 @@ func SendMessageToSendRoom(event *mevent.Event, currentFlow *StandupFlow, editEv
 	newPost := FormatPost(event.Sender, currentFlow, false, false, false)
 	var futureEditId mid.EventID
 	var sent *mautrix.RespSendEvent
-	editStr := ""
+	sentKey, failedKey := "send.sent", "send.failed"
 	if editEventID != nil {
 		_, err = SendMessageOnBehalfOf(&event.Sender, sendRoomID, &mevent.MessageEventContent{
 			MsgType:       mevent.MsgText,
@@ -278,7 +282,7 @@ func SendMessageToSendRoom(event *mevent.Event, currentFlow *StandupFlow, editEv
 			},
 			NewContent: newPost,
 		})
-		editStr = " edit"
+		sentKey, failedKey = "send.sent_edit", "send.failed_edit"
 		futureEditId = *editEventID
 	} else {
 		sent, err = SendMessageOnBehalfOf(&event.Sender, sendRoomID, newPost)
@@ -288,16 +292,17 @@ func SendMessageToSendRoom(event *mevent.Event, currentFlow *StandupFlow, editEv
 	}
 
 	if err != nil {
-		content := format.RenderMarkdown(fmt.Sprintf("Failed to send standup post%s to [%s](https://matrix.to/#/%s)", editStr, sendRoomID.String(), sendRoomID.String()), true, false)
+		content := format.RenderMarkdown(T(lang, failedKey, sendRoomID.String(), sendRoomID.String()), true, false)
 		SendMessage(event.RoomID, &content)
 	} else {
-		content := format.RenderMarkdown(fmt.Sprintf("Sent standup post%s to [%s](https://matrix.to/#/%s)", editStr, sendRoomID.String(), sendRoomID.String()), true, false)
+		content := format.RenderMarkdown(T(lang, sentKey, sendRoomID.String(), sendRoomID.String()), true, false)
 		content.MsgType = mevent.MsgNotice
 		SendMessage(event.RoomID, &content)
 		currentFlow.ResendEventId = nil
 		currentFlow.State = Sent
+		recordPost(event.Sender, currentFlow)
 		stateKey := strings.TrimPrefix(event.Sender.String(), "@")
-		_, err = client.SendStateEvent(event.RoomID, StatePreviousPost, stateKey, PreviousPostEventContent{
+		_, err = matrixClient.SendStateEvent(event.RoomID, types.StatePreviousPost, stateKey, PreviousPostEventContent{
 			EditEventID: futureEditId,
 			FlowID:      currentFlow.FlowID,
 			Day:         stateStore.GetCurrentWeekdayInUserTimezone(event.Sender),
@@ -306,7 +311,18 @@ func SendMessageToSendRoom(event *mevent.Event, currentFlow *StandupFlow, editEv
 	}
 }
 
-func HandleReaction(_ mautrix.EventSource, event *mevent.Event) {
+func HandleReaction(event *mevent.Event) {
+	// The bot's own account can confirm a verification even if it cannot use
+	// the bot.
+	if HandleSASReaction(event) {
+		return
+	}
+	if !canUseBot(event.Sender) {
+		return
+	}
+	if HandleWeeklySummaryReaction(event) {
+		return
+	}
 	reactionEventContent := event.Content.AsReaction()
 	currentFlow, found := currentStandupFlows[event.Sender]
 	if !found || currentFlow.State == FlowNotStarted {
@@ -325,19 +341,19 @@ func HandleReaction(_ mautrix.EventSource, event *mevent.Event) {
 	}
 
 	// Mark the reaction as read after we've handled it.
-	defer client.MarkRead(event.RoomID, event.ID)
+	defer matrixClient.MarkRead(event.RoomID, event.ID)
 
 	if reactionEventContent.RelatesTo.Key == CHECKMARK {
 		currentFlow.ReactableEvents = make([]mid.EventID, 0)
 
 		stateKey := strings.TrimPrefix(event.Sender.String(), "@")
 		var previousPostEventContent PreviousPostEventContent
-		stateEventErr := client.StateEvent(event.RoomID, StatePreviousPost, stateKey, &previousPostEventContent)
+		stateEventErr := matrixClient.StateEvent(event.RoomID, types.StatePreviousPost, stateKey, &previousPostEventContent)
 
 		if stateEventErr == nil && currentFlow.FlowID == previousPostEventContent.FlowID {
 			if currentFlow.State != Sent {
 				// this means that we have already gone through the flow, sent the message, then went back to edit.
-				client.RedactEvent(event.RoomID, currentFlow.PreviewEventId)
+				matrixClient.RedactEvent(event.RoomID, currentFlow.PreviewEventId)
 				ShowMessagePreview(event.RoomID, event.Sender, currentFlow, false)
 				currentFlow.State = Sent
 				return
@@ -345,7 +361,7 @@ func HandleReaction(_ mautrix.EventSource, event *mevent.Event) {
 		} else if currentFlow.PreviewEventId.String() != "" {
 			if currentFlow.State != Confirm && currentFlow.State != Sent && currentFlow.State != Threads && currentFlow.State != ThreadsFriday {
 				// this means we have already gone through the flow, and we went back to edit.
-				client.RedactEvent(event.RoomID, currentFlow.PreviewEventId)
+				matrixClient.RedactEvent(event.RoomID, currentFlow.PreviewEventId)
 				currentFlow.State = Notes
 			}
 		}
@@ -377,7 +393,7 @@ func HandleReaction(_ mautrix.EventSource, event *mevent.Event) {
 			if stateEventErr != nil {
 				SendMessage(event.RoomID, &mevent.MessageEventContent{
 					MsgType: mevent.MsgText,
-					Body:    "No previous post info found!",
+					Body:    T(languageFor(event.Sender), "send.no_previous"),
 				})
 				currentFlow = BlankStandupFlow()
 				return
@@ -388,7 +404,7 @@ func HandleReaction(_ mautrix.EventSource, event *mevent.Event) {
 	} else if reactionEventContent.RelatesTo.Key == RED_X {
 		if currentFlow.State == Confirm || currentFlow.State == Sent {
 			currentStandupFlows[event.Sender] = BlankStandupFlow()
-			SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: "Standup post cancelled"})
+			SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: T(languageFor(event.Sender), "cancel.done")})
 		}
 	}
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mcrypto "maunium.net/go/mautrix/crypto"
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+)
+
+// How long to wait for the keys for an undecryptable event before telling the
+// sender that it could not be decrypted.
+const undecryptableEventTimeout = 10 * time.Minute
+
+// Set while the undecryptable events are being retried, so that the sync
+// responses that arrive in the meantime do not start another run.
+var retryingUndecryptable int32
+
+// HandleEncrypted decrypts an encrypted event and dispatches it to the handler
+// for the decrypted event type. If the keys for the event have not arrived
+// yet, the event is queued and the keys are requested from the sender.
+func HandleEncrypted(event *mevent.Event) {
+	decryptedEvent, err := olmMachine.DecryptMegolmEvent(event)
+	if errors.Is(err, mcrypto.NoSessionFound) && event.Sender != client.UserID {
+		log.Warnf("No keys to decrypt %s from %s in %s yet, requesting them", event.ID, event.Sender, event.RoomID)
+		if err := stateStore.QueueUndecryptableEvent(event); err != nil {
+			log.Errorf("Failed to queue undecryptable event %s: %+v", event.ID, err)
+		}
+		requestKeys(event)
+	} else if err != nil {
+		log.Errorf("Failed to decrypt message from %s in %s: %+v", event.Sender, event.RoomID, err)
+	} else {
+		log.Debugf("Received encrypted event from %s in %s", event.Sender, event.RoomID)
+		handleDecryptedEvent(decryptedEvent)
+	}
+}
+
+func handleDecryptedEvent(decryptedEvent *mevent.Event) {
+	if decryptedEvent.Type.IsInRoomVerification() || isVerificationRequest(decryptedEvent) {
+		HandleInRoomVerification(decryptedEvent)
+	} else if decryptedEvent.Type == mevent.EventMessage {
+		goTracked(func() { HandleMessage(decryptedEvent) })
+	} else if decryptedEvent.Type == mevent.EventReaction {
+		goTracked(func() { HandleReaction(decryptedEvent) })
+	} else if decryptedEvent.Type == mevent.EventRedaction {
+		goTracked(func() { HandleRedaction(decryptedEvent) })
+	}
+}
+
+// requestKeys sends a room key request for the session of the event to all of
+// the sender's devices.
+func requestKeys(event *mevent.Event) {
+	content := event.Content.AsEncrypted()
+	deviceIDs := []mid.DeviceID{content.DeviceID}
+	if devices, err := olmMachine.CryptoStore.GetDevices(event.Sender); err == nil && len(devices) > 0 {
+		deviceIDs = make([]mid.DeviceID, 0, len(devices))
+		for deviceID := range devices {
+			deviceIDs = append(deviceIDs, deviceID)
+		}
+	}
+	err := olmMachine.SendRoomKeyRequest(event.RoomID, content.SenderKey, content.SessionID, "", map[mid.UserID][]mid.DeviceID{
+		event.Sender: deviceIDs,
+	})
+	if err != nil {
+		log.Errorf("Failed to request keys for %s: %+v", event.ID, err)
+	}
+}
+
+// StartRetryingUndecryptableEvents runs RetryUndecryptableEvents in the
+// background after a sync response has been processed by the OlmMachine, so
+// that the next sync does not have to wait for it. If it is still running from
+// an earlier sync, it is not started again.
+func StartRetryingUndecryptableEvents() {
+	if !atomic.CompareAndSwapInt32(&retryingUndecryptable, 0, 1) {
+		return
+	}
+	started := goTracked(func() {
+		defer atomic.StoreInt32(&retryingUndecryptable, 0)
+		RetryUndecryptableEvents()
+	})
+	if !started {
+		atomic.StoreInt32(&retryingUndecryptable, 0)
+	}
+}
+
+// RetryUndecryptableEvents tries to decrypt the queued events for which the
+// keys have arrived, and gives up on the ones that have been waiting for longer
+// than undecryptableEventTimeout.
+func RetryUndecryptableEvents() {
+	for _, undecryptable := range stateStore.GetUndecryptableEvents() {
+		event := undecryptable.Event
+		session, err := olmMachine.CryptoStore.GetGroupSession(event.RoomID, undecryptable.SenderKey, undecryptable.SessionID)
+		if session != nil || errors.Is(err, mcrypto.ErrGroupSessionWithheld) {
+			stateStore.RemoveUndecryptableEvent(event.ID)
+			decryptedEvent, err := olmMachine.DecryptMegolmEvent(event)
+			if err != nil {
+				log.Errorf("Failed to decrypt message from %s in %s after receiving keys: %+v", event.Sender, event.RoomID, err)
+				notifyUndecryptable(event)
+			} else {
+				log.Infof("Decrypted %s from %s after receiving keys", event.ID, event.Sender)
+				handleDecryptedEvent(decryptedEvent)
+			}
+		} else if time.Since(undecryptable.QueuedAt) > undecryptableEventTimeout {
+			log.Warnf("Giving up on decrypting %s from %s in %s", event.ID, event.Sender, event.RoomID)
+			stateStore.RemoveUndecryptableEvent(event.ID)
+			notifyUndecryptable(event)
+		}
+	}
+}
+
+func notifyUndecryptable(event *mevent.Event) {
+	roomID := stateStore.GetConfigRoomId(event.Sender)
+	if roomID == "" {
+		roomID = event.RoomID
+	}
+	SendMessage(roomID, &mevent.MessageEventContent{
+		MsgType: mevent.MsgNotice,
+		Body: T(languageFor(event.Sender), "undecryptable",
+			time.Unix(0, event.Timestamp*int64(time.Millisecond)).In(stateStore.GetTimezone(event.Sender)).Format("15:04 MST")),
+	})
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix"
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+
+	"github.com/beeper/standupbot/store"
+	"github.com/beeper/standupbot/types"
+)
+
+const (
+	testBotUser    = mid.UserID("@standupbot:example.com")
+	testUser       = mid.UserID("@alice:example.com")
+	testConfigRoom = mid.RoomID("!config:example.com")
+	testSendRoom   = mid.RoomID("!send:example.com")
+)
+
+// fakeHomeserver is an in-memory implementation of MatrixClient that records
+// everything that the bot sends and stores room state.
+type fakeHomeserver struct {
+	lock        sync.Mutex
+	nextEventID int
+	events      []*mevent.Event
+	state       map[mid.RoomID]map[string]json.RawMessage
+	readMarkers map[mid.RoomID]mid.EventID
+	joinedRooms []mid.RoomID
+	leftRooms   []mid.RoomID
+	aliases     map[mid.RoomAlias]mid.RoomID
+}
+
+var _ MatrixClient = &fakeHomeserver{}
+
+func newFakeHomeserver() *fakeHomeserver {
+	return &fakeHomeserver{
+		state:       map[mid.RoomID]map[string]json.RawMessage{},
+		readMarkers: map[mid.RoomID]mid.EventID{},
+		aliases:     map[mid.RoomAlias]mid.RoomID{},
+	}
+}
+
+func (hs *fakeHomeserver) addEvent(roomID mid.RoomID, sender mid.UserID, eventType mevent.Type, content mevent.Content) *mevent.Event {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	hs.nextEventID++
+	event := &mevent.Event{
+		ID:        mid.EventID(fmt.Sprintf("$event%d", hs.nextEventID)),
+		RoomID:    roomID,
+		Sender:    sender,
+		Type:      eventType,
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		Content:   content,
+	}
+	hs.events = append(hs.events, event)
+	return event
+}
+
+func (hs *fakeHomeserver) SendMessageEvent(roomID mid.RoomID, eventType mevent.Type, contentJSON interface{}, extra ...mautrix.ReqSendEvent) (*mautrix.RespSendEvent, error) {
+	var content mevent.Content
+	switch c := contentJSON.(type) {
+	case *mevent.Content:
+		content = *c
+	default:
+		content = mevent.Content{Parsed: c}
+	}
+	event := hs.addEvent(roomID, testBotUser, eventType, content)
+	return &mautrix.RespSendEvent{EventID: event.ID}, nil
+}
+
+func (hs *fakeHomeserver) SendStateEvent(roomID mid.RoomID, eventType mevent.Type, stateKey string, contentJSON interface{}) (*mautrix.RespSendEvent, error) {
+	contentBytes, err := json.Marshal(contentJSON)
+	if err != nil {
+		return nil, err
+	}
+	hs.lock.Lock()
+	if _, found := hs.state[roomID]; !found {
+		hs.state[roomID] = map[string]json.RawMessage{}
+	}
+	hs.state[roomID][eventType.Type+"/"+stateKey] = contentBytes
+	hs.lock.Unlock()
+
+	event := hs.addEvent(roomID, testBotUser, eventType, mevent.Content{VeryRaw: contentBytes})
+	return &mautrix.RespSendEvent{EventID: event.ID}, nil
+}
+
+func (hs *fakeHomeserver) StateEvent(roomID mid.RoomID, eventType mevent.Type, stateKey string, outContent interface{}) error {
+	hs.lock.Lock()
+	contentBytes, found := hs.state[roomID][eventType.Type+"/"+stateKey]
+	hs.lock.Unlock()
+	if !found {
+		return mautrix.MNotFound
+	}
+	return json.Unmarshal(contentBytes, outContent)
+}
+
+func (hs *fakeHomeserver) RedactEvent(roomID mid.RoomID, eventID mid.EventID, extra ...mautrix.ReqRedact) (*mautrix.RespSendEvent, error) {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	hs.nextEventID++
+	event := &mevent.Event{
+		ID:      mid.EventID(fmt.Sprintf("$event%d", hs.nextEventID)),
+		RoomID:  roomID,
+		Sender:  testBotUser,
+		Type:    mevent.EventRedaction,
+		Redacts: eventID,
+	}
+	hs.events = append(hs.events, event)
+	return &mautrix.RespSendEvent{EventID: event.ID}, nil
+}
+
+func (hs *fakeHomeserver) SendReaction(roomID mid.RoomID, eventID mid.EventID, reaction string) (*mautrix.RespSendEvent, error) {
+	event := hs.addEvent(roomID, testBotUser, mevent.EventReaction, mevent.Content{Parsed: &mevent.ReactionEventContent{
+		RelatesTo: mevent.RelatesTo{
+			Type:    mevent.RelAnnotation,
+			EventID: eventID,
+			Key:     reaction,
+		},
+	}})
+	return &mautrix.RespSendEvent{EventID: event.ID}, nil
+}
+
+func (hs *fakeHomeserver) JoinRoom(roomIDorAlias, serverName string, content interface{}) (*mautrix.RespJoinRoom, error) {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	roomID, found := hs.aliases[mid.RoomAlias(roomIDorAlias)]
+	if !found {
+		if !strings.HasPrefix(roomIDorAlias, "!") {
+			return nil, mautrix.MNotFound
+		}
+		roomID = mid.RoomID(roomIDorAlias)
+	}
+	hs.joinedRooms = append(hs.joinedRooms, roomID)
+	return &mautrix.RespJoinRoom{RoomID: roomID}, nil
+}
+
+func (hs *fakeHomeserver) LeaveRoom(roomID mid.RoomID, optionalReq ...*mautrix.ReqLeave) (*mautrix.RespLeaveRoom, error) {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	hs.leftRooms = append(hs.leftRooms, roomID)
+	return &mautrix.RespLeaveRoom{}, nil
+}
+
+func (hs *fakeHomeserver) MarkRead(roomID mid.RoomID, eventID mid.EventID) error {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	hs.readMarkers[roomID] = eventID
+	return nil
+}
+
+func (hs *fakeHomeserver) Members(roomID mid.RoomID, req ...mautrix.ReqMembers) (*mautrix.RespMembers, error) {
+	members := &mautrix.RespMembers{Chunk: make([]*mevent.Event, 0)}
+	for _, userID := range stateStore.GetRoomMembers(roomID) {
+		stateKey := userID.String()
+		members.Chunk = append(members.Chunk, &mevent.Event{
+			RoomID:   roomID,
+			Sender:   userID,
+			Type:     mevent.StateMember,
+			StateKey: &stateKey,
+			Content:  mevent.Content{Parsed: &mevent.MemberEventContent{Membership: mevent.MembershipJoin}},
+		})
+	}
+	return members, nil
+}
+
+func (hs *fakeHomeserver) JoinedMembers(roomID mid.RoomID) (*mautrix.RespJoinedMembers, error) {
+	members := &mautrix.RespJoinedMembers{}
+	members.Joined = map[mid.UserID]struct {
+		DisplayName *string `json:"display_name"`
+		AvatarURL   *string `json:"avatar_url"`
+	}{testBotUser: {}}
+	for _, userID := range stateStore.GetRoomMembers(roomID) {
+		members.Joined[userID] = members.Joined[testBotUser]
+	}
+	return members, nil
+}
+
+func (hs *fakeHomeserver) State(roomID mid.RoomID) (mautrix.RoomStateMap, error) {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	state := mautrix.RoomStateMap{}
+	for key, content := range hs.state[roomID] {
+		parts := strings.SplitN(key, "/", 2)
+		// Like mautrix, guess the class of the event type from its name.
+		eventType := mevent.NewEventType(parts[0])
+		if _, found := state[eventType]; !found {
+			state[eventType] = map[string]*mevent.Event{}
+		}
+		stateKey := parts[1]
+		state[eventType][stateKey] = &mevent.Event{
+			RoomID:   roomID,
+			Type:     eventType,
+			StateKey: &stateKey,
+			Content:  mevent.Content{VeryRaw: content},
+		}
+	}
+	return state, nil
+}
+
+func (hs *fakeHomeserver) JoinedRooms() (*mautrix.RespJoinedRooms, error) {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	joined := map[mid.RoomID]bool{}
+	for _, event := range hs.events {
+		joined[event.RoomID] = true
+	}
+	for _, roomID := range hs.joinedRooms {
+		joined[roomID] = true
+	}
+	for _, roomID := range hs.leftRooms {
+		delete(joined, roomID)
+	}
+	resp := &mautrix.RespJoinedRooms{JoinedRooms: make([]mid.RoomID, 0)}
+	for roomID := range joined {
+		resp.JoinedRooms = append(resp.JoinedRooms, roomID)
+	}
+	return resp, nil
+}
+
+func (hs *fakeHomeserver) ResolveAlias(alias mid.RoomAlias) (*mautrix.RespAliasResolve, error) {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	roomID, found := hs.aliases[alias]
+	if !found {
+		return nil, mautrix.MNotFound
+	}
+	return &mautrix.RespAliasResolve{RoomID: roomID}, nil
+}
+
+// messages returns the messages that the bot sent to the room.
+func (hs *fakeHomeserver) messages(roomID mid.RoomID) []*mevent.Event {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	messages := make([]*mevent.Event, 0)
+	for _, event := range hs.events {
+		if event.RoomID == roomID && event.Sender == testBotUser && event.Type == mevent.EventMessage {
+			messages = append(messages, event)
+		}
+	}
+	return messages
+}
+
+func (hs *fakeHomeserver) lastMessage(t *testing.T, roomID mid.RoomID) *mevent.Event {
+	t.Helper()
+	messages := hs.messages(roomID)
+	if len(messages) == 0 {
+		t.Fatalf("No messages were sent to %s", roomID)
+	}
+	return messages[len(messages)-1]
+}
+
+// findMessage returns the last message that the bot sent to the room that
+// contains the given text.
+func (hs *fakeHomeserver) findMessage(t *testing.T, roomID mid.RoomID, text string) *mevent.Event {
+	t.Helper()
+	messages := hs.messages(roomID)
+	for i := len(messages) - 1; i >= 0; i-- {
+		if strings.Contains(messages[i].Content.AsMessage().Body, text) {
+			return messages[i]
+		}
+	}
+	t.Fatalf("No message containing %q was sent to %s", text, roomID)
+	return nil
+}
+
+// reactions returns the reactions that the bot sent to the event.
+func (hs *fakeHomeserver) reactions(eventID mid.EventID) []string {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	reactions := make([]string, 0)
+	for _, event := range hs.events {
+		if event.Sender == testBotUser && event.Type == mevent.EventReaction && event.Content.AsReaction().RelatesTo.EventID == eventID {
+			reactions = append(reactions, event.Content.AsReaction().RelatesTo.Key)
+		}
+	}
+	return reactions
+}
+
+// isRedacted returns whether the bot redacted the event.
+func (hs *fakeHomeserver) isRedacted(roomID mid.RoomID, eventID mid.EventID) bool {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	for _, event := range hs.events {
+		if event.RoomID == roomID && event.Sender == testBotUser && event.Type == mevent.EventRedaction && event.Redacts == eventID {
+			return true
+		}
+	}
+	return false
+}
+
+// sendMessage sends a message from the test user to the config room and
+// passes it to HandleMessage.
+func (hs *fakeHomeserver) sendMessage(content *mevent.MessageEventContent) *mevent.Event {
+	event := hs.addEvent(testConfigRoom, testUser, mevent.EventMessage, mevent.Content{Parsed: content})
+	HandleMessage(event)
+	return event
+}
+
+func (hs *fakeHomeserver) sendText(body string) *mevent.Event {
+	return hs.sendMessage(&mevent.MessageEventContent{MsgType: mevent.MsgText, Body: body})
+}
+
+func (hs *fakeHomeserver) sendEdit(eventID mid.EventID, newBody string) *mevent.Event {
+	return hs.sendMessage(&mevent.MessageEventContent{
+		MsgType: mevent.MsgText,
+		Body:    " * " + newBody,
+		RelatesTo: &mevent.RelatesTo{
+			Type:    mevent.RelReplace,
+			EventID: eventID,
+		},
+		NewContent: &mevent.MessageEventContent{MsgType: mevent.MsgText, Body: newBody},
+	})
+}
+
+func (hs *fakeHomeserver) sendThreadReply(rootEventID mid.EventID, body string) *mevent.Event {
+	return hs.sendMessage(&mevent.MessageEventContent{
+		MsgType: mevent.MsgText,
+		Body:    body,
+		RelatesTo: &mevent.RelatesTo{
+			Type:    "m.thread",
+			EventID: rootEventID,
+		},
+	})
+}
+
+// react sends a reaction from the test user and passes it to HandleReaction.
+func (hs *fakeHomeserver) react(eventID mid.EventID, key string) {
+	event := hs.addEvent(testConfigRoom, testUser, mevent.EventReaction, mevent.Content{Parsed: &mevent.ReactionEventContent{
+		RelatesTo: mevent.RelatesTo{
+			Type:    mevent.RelAnnotation,
+			EventID: eventID,
+			Key:     key,
+		},
+	}})
+	HandleReaction(event)
+}
+
+// redact redacts an event as the test user and passes the redaction to
+// HandleRedaction.
+func (hs *fakeHomeserver) redact(eventID mid.EventID) {
+	event := hs.addEvent(testConfigRoom, testUser, mevent.EventRedaction, mevent.Content{Parsed: &mevent.RedactionEventContent{}})
+	event.Redacts = eventID
+	HandleRedaction(event)
+}
+
+// setupTest points the bot's globals at a fresh fake homeserver and an
+// in-memory database, with the clock fixed at now.
+func setupTest(t *testing.T, now time.Time) *fakeHomeserver {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: gets its own database.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	hs := newFakeHomeserver()
+	configuration = Configuration{Username: testBotUser.String()}
+	CompileCommandPatterns()
+	types.SetNamespace(types.DefaultNamespace)
+	stateStore = store.NewStateStore(db, store.SQLite)
+	if err := stateStore.Upgrade(); err != nil {
+		t.Fatal(err)
+	}
+	stateStore.Client = hs
+	matrixClient = hs
+	scheduler = NewReminderScheduler()
+	weeklySummaryScheduler = NewWeeklySummaryScheduler()
+	stateStore.OnReminderSettingsChanged = rescheduleUser
+	currentStandupFlows = make(map[mid.UserID]*StandupFlow)
+	pendingWeeklySummaries.users = map[mid.UserID]*pendingWeeklySummary{}
+	atomic.StoreInt64(&lastSyncAt, 0)
+
+	store.Now = func() time.Time { return now }
+	t.Cleanup(func() { store.Now = time.Now })
+
+	// The test user is a member of the send room.
+	stateKey := testUser.String()
+	stateStore.SetMembership(&mevent.Event{
+		RoomID:   testSendRoom,
+		Type:     mevent.StateMember,
+		StateKey: &stateKey,
+		Content:  mevent.Content{Parsed: &mevent.MemberEventContent{Membership: mevent.MembershipJoin}},
+	})
+	return hs
+}
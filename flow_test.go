@@ -0,0 +1,342 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	mevent "maunium.net/go/mautrix/event"
+
+	"github.com/beeper/standupbot/types"
+)
+
+var (
+	monday  = time.Date(2022, time.March, 14, 12, 0, 0, 0, time.UTC)
+	tuesday = time.Date(2022, time.March, 15, 12, 0, 0, 0, time.UTC)
+)
+
+// answerQuestion checks that the last message in the config room asks the
+// given question, answers it with the given items and then reacts with ✅ to
+// move on to the next question.
+func answerQuestion(t *testing.T, hs *fakeHomeserver, question string, items ...string) []*mevent.Event {
+	t.Helper()
+	questionEvent := hs.lastMessage(t, testConfigRoom)
+	if body := questionEvent.Content.AsMessage().Body; !strings.Contains(body, question) {
+		t.Fatalf("Expected the question %q, got %q", question, body)
+	}
+
+	itemEvents := make([]*mevent.Event, 0)
+	for _, item := range items {
+		itemEvent := hs.sendText(item)
+		if reactions := hs.reactions(itemEvent.ID); len(reactions) != 1 || reactions[0] != CHECKMARK {
+			t.Fatalf("Expected a %s reaction to %q, got %v", CHECKMARK, item, reactions)
+		}
+		itemEvents = append(itemEvents, itemEvent)
+	}
+	hs.react(questionEvent.ID, CHECKMARK)
+	return itemEvents
+}
+
+// confirmPreview checks that the last message in the config room is the post
+// preview and reacts to it with ✅ to send the post.
+func confirmPreview(t *testing.T, hs *fakeHomeserver) *mevent.Event {
+	t.Helper()
+	preview := hs.lastMessage(t, testConfigRoom)
+	if body := preview.Content.AsMessage().Body; !strings.Contains(body, "Standup post preview") {
+		t.Fatalf("Expected the post preview, got %q", body)
+	}
+	if reactions := hs.reactions(preview.ID); len(reactions) != 2 || reactions[0] != CHECKMARK || reactions[1] != RED_X {
+		t.Fatalf("Expected %s and %s reactions to the preview, got %v", CHECKMARK, RED_X, reactions)
+	}
+	hs.react(preview.ID, CHECKMARK)
+	return preview
+}
+
+func assertContains(t *testing.T, body string, texts ...string) {
+	t.Helper()
+	for _, text := range texts {
+		if !strings.Contains(body, text) {
+			t.Errorf("Expected %q to contain %q", body, text)
+		}
+	}
+}
+
+func assertNotContains(t *testing.T, body string, texts ...string) {
+	t.Helper()
+	for _, text := range texts {
+		if strings.Contains(body, text) {
+			t.Errorf("Expected %q not to contain %q", body, text)
+		}
+	}
+}
+
+func assertState(t *testing.T, state StandupFlowState) {
+	t.Helper()
+	if flow, found := currentStandupFlows[testUser]; !found {
+		t.Fatalf("No standup flow for %s", testUser)
+	} else if flow.State != state {
+		t.Fatalf("Expected the flow to be in state %d, got %d", state, flow.State)
+	}
+}
+
+// sentPost returns the only post in the send room.
+func sentPost(t *testing.T, hs *fakeHomeserver) *mevent.Event {
+	t.Helper()
+	posts := hs.messages(testSendRoom)
+	if len(posts) != 1 {
+		t.Fatalf("Expected one post in the send room, got %d", len(posts))
+	}
+	return posts[0]
+}
+
+func TestNonThreadFlow(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	hs.sendText("!su room " + testSendRoom.String())
+	hs.sendText("!su new")
+
+	answerQuestion(t, hs, "What did you do yesterday?", "Wrote tests")
+	answerQuestion(t, hs, "What are you planning to do today?", "Fix bugs", "Review PRs")
+	answerQuestion(t, hs, "Do you have any blockers?")
+	answerQuestion(t, hs, "Do you have any other notes?", "Lunch at noon")
+	assertState(t, Confirm)
+	confirmPreview(t, hs)
+
+	post := sentPost(t, hs)
+	body := post.Content.AsMessage().Body
+	assertContains(t, body, "**Yesterday**\n- Wrote tests", "**Today**\n- Fix bugs\n- Review PRs", "**Notes**\n- Lunch at noon")
+	assertNotContains(t, body, "**Blockers**", "**Friday**", "preview")
+	if onBehalfOf := post.Content.Raw["space.nevarro.msc3464.on_behalf_of"]; onBehalfOf != testUser {
+		t.Errorf("Expected the post to be on behalf of %s, got %v", testUser, onBehalfOf)
+	}
+	assertState(t, Sent)
+
+	var previousPost PreviousPostEventContent
+	if err := hs.StateEvent(testConfigRoom, types.StatePreviousPost, "alice:example.com", &previousPost); err != nil {
+		t.Fatalf("No previous post state event: %+v", err)
+	}
+	if previousPost.EditEventID != post.ID {
+		t.Errorf("Expected the previous post to be %s, got %s", post.ID, previousPost.EditEventID)
+	}
+	if hs.readMarkers[testConfigRoom] == "" {
+		t.Error("Expected the bot to mark the messages as read")
+	}
+}
+
+func TestMondayFlow(t *testing.T) {
+	hs := setupTest(t, monday)
+	hs.sendText("!su room " + testSendRoom.String())
+	hs.sendText("!su new")
+
+	answerQuestion(t, hs, "What did you do Friday?", "Deployed the release")
+	answerQuestion(t, hs, "What did you do over the weekend?", "Went hiking")
+	answerQuestion(t, hs, "What are you planning to do today?", "Plan the sprint")
+	answerQuestion(t, hs, "Do you have any blockers?")
+	answerQuestion(t, hs, "Do you have any other notes?")
+	confirmPreview(t, hs)
+
+	body := sentPost(t, hs).Content.AsMessage().Body
+	assertContains(t, body, "**Friday**\n- Deployed the release", "**Weekend**\n- Went hiking", "**Today**\n- Plan the sprint")
+	assertNotContains(t, body, "**Yesterday**")
+}
+
+func TestEditYesterdayOnMonday(t *testing.T) {
+	hs := setupTest(t, monday)
+	hs.sendText("!su new")
+	hs.sendText("!su edit yesterday")
+
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "It's Monday")
+	assertState(t, Friday)
+}
+
+func TestThreadFlow(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	hs.sendText("!su room " + testSendRoom.String())
+	hs.sendText("!su threads true")
+	hs.sendText("!su new")
+	assertState(t, Threads)
+
+	yesterdayRoot := hs.findMessage(t, testConfigRoom, "**Yesterday** _(thread)_")
+	todayRoot := hs.findMessage(t, testConfigRoom, "**Today** _(thread)_")
+	preview := hs.lastMessage(t, testConfigRoom)
+
+	hs.sendThreadReply(yesterdayRoot.ID, "Wrote tests")
+	edit := hs.lastMessage(t, testConfigRoom).Content.AsMessage()
+	if edit.RelatesTo == nil || edit.RelatesTo.Type != mevent.RelReplace || edit.RelatesTo.EventID != preview.ID {
+		t.Fatalf("Expected the preview to be edited, got %+v", edit)
+	}
+	assertContains(t, edit.NewContent.Body, "**Yesterday**\n- Wrote tests")
+
+	hs.sendThreadReply(todayRoot.ID, "Fix bugs")
+	// Non-threaded messages are ignored in thread mode.
+	hs.sendText("Not in a thread")
+	flow := currentStandupFlows[testUser]
+	if len(flow.Yesterday) != 1 || len(flow.Today) != 1 {
+		t.Fatalf("Expected one item for yesterday and today, got %v and %v", flow.Yesterday, flow.Today)
+	}
+
+	hs.react(preview.ID, CHECKMARK)
+	body := sentPost(t, hs).Content.AsMessage().Body
+	assertContains(t, body, "**Yesterday**\n- Wrote tests", "**Today**\n- Fix bugs")
+	assertNotContains(t, body, "Not in a thread")
+	assertState(t, Sent)
+}
+
+func TestThreadFlowOnMonday(t *testing.T) {
+	hs := setupTest(t, monday)
+	hs.sendText("!su threads true")
+	hs.sendText("!su new")
+	assertState(t, ThreadsFriday)
+
+	flow := currentStandupFlows[testUser]
+	if len(flow.FridayThreadEvents) != 1 || len(flow.WeekendThreadEvents) != 1 || len(flow.YesterdayThreadEvents) != 0 {
+		t.Fatalf("Expected Friday and Weekend threads and no Yesterday thread, got %v, %v and %v",
+			flow.FridayThreadEvents, flow.WeekendThreadEvents, flow.YesterdayThreadEvents)
+	}
+
+	hs.sendThreadReply(flow.WeekendThreadEvents[0], "Went hiking")
+	if len(flow.Weekend) != 1 || flow.Weekend[0].Body != "Went hiking" {
+		t.Fatalf("Expected the weekend item to be added, got %v", flow.Weekend)
+	}
+}
+
+func TestEdits(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	hs.sendText("!su room " + testSendRoom.String())
+	hs.sendText("!su new")
+
+	yesterdayItems := answerQuestion(t, hs, "What did you do yesterday?", "Wrote tests")
+	todayItems := answerQuestion(t, hs, "What are you planning to do today?", "Fix bugs")
+	answerQuestion(t, hs, "Do you have any blockers?")
+	answerQuestion(t, hs, "Do you have any other notes?")
+	preview := hs.lastMessage(t, testConfigRoom)
+
+	// Editing an item before sending edits the preview.
+	hs.sendEdit(todayItems[0].ID, "Fix all the bugs")
+	if body := currentStandupFlows[testUser].Today[0].Body; body != "Fix all the bugs" {
+		t.Fatalf("Expected the item to be edited, got %q", body)
+	}
+	edit := hs.lastMessage(t, testConfigRoom).Content.AsMessage()
+	if edit.RelatesTo == nil || edit.RelatesTo.EventID != preview.ID {
+		t.Fatalf("Expected the preview to be edited, got %+v", edit)
+	}
+	assertContains(t, edit.NewContent.Body, "- Fix all the bugs")
+
+	hs.react(preview.ID, CHECKMARK)
+	post := sentPost(t, hs)
+	assertContains(t, post.Content.AsMessage().Body, "- Fix all the bugs")
+
+	// Editing an item after sending shows a new preview, and confirming it
+	// edits the post in the send room.
+	hs.sendEdit(yesterdayItems[0].ID, "Wrote more tests")
+	if !hs.isRedacted(testConfigRoom, preview.ID) {
+		t.Error("Expected the old preview to be redacted")
+	}
+	newPreview := hs.lastMessage(t, testConfigRoom)
+	assertContains(t, newPreview.Content.AsMessage().Body, "- Wrote more tests", "Send Edit")
+
+	hs.react(newPreview.ID, CHECKMARK)
+	posts := hs.messages(testSendRoom)
+	if len(posts) != 2 {
+		t.Fatalf("Expected the post to be edited, got %d messages in the send room", len(posts))
+	}
+	postEdit := posts[1].Content.AsMessage()
+	if postEdit.RelatesTo == nil || postEdit.RelatesTo.Type != mevent.RelReplace || postEdit.RelatesTo.EventID != post.ID {
+		t.Fatalf("Expected an edit of %s, got %+v", post.ID, postEdit)
+	}
+	assertContains(t, postEdit.NewContent.Body, "- Wrote more tests", "- Fix all the bugs")
+	assertState(t, Sent)
+}
+
+func TestRedactions(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	hs.sendText("!su room " + testSendRoom.String())
+	hs.sendText("!su new")
+
+	answerQuestion(t, hs, "What did you do yesterday?", "Wrote tests")
+	todayItems := answerQuestion(t, hs, "What are you planning to do today?", "Fix bugs", "Review PRs", "Have lunch")
+
+	// Redacting an item before the preview exists just removes it.
+	hs.redact(todayItems[2].ID)
+	if today := currentStandupFlows[testUser].Today; len(today) != 2 {
+		t.Fatalf("Expected two items for today, got %v", today)
+	}
+
+	answerQuestion(t, hs, "Do you have any blockers?")
+	answerQuestion(t, hs, "Do you have any other notes?")
+	preview := hs.lastMessage(t, testConfigRoom)
+
+	// Redacting an item after the preview was shown edits the preview.
+	hs.redact(todayItems[1].ID)
+	edit := hs.lastMessage(t, testConfigRoom).Content.AsMessage()
+	if edit.RelatesTo == nil || edit.RelatesTo.EventID != preview.ID {
+		t.Fatalf("Expected the preview to be edited, got %+v", edit)
+	}
+	assertContains(t, edit.NewContent.Body, "- Fix bugs")
+	assertNotContains(t, edit.NewContent.Body, "- Review PRs", "- Have lunch")
+
+	hs.react(preview.ID, CHECKMARK)
+	body := sentPost(t, hs).Content.AsMessage().Body
+	assertContains(t, body, "**Today**\n- Fix bugs")
+	assertNotContains(t, body, "- Review PRs", "- Have lunch")
+}
+
+func TestUndo(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	hs.sendText("!su room " + testSendRoom.String())
+
+	hs.sendText("!su undo")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "No sent standup post to undo")
+
+	hs.sendText("!su new")
+	answerQuestion(t, hs, "What did you do yesterday?", "Wrote tests")
+	answerQuestion(t, hs, "What are you planning to do today?")
+	answerQuestion(t, hs, "Do you have any blockers?")
+	answerQuestion(t, hs, "Do you have any other notes?")
+	confirmPreview(t, hs)
+	post := sentPost(t, hs)
+
+	hs.sendText("!su undo")
+	if !hs.isRedacted(testSendRoom, post.ID) {
+		t.Fatal("Expected the post to be redacted")
+	}
+	assertState(t, Confirm)
+
+	var previousPost PreviousPostEventContent
+	if err := hs.StateEvent(testConfigRoom, types.StatePreviousPost, "alice:example.com", &previousPost); err != nil {
+		t.Fatalf("No previous post state event: %+v", err)
+	}
+	if previousPost.EditEventID != "" {
+		t.Errorf("Expected the previous post to be cleared, got %s", previousPost.EditEventID)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	hs.sendText("!su new")
+	answerQuestion(t, hs, "What did you do yesterday?", "Wrote tests")
+	answerQuestion(t, hs, "What are you planning to do today?")
+	answerQuestion(t, hs, "Do you have any blockers?")
+	answerQuestion(t, hs, "Do you have any other notes?")
+
+	preview := hs.lastMessage(t, testConfigRoom)
+	hs.react(preview.ID, RED_X)
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "Standup post cancelled")
+	assertState(t, FlowNotStarted)
+	if len(hs.messages(testSendRoom)) != 0 {
+		t.Error("Expected nothing to be sent to the send room")
+	}
+}
+
+func TestMessagesOutsideConfigRoomAreIgnored(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	hs.sendText("!su new")
+
+	event := hs.addEvent("!other:example.com", testUser, mevent.EventMessage, mevent.Content{Parsed: &mevent.MessageEventContent{
+		MsgType: mevent.MsgText,
+		Body:    "Wrote tests",
+	}})
+	HandleMessage(event)
+	if yesterday := currentStandupFlows[testUser].Yesterday; len(yesterday) != 0 {
+		t.Fatalf("Expected the message to be ignored, got %v", yesterday)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	mid "maunium.net/go/mautrix/id"
+)
+
+var flowStateNames = map[StandupFlowState]string{
+	FlowNotStarted: "not started",
+	Yesterday:      "yesterday",
+	Friday:         "friday",
+	Weekend:        "weekend",
+	Today:          "today",
+	Blockers:       "blockers",
+	Notes:          "notes",
+	Confirm:        "confirm",
+	Sent:           "sent",
+	Threads:        "threads",
+	ThreadsFriday:  "threads (friday)",
+}
+
+func (s StandupFlowState) String() string {
+	if name, found := flowStateNames[s]; found {
+		return name
+	}
+	return "unknown"
+}
+
+// itemCount returns the number of items in all sections of the flow.
+func (flow *StandupFlow) itemCount() int {
+	return len(flow.Yesterday) + len(flow.Friday) + len(flow.Weekend) + len(flow.Today) + len(flow.Blockers) + len(flow.Notes)
+}
+
+// LoadFlows reads the standup flows that were saved to the given file.
+func LoadFlows(path string) (map[mid.UserID]*StandupFlow, error) {
+	flowsJson, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	flows := make(map[mid.UserID]*StandupFlow)
+	if err := json.Unmarshal(flowsJson, &flows); err != nil {
+		return nil, err
+	}
+	return flows, nil
+}
+
+// SaveFlows writes the standup flows to the given file so that they can be
+// loaded when the bot starts again.
+func SaveFlows(path string, flows map[mid.UserID]*StandupFlow) error {
+	flowsJson, err := json.Marshal(flows)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, flowsJson, 0600)
+}
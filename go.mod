@@ -5,8 +5,11 @@ go 1.16
 require (
 	github.com/google/uuid v1.3.0
 	github.com/kyoh86/xdg v1.2.0
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/sethvargo/go-retry v0.1.0
 	github.com/sirupsen/logrus v1.9.0
+	golang.org/x/crypto v0.0.0-20220315160706-3147a52a75dd
+	gopkg.in/yaml.v2 v2.4.0
 	maunium.net/go/mautrix v0.10.12
 )
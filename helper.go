@@ -1,19 +1,32 @@
 package main
 
 import (
-	"fmt"
+	"errors"
 	_ "strconv"
 	"time"
 
 	"github.com/sethvargo/go-retry"
 	log "github.com/sirupsen/logrus"
 	"maunium.net/go/mautrix"
-	mcrypto "maunium.net/go/mautrix/crypto"
 	mevent "maunium.net/go/mautrix/event"
 	mid "maunium.net/go/mautrix/id"
 )
 
+// permanentError wraps an error that does not go away by retrying, so that
+// DoRetry returns it right away and callers can tell it from temporary errors.
+type permanentError struct {
+	error
+}
+
+func (e permanentError) Unwrap() error {
+	return e.error
+}
+
 func DoRetry(description string, fn func() (interface{}, error)) (interface{}, error) {
+	// Let the shutdown wait for the retries to finish.
+	if done, ok := track(); ok {
+		defer done()
+	}
 	var err error
 	b, err := retry.NewFibonacci(1 * time.Second)
 	if err != nil {
@@ -27,6 +40,9 @@ func DoRetry(description string, fn func() (interface{}, error)) (interface{}, e
 		if err == nil {
 			log.Info(description, " succeeded")
 			return val, nil
+		} else if permanent := (permanentError{}); errors.As(err, &permanent) {
+			log.Debugf("  %s failed. Will not retry. Error: %+v", description, permanent.error)
+			return nil, err
 		}
 		nextDuration, stop := b.Next()
 		log.Debugf("  %s failed. Retrying in %f seconds. Error: %+v", description, nextDuration.Seconds(), err)
@@ -43,46 +59,26 @@ func SendMessage(roomId mid.RoomID, content *mevent.MessageEventContent) (resp *
 	return SendMessageOnBehalfOf(nil, roomId, content)
 }
 
+// SendMessageOnBehalfOf sends the message after the messages that are already
+// queued for the room, and returns once it is sent. If the bot stops before
+// sending it, it is sent when the bot starts again.
 func SendMessageOnBehalfOf(user *mid.UserID, roomId mid.RoomID, content *mevent.MessageEventContent) (resp *mautrix.RespSendEvent, err error) {
 	eventContent := &mevent.Content{Parsed: content}
-	if user != nil {
+	if user != nil && !puppeting(user, roomId) {
 		eventContent.Raw = map[string]interface{}{
 			"space.nevarro.msc3464.on_behalf_of": *user,
 		}
 	}
 
-	r, err := DoRetry(fmt.Sprintf("send message to %s on behalf of %s", roomId, user), func() (interface{}, error) {
-		if stateStore.IsEncrypted(roomId) {
-			log.Debugf("Sending encrypted event to %s", roomId)
-			encrypted, err := olmMachine.EncryptMegolmEvent(roomId, mevent.EventMessage, eventContent)
-
-			// These three errors mean we have to make a new Megolm session
-			if err == mcrypto.SessionExpired || err == mcrypto.SessionNotShared || err == mcrypto.NoGroupSession {
-				err = olmMachine.ShareGroupSession(roomId, stateStore.GetRoomMembers(roomId))
-				if err != nil {
-					log.Errorf("Failed to share group session to %s: %s", roomId, err)
-					return nil, err
-				}
-
-				encrypted, err = olmMachine.EncryptMegolmEvent(roomId, mevent.EventMessage, eventContent)
-			}
-
-			if err != nil {
-				log.Errorf("Failed to encrypt message to %s: %s", roomId, err)
-				return nil, err
-			}
+	return queueMessage(user, roomId, eventContent)
+}
 
-			encrypted.RelatesTo = content.RelatesTo // The m.relates_to field should be unencrypted, so copy it.
-			return client.SendMessageEvent(roomId, mevent.EventEncrypted, encrypted)
-		} else {
-			log.Debugf("Sending unencrypted event to %s", roomId)
-			return client.SendMessageEvent(roomId, mevent.EventMessage, eventContent)
+// isRoomMember returns whether the user is joined to the room.
+func isRoomMember(roomID mid.RoomID, userID mid.UserID) bool {
+	for _, memberID := range stateStore.GetRoomMembers(roomID) {
+		if memberID == userID {
+			return true
 		}
-	})
-	if err != nil {
-		// give up
-		log.Errorf("Failed to send message to %s: %s", roomId, err)
-		return nil, err
 	}
-	return r.(*mautrix.RespSendEvent), err
+	return false
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	mid "maunium.net/go/mautrix/id"
+)
+
+// The language that is used if the user has not chosen one.
+const defaultLanguage = "en"
+
+// messages maps each language code to the translations of the message keys.
+// Messages that are missing from a language fall back to English.
+var messages = map[string]map[string]string{
+	"en": messagesEn,
+	"de": messagesDe,
+}
+
+// T returns the message with the given key in the given language, formatted
+// with the given arguments.
+func T(lang, key string, args ...interface{}) string {
+	message, found := messages[lang][key]
+	if !found {
+		message, found = messages[defaultLanguage][key]
+		if !found {
+			return key
+		}
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+func isSupportedLanguage(lang string) bool {
+	_, found := messages[lang]
+	return found
+}
+
+// supportedLanguages returns the supported languages and their names, like
+// "de (Deutsch), en (English)".
+func supportedLanguages() string {
+	langs := make([]string, 0, len(messages))
+	for lang := range messages {
+		langs = append(langs, fmt.Sprintf("%s (%s)", lang, T(lang, "language.name")))
+	}
+	sort.Strings(langs)
+	return strings.Join(langs, ", ")
+}
+
+// languageFor returns the language that the bot uses to talk to the user.
+func languageFor(userID mid.UserID) string {
+	if lang := stateStore.GetLanguage(userID); isSupportedLanguage(lang) {
+		return lang
+	} else if lang := currentConfiguration().Defaults.Language; isSupportedLanguage(lang) {
+		return lang
+	}
+	return defaultLanguage
+}
+
+// postLanguageFor returns the language of the section headers in the user's
+// standup posts. The language configured for the send room takes precedence
+// over the user's language so that all posts in a room look the same.
+func postLanguageFor(userID mid.UserID) string {
+	if sendRoomID, err := stateStore.GetSendRoomId(userID); err == nil {
+		if lang := stateStore.GetRoomLanguage(sendRoomID); isSupportedLanguage(lang) {
+			return lang
+		}
+	}
+	return languageFor(userID)
+}
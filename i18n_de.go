@@ -0,0 +1,193 @@
+package main
+
+var messagesDe = map[string]string{
+	"language.name": "Deutsch",
+
+	// Questions
+	"question.friday":    "Was hast du am Freitag gemacht?",
+	"question.weekend":   "Was hast du am Wochenende gemacht?",
+	"question.yesterday": "Was hast du gestern gemacht?",
+	"question.today":     "Was hast du heute vor?",
+	"question.blockers":  "Gibt es etwas, das dich blockiert?",
+	"question.notes":     "Hast du sonst noch etwas anzumerken?",
+	"question.hint":      "Schreib einen Punkt pro Nachricht. Reagiere mit ✅, wenn du fertig bist.",
+	"threads.intro":      "**Fülle den Standup-Post aus, indem du in jedem Thread antwortest.** *Schreib einen Punkt pro Nachricht.*",
+	"threads.thread":     "Thread",
+
+	// Posts
+	"section.friday":         "Freitag",
+	"section.weekend":        "Wochenende",
+	"section.yesterday":      "Gestern",
+	"section.today":          "Heute",
+	"section.blockers":       "Blockaden",
+	"section.notes":          "Notizen",
+	"post.header":            "Standup-Post von %s:",
+	"preview.header":         "Vorschau des Standup-Posts:",
+	"preview.send":           "Senden (%s) oder Abbrechen (%s)?",
+	"preview.send_edit":      "Änderung senden (%s) oder Abbrechen (%s)?",
+	"send.no_room":           "Kein Raum zum Senden festgelegt! Lege einen mit `!standupbot room [Raum-ID oder Alias]` fest.",
+	"send.not_member":        "**Du bist kein Mitglied des festgelegten Raums!** Der Post wird nicht gesendet. Lege einen neuen Raum mit `!standupbot room [Raum-ID oder Alias]` fest.",
+	"send.failed":            "Der Standup-Post konnte nicht an [%s](https://matrix.to/#/%s) gesendet werden",
+	"send.failed_edit":       "Die Änderung des Standup-Posts konnte nicht an [%s](https://matrix.to/#/%s) gesendet werden",
+	"send.sent":              "Standup-Post an [%s](https://matrix.to/#/%s) gesendet",
+	"send.sent_edit":         "Änderung des Standup-Posts an [%s](https://matrix.to/#/%s) gesendet",
+	"send.no_previous":       "Keine Informationen zum vorherigen Post gefunden!",
+	"reminder":               "Zeit für deinen Standup-Post!",
+	"reminder.late":          "Zeit für deinen Standup-Post! (Entschuldige, dass diese Erinnerung zu spät kommt, der Bot war offline.)",
+	"reminder.writing":       "Du schreibst bereits einen Standup-Post! Wenn du von vorne beginnen möchtest, schreib `!standupbot new`",
+	"weekly.header":          "Wochenzusammenfassung von %s für die Woche vom %s:",
+	"weekly.preview_header":  "Vorschau der Wochenzusammenfassung:",
+	"weekly.items":           "Diese Woche",
+	"weekly.blocker_cleared": "(gelöst)",
+	"weekly.blocker_open":    "(noch offen)",
+	"weekly.no_posts":        "Du hast diese Woche keine Standup-Posts gesendet.",
+	"weekly.compile_failed":  "Deine Wochenzusammenfassung konnte nicht erstellt werden: %s",
+	"weekly.not_member":      "**Du bist kein Mitglied von %s!** Deine Wochenzusammenfassung wird nicht dorthin gesendet. Lege einen anderen Raum mit `!standupbot weeklyroom [Raum-ID oder Alias]` fest.",
+	"weekly.failed":          "Die Wochenzusammenfassung konnte nicht an [%s](https://matrix.to/#/%s) gesendet werden",
+	"weekly.sent":            "Wochenzusammenfassung an [%s](https://matrix.to/#/%s) gesendet",
+	"weekly.cancelled":       "Wochenzusammenfassung abgebrochen.",
+	"undecryptable":          "Ich konnte eine Nachricht, die du um %s gesendet hast, nicht entschlüsseln, deshalb wurde sie ignoriert. Bitte sende sie erneut.",
+	"verification.emoji":     "Verifiziere %s. Stelle sicher, dass die folgenden Emojis auf deinem Gerät angezeigt werden:",
+	"verification.decimal":   "Verifiziere %s. Stelle sicher, dass die folgenden Zahlen auf deinem Gerät angezeigt werden:",
+	"verification.confirm":   "Reagiere mit %s, wenn sie übereinstimmen, oder mit %s, wenn nicht.",
+	"verification.cancelled": "Verifizierung abgebrochen: %s",
+	"verification.success":   "Verifizierung erfolgreich!",
+
+	// Commands
+	"show.nothing":               "Es gibt keinen Standup-Post zum Anzeigen.",
+	"edit.threads":               "Du kannst !edit nicht verwenden, wenn du Threads benutzt. Antworte einfach im entsprechenden Thread.",
+	"edit.nothing":               "Es gibt keinen Standup-Post zum Bearbeiten.",
+	"edit.not_monday":            "Heute ist nicht Montag, deshalb kannst du Freitag nicht bearbeiten.",
+	"edit.not_monday_wkend":      "Heute ist nicht Montag, deshalb kannst du das Wochenende nicht bearbeiten.",
+	"edit.monday":                "Heute ist Montag, deshalb kannst du gestern nicht bearbeiten. Bearbeite stattdessen Freitag oder Wochenende.",
+	"undo.nothing":               "Es gibt keinen gesendeten Standup-Post zum Rückgängigmachen.",
+	"undo.no_send_room":          "Kein Raum zum Senden festgelegt. Es kann nichts rückgängig gemacht werden.",
+	"undo.no_previous":           "Es gibt keinen vorherigen Standup-Post zum Rückgängigmachen.",
+	"undo.failed":                "Der Standup-Post konnte nicht entfernt werden!",
+	"undo.done":                  "Standup-Post mit der ID %s in %s entfernt",
+	"cancel.nothing":             "Es gibt keinen Standup-Post zum Abbrechen.",
+	"cancel.done":                "Standup-Post abgebrochen",
+	"tz.not_set":                 "nicht festgelegt",
+	"tz.show":                    "Die Zeitzone ist %s",
+	"tz.invalid":                 "%s ist keine bekannte Zeitzone. Verwende den Namen aus der IANA-Zeitzonendatenbank, zum Beispiel 'Europe/Berlin'",
+	"tz.set":                     "Zeitzone auf %s gesetzt",
+	"tz.failed":                  "Die Zeitzone konnte nicht gesetzt werden: %s\nStelle sicher, dass standupbot Moderator oder Admin im Raum ist!",
+	"notify.not_set":             "Keine Benachrichtigungszeit festgelegt",
+	"notify.show":                "Die Benachrichtigungszeit ist %02d:%02d",
+	"notify.disabled":            "Benachrichtigungen deaktiviert",
+	"notify.disable_failed":      "Benachrichtigungen konnten nicht deaktiviert werden",
+	"notify.invalid":             "%s ist keine gültige Uhrzeit. Gib sie im 24-Stunden-Format an, zum Beispiel 13:30.",
+	"notify.set":                 "Benachrichtigungszeit auf %02d:%02d gesetzt",
+	"notify.failed":              "Die Benachrichtigungszeit konnte nicht gesetzt werden: %s\nStelle sicher, dass standupbot Moderator oder Admin im Raum ist!",
+	"threads.not_set":            "Die Thread-Einstellung ist nicht festgelegt. Standardmäßig werden keine Threads verwendet.",
+	"threads.enabled":            "Threads sind aktiviert.",
+	"threads.disabled":           "Threads sind nicht aktiviert.",
+	"threads.invalid":            "Die Thread-Einstellung konnte nicht gesetzt werden: %s ist ungültig. Verwende 'true' oder 'false'.",
+	"threads.failed":             "Die Thread-Einstellung konnte nicht gesetzt werden: %s",
+	"threads.set":                "Thread-Einstellung auf %s gesetzt",
+	"room.not_set":               "Kein Raum zum Senden festgelegt",
+	"room.show":                  "Standup-Posts werden an %s gesendet",
+	"room.join_failed":           "Konnte dem Raum %s nicht beitreten: %s",
+	"room.joined":                "%s beigetreten und als Raum zum Senden festgelegt",
+	"room.failed":                "Der Raum zum Senden konnte nicht gesetzt werden: %s\nStelle sicher, dass standupbot Moderator oder Admin im Raum ist!",
+	"weekly_room.not_set":        "Wochenzusammenfassungen werden an deinen Raum zum Senden gesendet",
+	"weekly_room.show":           "Wochenzusammenfassungen werden an %s gesendet",
+	"weekly_room.joined":         "%s beigetreten und als Raum für deine Wochenzusammenfassungen festgelegt",
+	"weekly_room.cleared":        "Wochenzusammenfassungen werden ab jetzt an deinen Raum zum Senden gesendet",
+	"weekly_room.failed":         "Der Raum für Wochenzusammenfassungen konnte nicht gesetzt werden: %s\nStelle sicher, dass standupbot Moderator oder Admin im Raum ist!",
+	"lang.show":                  "Deine Sprache ist %s. Verfügbare Sprachen: %s",
+	"lang.invalid":               "%s ist keine unterstützte Sprache. Verfügbare Sprachen: %s",
+	"lang.set":                   "Sprache auf %s gesetzt",
+	"lang.failed":                "Die Sprache konnte nicht gesetzt werden: %s\nStelle sicher, dass standupbot Moderator oder Admin im Raum ist!",
+	"roomlang.no_send_room":      "Kein Raum zum Senden festgelegt! Lege zuerst einen mit `!standupbot room [Raum-ID oder Alias]` fest.",
+	"roomlang.not_set":           "Für %s ist keine Sprache festgelegt, deshalb verwenden deine Standup-Posts deine eigene Sprache.",
+	"roomlang.show":              "Standup-Posts in %s verwenden %s.",
+	"roomlang.set":               "Standup-Posts in %s verwenden ab jetzt %s.",
+	"roomlang.cleared":           "Die Sprache von %s wurde entfernt. Standup-Posts verwenden die Sprache ihrer Verfasser.",
+	"roomlang.failed":            "Die Sprache des Raums konnte nicht gesetzt werden: %s\nStelle sicher, dass standupbot Moderator oder Admin im Raum ist!",
+	"upgrade.join_failed":        "%s wurde auf %s aktualisiert, aber ich konnte dem neuen Raum nicht beitreten: %s\nLade mich in den neuen Raum ein und richte ihn erneut ein.",
+	"upgrade.config_room":        "Dieser Raum ersetzt %s. Deine Einstellungen wurden hierher verschoben.",
+	"upgrade.config_room_failed": "Dieser Raum wurde auf %s aktualisiert, aber ich konnte deine Einstellungen nicht dorthin verschieben: %s\nStelle sicher, dass standupbot Moderator oder Admin im neuen Raum ist!",
+	"upgrade.send_room":          "Dein Raum zum Senden %s wurde aktualisiert. Standup-Posts werden jetzt an %s gesendet.",
+	"upgrade.send_room_failed":   "Dein Raum zum Senden %s wurde auf %s aktualisiert, aber ich konnte deinen Raum zum Senden nicht ändern: %s",
+	"upgrade.weekly_room":        "Dein Raum für Wochenzusammenfassungen %s wurde aktualisiert. Wochenzusammenfassungen werden jetzt an %s gesendet.",
+	"upgrade.weekly_room_failed": "Dein Raum für Wochenzusammenfassungen %s wurde auf %s aktualisiert, aber ich konnte ihn nicht ändern: %s",
+	"access.invite_rejected":     "Dieser Bot steht dir nicht zur Verfügung.",
+	"access.room_domain_denied":  "%s kann nicht als Raum zum Senden verwendet werden, weil Räume auf %s nicht erlaubt sind.",
+	"access.room_not_in_space":   "%s kann nicht als Raum zum Senden verwendet werden, weil er nicht im Space %s ist.",
+
+	// Command parsing and help
+	"command.parse_failed":            "Der Befehl konnte nicht verarbeitet werden: %s",
+	"command.unknown":                 "Unbekannter Befehl %s.",
+	"command.did_you_mean":            "Meintest du %s?",
+	"command.see_help":                "Verwende !su help, um die Liste der Befehle anzuzeigen.",
+	"command.invalid_args":            "Ungültige Argumente für %s: %s",
+	"args.not_enough":                 "zu wenige Argumente",
+	"args.too_many":                   "zu viele Argumente",
+	"args.invalid_choice":             "%s ist ungültig. Erlaubt sind %s",
+	"help.commands":                   "BEFEHLE:",
+	"help.usage_hint":                 "Verwende %s, um die genaue Verwendung eines Befehls anzuzeigen.",
+	"help.usage":                      "Verwendung",
+	"help.aliases":                    "Aliase",
+	"help.version":                    "Version %s.",
+	"help.source_code":                "Quellcode",
+	"command.new":                     "einen neuen Standup-Post beginnen",
+	"command.show":                    "den aktuellen Standup-Post anzeigen",
+	"command.edit":                    "den angegebenen Abschnitt des Standup-Posts bearbeiten",
+	"command.edit.details":            "Friday und Weekend können nur montags bearbeitet werden, Yesterday nur an den anderen Tagen. Das funktioniert nicht mit Threads. Antworte stattdessen im entsprechenden Thread.",
+	"command.cancel":                  "den aktuellen Standup-Post abbrechen",
+	"command.undo":                    "das Senden des aktuellen Standup-Posts rückgängig machen",
+	"command.help":                    "diese Hilfe anzeigen",
+	"command.help.details":            "Zeigt die Liste der Befehle oder die genaue Verwendung des angegebenen Befehls an.",
+	"command.vanquish":                "den Bot den Raum verlassen lassen",
+	"command.tz":                      "die Zeitzone für Benachrichtigungen anzeigen oder festlegen",
+	"command.tz.details":              "Verwende den Namen der Zeitzone aus der IANA-Zeitzonendatenbank, zum Beispiel Europe/Berlin.",
+	"command.notify":                  "die Uhrzeit der Standup-Benachrichtigung anzeigen oder festlegen",
+	"command.notify.details":          "Gib die Uhrzeit im 24-Stunden-Format in deiner Zeitzone an, zum Beispiel 13:30. Verwende stop, um Benachrichtigungen zu deaktivieren.",
+	"command.room":                    "den Raum anzeigen oder festlegen, an den deine Standup-Posts gesendet werden",
+	"command.room.details":            "Der Bot tritt dem Raum bei und sendet deine Standup-Posts dorthin. Gib einen Servernamen an, wenn der Bot dem Raum über einen bestimmten Server beitreten muss.",
+	"command.threads":                 "festlegen, ob Threads zum Schreiben von Standup-Posts verwendet werden",
+	"command.weekly":                  "die Zusammenfassung deiner Standup-Posts dieser Woche erstellen",
+	"command.weekly.details":          "Die Zusammenfassung listet die Einträge der Abschnitte Gestern und Heute aus den Posts dieser Woche je einmal auf, dazu die Blockaden und ob sie gelöst wurden. Reagiere mit ✅, um sie an deinen Raum für Wochenzusammenfassungen zu senden.",
+	"command.weeklyroom":              "den Raum anzeigen oder festlegen, an den deine Wochenzusammenfassungen gesendet werden",
+	"command.weeklyroom.details":      "Verwende none, um sie an deinen Raum zum Senden zu senden.",
+	"command.lang":                    "die Sprache anzeigen oder festlegen, in der der Bot mit dir spricht",
+	"command.lang.details":            "Die Abschnittsüberschriften deiner Standup-Posts verwenden auch diese Sprache, sofern für den Raum keine Sprache festgelegt ist.",
+	"command.roomlang":                "die Sprache der Standup-Posts in deinem Raum anzeigen oder festlegen",
+	"command.roomlang.details":        "Diese Sprache hat Vorrang vor der Sprache der Verfasser. Verwende none, um sie zu entfernen.",
+	"command.admin":                   "Befehle für die Admins des Bots",
+	"command.admin.users":             "die bekannten Nutzer und ihre Einstellungen auflisten",
+	"command.admin.rooms":             "die Räume auflisten, in denen der Bot ist",
+	"command.admin.leave":             "den Bot einen Raum verlassen lassen",
+	"command.admin.broadcast":         "eine Nachricht an den Konfigurationsraum jedes Nutzers senden",
+	"command.admin.broadcast.details": "Die Nachricht muss nicht in Anführungszeichen stehen.",
+	"command.admin.status":            "Version, Laufzeit und Sync-Status des Bots anzeigen",
+	"command.admin.reload":            "die Konfiguration neu laden",
+	"command.admin.reload.details":    "Nur das Log-Level, die Admins, die Befehlspräfixe, die Zugriffseinstellungen, die Standardeinstellungen und die Einstellungen der Wochenzusammenfassungen können ohne Neustart des Bots geändert werden. Das Senden von SIGHUP an den Bot lädt die Konfiguration ebenfalls neu.",
+
+	// Admin commands
+	"admin.unset":               "nicht festgelegt",
+	"admin.users.none":          "Es gibt keine bekannten Nutzer.",
+	"admin.users.header":        "%d bekannte Nutzer:",
+	"admin.users.config_room":   "Konfigurationsraum %s",
+	"admin.users.send_room":     "Raum zum Senden %s",
+	"admin.users.timezone":      "Zeitzone %s",
+	"admin.users.notify":        "Benachrichtigungszeit %s",
+	"admin.users.threads":       "Threads %s",
+	"admin.users.language":      "Sprache %s",
+	"admin.rooms.failed":        "Die Räume konnten nicht abgerufen werden: %s",
+	"admin.rooms.header":        "In %d Räumen:",
+	"admin.rooms.members":       "%d Mitglieder",
+	"admin.leave.failed":        "Konnte %s nicht verlassen: %s",
+	"admin.leave.done":          "%s verlassen",
+	"admin.broadcast.done":      "Die Nachricht wurde an %d von %d Konfigurationsräumen gesendet.",
+	"admin.status.version":      "Version: %s",
+	"admin.status.uptime":       "Laufzeit: %s",
+	"admin.status.appservice":   "Läuft als Appservice",
+	"admin.status.never_synced": "Sync: noch kein erfolgreicher Sync",
+	"admin.status.synced":       "Sync: letzter erfolgreicher Sync vor %s",
+	"admin.status.sync_failing": "Sync: %d-mal in Folge fehlgeschlagen, letzter Fehler: %s",
+	"admin.status.users":        "Bekannte Nutzer: %d",
+	"admin.reload.failed":       "Die Konfiguration konnte nicht neu geladen werden: %s",
+	"admin.reload.done":         "Die Konfiguration wurde neu geladen.",
+}
@@ -0,0 +1,193 @@
+package main
+
+var messagesEn = map[string]string{
+	"language.name": "English",
+
+	// Questions
+	"question.friday":    "What did you do Friday?",
+	"question.weekend":   "What did you do over the weekend?",
+	"question.yesterday": "What did you do yesterday?",
+	"question.today":     "What are you planning to do today?",
+	"question.blockers":  "Do you have any blockers?",
+	"question.notes":     "Do you have any other notes?",
+	"question.hint":      "Enter one item per message. React with ✅ when done.",
+	"threads.intro":      "**Fill out the standup post by replying in each thread.** *Enter one item per message.*",
+	"threads.thread":     "thread",
+
+	// Posts
+	"section.friday":         "Friday",
+	"section.weekend":        "Weekend",
+	"section.yesterday":      "Yesterday",
+	"section.today":          "Today",
+	"section.blockers":       "Blockers",
+	"section.notes":          "Notes",
+	"post.header":            "%s's standup post:",
+	"preview.header":         "Standup post preview:",
+	"preview.send":           "Send (%s) or Cancel (%s)?",
+	"preview.send_edit":      "Send Edit (%s) or Cancel (%s)?",
+	"send.no_room":           "No send room set! Set one using `!standupbot room [room ID or alias]`.",
+	"send.not_member":        "**You are not a member of the configured send room!** Refusing to send a message to the room. Set a new one using `!standupbot room [room ID or alias]`.",
+	"send.failed":            "Failed to send standup post to [%s](https://matrix.to/#/%s)",
+	"send.failed_edit":       "Failed to send standup post edit to [%s](https://matrix.to/#/%s)",
+	"send.sent":              "Sent standup post to [%s](https://matrix.to/#/%s)",
+	"send.sent_edit":         "Sent standup post edit to [%s](https://matrix.to/#/%s)",
+	"send.no_previous":       "No previous post info found!",
+	"reminder":               "Time to write your standup post!",
+	"reminder.late":          "Time to write your standup post! (Sorry that this reminder is late, the bot was offline.)",
+	"reminder.writing":       "Looks like you are already writing a standup post! If you want to start over, type `!standupbot new`",
+	"weekly.header":          "%s's weekly summary for the week of %s:",
+	"weekly.preview_header":  "Weekly summary preview:",
+	"weekly.items":           "This week",
+	"weekly.blocker_cleared": "(cleared)",
+	"weekly.blocker_open":    "(still open)",
+	"weekly.no_posts":        "You have not sent any standup posts this week.",
+	"weekly.compile_failed":  "Failed to compile your weekly summary: %s",
+	"weekly.not_member":      "**You are not a member of %s!** Refusing to send your weekly summary there. Set another room using `!standupbot weeklyroom [room ID or alias]`.",
+	"weekly.failed":          "Failed to send weekly summary to [%s](https://matrix.to/#/%s)",
+	"weekly.sent":            "Sent weekly summary to [%s](https://matrix.to/#/%s)",
+	"weekly.cancelled":       "Weekly summary cancelled.",
+	"undecryptable":          "I couldn't decrypt a message that you sent at %s, so it was ignored. Please send it again.",
+	"verification.emoji":     "Verifying %s. Make sure that the following emojis are shown on your device:",
+	"verification.decimal":   "Verifying %s. Make sure that the following numbers are shown on your device:",
+	"verification.confirm":   "React with %s if they match or with %s if they do not.",
+	"verification.cancelled": "Verification cancelled: %s",
+	"verification.success":   "Verification successful!",
+
+	// Commands
+	"show.nothing":               "No standup post to show.",
+	"edit.threads":               "You cannot use !edit when using threads. Just reply to the corresponding thread.",
+	"edit.nothing":               "No standup post to edit.",
+	"edit.not_monday":            "It's not Monday, so you can't go back to edit Friday.",
+	"edit.not_monday_wkend":      "It's not Monday, so you can't go back to edit the weekend.",
+	"edit.monday":                "It's Monday, so you can't go back to edit yesterday. Edit Friday or Weekend instead.",
+	"undo.nothing":               "No sent standup post to undo.",
+	"undo.no_send_room":          "No send room configured. Can't undo anything.",
+	"undo.no_previous":           "No previous standup post to undo.",
+	"undo.failed":                "Failed to redact the standup post!",
+	"undo.done":                  "Redacted standup post with ID: %s in %s",
+	"cancel.nothing":             "No standup post to cancel.",
+	"cancel.done":                "Standup post cancelled",
+	"tz.not_set":                 "not set",
+	"tz.show":                    "Timezone is set to %s",
+	"tz.invalid":                 "%s is not a recognized timezone. Use the name corresponding to a file in the IANA Time Zone database, such as 'America/New_York'",
+	"tz.set":                     "Timezone set to %s",
+	"tz.failed":                  "Failed setting timezone: %s\nCheck to make sure that standupbot is a mod/admin in the room!",
+	"notify.not_set":             "Notification time is not set",
+	"notify.show":                "Notification time is set to %02d:%02d",
+	"notify.disabled":            "Notifications successfully disabled",
+	"notify.disable_failed":      "Failed to disable notifications",
+	"notify.invalid":             "%s is not a valid time. Please specify it in 24-hour time like: 13:30.",
+	"notify.set":                 "Notification time set to %02d:%02d",
+	"notify.failed":              "Failed setting notification time: %s\nCheck to make sure that standupbot is a mod/admin in the room!",
+	"threads.not_set":            "Use threads setting is not set. Will default to not using threads.",
+	"threads.enabled":            "Using threads is enabled.",
+	"threads.disabled":           "Using threads is not enabled.",
+	"threads.invalid":            "Failed setting use threads option: %s is not valid. Use 'true' or 'false'.",
+	"threads.failed":             "Failed setting use threads option: %s",
+	"threads.set":                "Set use threads option to %s",
+	"room.not_set":               "Send room not set",
+	"room.show":                  "Send room is set to %s",
+	"room.join_failed":           "Could not join room %s: %s",
+	"room.joined":                "Joined %s and set that as your send room",
+	"room.failed":                "Failed setting send room: %s\nCheck to make sure that standupbot is a mod/admin in the room!",
+	"weekly_room.not_set":        "Weekly summaries are sent to your send room",
+	"weekly_room.show":           "Weekly summaries are sent to %s",
+	"weekly_room.joined":         "Joined %s and set that as the room for your weekly summaries",
+	"weekly_room.cleared":        "Weekly summaries will be sent to your send room",
+	"weekly_room.failed":         "Failed setting the weekly summary room: %s\nCheck to make sure that standupbot is a mod/admin in the room!",
+	"lang.show":                  "Your language is %s. Available languages: %s",
+	"lang.invalid":               "%s is not a supported language. Available languages: %s",
+	"lang.set":                   "Language set to %s",
+	"lang.failed":                "Failed setting language: %s\nCheck to make sure that standupbot is a mod/admin in the room!",
+	"roomlang.no_send_room":      "No send room set! Set one using `!standupbot room [room ID or alias]` first.",
+	"roomlang.not_set":           "No language is set for %s, so your standup posts use your own language.",
+	"roomlang.show":              "Standup posts in %s use %s.",
+	"roomlang.set":               "Standup posts in %s will use %s.",
+	"roomlang.cleared":           "Removed the language of %s. Standup posts will use the language of their author.",
+	"roomlang.failed":            "Failed setting the language of the send room: %s\nCheck to make sure that standupbot is a mod/admin in the send room!",
+	"upgrade.join_failed":        "%s was upgraded to %s, but I could not join the new room: %s\nInvite me to the new room and set it up again.",
+	"upgrade.config_room":        "This room replaces %s. Your settings were moved here.",
+	"upgrade.config_room_failed": "This room was upgraded to %s, but I could not move your settings there: %s\nCheck to make sure that standupbot is a mod/admin in the new room!",
+	"upgrade.send_room":          "Your send room %s was upgraded. Standup posts will now be sent to %s.",
+	"upgrade.send_room_failed":   "Your send room %s was upgraded to %s, but I could not update your send room: %s",
+	"upgrade.weekly_room":        "Your weekly room %s was upgraded. Weekly summaries will now be sent to %s.",
+	"upgrade.weekly_room_failed": "Your weekly room %s was upgraded to %s, but I could not update your weekly room: %s",
+	"access.invite_rejected":     "This bot is not available to you.",
+	"access.room_domain_denied":  "%s cannot be used as a send room because rooms on %s are not allowed.",
+	"access.room_not_in_space":   "%s cannot be used as a send room because it is not in the space %s.",
+
+	// Command parsing and help
+	"command.parse_failed":            "Could not parse the command: %s",
+	"command.unknown":                 "Unknown command %s.",
+	"command.did_you_mean":            "Did you mean %s?",
+	"command.see_help":                "Use !su help to see the list of commands.",
+	"command.invalid_args":            "Invalid arguments for %s: %s",
+	"args.not_enough":                 "not enough arguments",
+	"args.too_many":                   "too many arguments",
+	"args.invalid_choice":             "%s is not valid. Must be one of %s",
+	"help.commands":                   "COMMANDS:",
+	"help.usage_hint":                 "Use %s to show the detailed usage of a command.",
+	"help.usage":                      "Usage",
+	"help.aliases":                    "Aliases",
+	"help.version":                    "Version %s.",
+	"help.source_code":                "Source code",
+	"command.new":                     "prepare a new standup post",
+	"command.show":                    "show the current standup post",
+	"command.edit":                    "edit the given section of the standup post",
+	"command.edit.details":            "Friday and Weekend can only be edited on Mondays, and Yesterday can only be edited on other days. This does not work when using threads. Reply to the corresponding thread instead.",
+	"command.cancel":                  "cancel the current standup post",
+	"command.undo":                    "undo sending the current standup post to the send room",
+	"command.help":                    "show this help",
+	"command.help.details":            "Shows the list of commands, or the detailed usage of the given command.",
+	"command.vanquish":                "tell the bot to leave the room",
+	"command.tz":                      "show or set the timezone to use for configuring notifications",
+	"command.tz.details":              "Use the name of the timezone from the IANA Time Zone database, such as America/New_York.",
+	"command.notify":                  "show or set the time at which the standup notification will be sent",
+	"command.notify.details":          "Specify the time in 24-hour time in your timezone, like 13:30. Use stop to disable notifications.",
+	"command.room":                    "show or set the room where your standup notification will be sent",
+	"command.room.details":            "The bot joins the room and sends your standup posts there. Specify a server name if the bot needs to join the room via a particular server.",
+	"command.threads":                 "whether or not to use threads for composing standup posts",
+	"command.weekly":                  "compile the summary of your standup posts this week",
+	"command.weekly.details":          "The summary lists the items of the Yesterday and Today sections of this week's posts once, and the blockers that you raised with whether they were cleared. React with ✅ to send it to your weekly room.",
+	"command.weeklyroom":              "show or set the room where your weekly summaries will be sent",
+	"command.weeklyroom.details":      "Use none to send them to your send room.",
+	"command.lang":                    "show or set the language that the bot uses to talk to you",
+	"command.lang.details":            "The section headers in your standup posts also use this language, unless a language is set for the send room.",
+	"command.roomlang":                "show or set the language of the standup posts in your send room",
+	"command.roomlang.details":        "This overrides the language of the authors of the standup posts. Use none to remove it.",
+	"command.admin":                   "commands for the admins of the bot",
+	"command.admin.users":             "list the known users and their settings",
+	"command.admin.rooms":             "list the rooms that the bot is in",
+	"command.admin.leave":             "make the bot leave a room",
+	"command.admin.broadcast":         "send a notice to the config room of every user",
+	"command.admin.broadcast.details": "The message does not need to be quoted.",
+	"command.admin.status":            "show the version, uptime and sync status of the bot",
+	"command.admin.reload":            "reload the configuration",
+	"command.admin.reload.details":    "Only the log level, the admins, the command prefixes, the access control settings, the defaults and the weekly summary settings can be changed without restarting the bot. Sending SIGHUP to the bot also reloads the configuration.",
+
+	// Admin commands
+	"admin.unset":               "not set",
+	"admin.users.none":          "There are no known users.",
+	"admin.users.header":        "%d known users:",
+	"admin.users.config_room":   "config room %s",
+	"admin.users.send_room":     "send room %s",
+	"admin.users.timezone":      "timezone %s",
+	"admin.users.notify":        "notification time %s",
+	"admin.users.threads":       "threads %s",
+	"admin.users.language":      "language %s",
+	"admin.rooms.failed":        "Failed to get the joined rooms: %s",
+	"admin.rooms.header":        "Joined %d rooms:",
+	"admin.rooms.members":       "%d members",
+	"admin.leave.failed":        "Failed to leave %s: %s",
+	"admin.leave.done":          "Left %s",
+	"admin.broadcast.done":      "Sent the message to %d of %d config rooms.",
+	"admin.status.version":      "Version: %s",
+	"admin.status.uptime":       "Uptime: %s",
+	"admin.status.appservice":   "Running as an appservice",
+	"admin.status.never_synced": "Sync: no successful sync yet",
+	"admin.status.synced":       "Sync: last successful sync %s ago",
+	"admin.status.sync_failing": "Sync: failed %d times in a row, last error: %s",
+	"admin.status.users":        "Known users: %d",
+	"admin.reload.failed":       "Failed to reload the configuration: %s",
+	"admin.reload.done":         "Reloaded the configuration.",
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCatalogsAreComplete(t *testing.T) {
+	for lang, catalog := range messages {
+		for key := range messagesEn {
+			if _, found := catalog[key]; !found {
+				t.Errorf("%s is missing %s", lang, key)
+			}
+		}
+		for key := range catalog {
+			if _, found := messagesEn[key]; !found {
+				t.Errorf("%s has %s, which is not in the English catalog", lang, key)
+			}
+		}
+	}
+}
+
+func TestUserLanguage(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	hs.sendText("!su room " + testSendRoom.String())
+	hs.sendText("!su lang fr")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "fr is not a supported language", "de (Deutsch)")
+
+	hs.sendText("!su lang de")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "Sprache auf Deutsch gesetzt")
+	if stateStore.GetLanguage(testUser) != "de" {
+		t.Fatal("Expected the language to be stored")
+	}
+
+	hs.sendText("!su new")
+	answerQuestion(t, hs, "Was hast du gestern gemacht?", "Tests geschrieben")
+	answerQuestion(t, hs, "Was hast du heute vor?", "Fehler beheben")
+	answerQuestion(t, hs, "Gibt es etwas, das dich blockiert?")
+	answerQuestion(t, hs, "Hast du sonst noch etwas anzumerken?")
+	preview := hs.lastMessage(t, testConfigRoom)
+	assertContains(t, preview.Content.AsMessage().Body, "Vorschau des Standup-Posts:", "Senden (✅) oder Abbrechen (❌)?")
+	hs.react(preview.ID, CHECKMARK)
+
+	body := sentPost(t, hs).Content.AsMessage().Body
+	assertContains(t, body, "Standup-Post von @alice:example.com:", "**Gestern**\n- Tests geschrieben", "**Heute**\n- Fehler beheben")
+}
+
+func TestRoomLanguage(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	hs.sendText("!su roomlang")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "No send room set!")
+
+	hs.sendText("!su room " + testSendRoom.String())
+	hs.sendText("!su lang de")
+	hs.sendText("!su roomlang en")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "Standup-Posts in !send:example.com verwenden ab jetzt English.")
+
+	hs.sendText("!su new")
+	answerQuestion(t, hs, "Was hast du gestern gemacht?", "Tests geschrieben")
+	answerQuestion(t, hs, "Was hast du heute vor?")
+	answerQuestion(t, hs, "Gibt es etwas, das dich blockiert?")
+	answerQuestion(t, hs, "Hast du sonst noch etwas anzumerken?")
+	hs.react(hs.lastMessage(t, testConfigRoom).ID, CHECKMARK)
+
+	// The send room's language takes precedence over the author's.
+	body := sentPost(t, hs).Content.AsMessage().Body
+	assertContains(t, body, "@alice:example.com's standup post:", "**Yesterday**\n- Tests geschrieben")
+
+	hs.sendText("!su roomlang none")
+	if stateStore.GetRoomLanguage(testSendRoom) != "" {
+		t.Error("Expected the room language to be removed")
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"maunium.net/go/mautrix"
+	mcrypto "maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/olm"
+	"maunium.net/go/mautrix/crypto/utils"
+	mid "maunium.net/go/mautrix/id"
+)
+
+// Server-side key backup using the m.megolm_backup.v1.curve25519-aes-sha2
+// algorithm. See https://spec.matrix.org/v1.3/client-server-api/#server-side-key-backups
+
+const keyBackupAlgorithm = "m.megolm_backup.v1.curve25519-aes-sha2"
+
+type KeyBackupAuthData struct {
+	PublicKey  string                           `json:"public_key"`
+	Signatures map[mid.UserID]map[string]string `json:"signatures,omitempty"`
+}
+
+type KeyBackupVersion struct {
+	Algorithm string            `json:"algorithm"`
+	AuthData  KeyBackupAuthData `json:"auth_data"`
+	Version   string            `json:"version,omitempty"`
+}
+
+type KeyBackupSessionData struct {
+	Algorithm         mid.Algorithm     `json:"algorithm"`
+	ForwardingChains  []string          `json:"forwarding_curve25519_key_chain"`
+	SenderClaimedKeys map[string]string `json:"sender_claimed_keys"`
+	SenderKey         mid.SenderKey     `json:"sender_key"`
+	SessionKey        string            `json:"session_key"`
+}
+
+type EncryptedKeyBackupSessionData struct {
+	Ciphertext string `json:"ciphertext"`
+	Ephemeral  string `json:"ephemeral"`
+	MAC        string `json:"mac"`
+}
+
+type KeyBackupData struct {
+	FirstMessageIndex uint32                        `json:"first_message_index"`
+	ForwardedCount    int                           `json:"forwarded_count"`
+	IsVerified        bool                          `json:"is_verified"`
+	SessionData       EncryptedKeyBackupSessionData `json:"session_data"`
+}
+
+type RoomKeyBackup struct {
+	Sessions map[mid.SessionID]KeyBackupData `json:"sessions"`
+}
+
+type KeysBackup struct {
+	Rooms map[mid.RoomID]RoomKeyBackup `json:"rooms"`
+}
+
+type KeyBackup struct {
+	Version    string
+	privateKey []byte
+	publicKey  []byte
+
+	uploaded map[mid.SessionID]uint32
+}
+
+// readOrGenerateRecoveryKey reads the recovery key from RecoveryKeyFile. If the
+// file does not exist, a new recovery key is generated and written to it.
+func readOrGenerateRecoveryKey() ([]byte, error) {
+	recoveryKey, err := readSecretFile(configuration.RecoveryKeyFile)
+	if err == nil {
+		privateKey := utils.DecodeBase58RecoveryKey(recoveryKey)
+		if privateKey == nil {
+			return nil, fmt.Errorf("%s does not contain a valid recovery key", configuration.RecoveryKeyFile)
+		}
+		return privateKey, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	privateKey := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(privateKey); err != nil {
+		return nil, err
+	}
+	recoveryKey = utils.EncodeBase58RecoveryKey(privateKey)
+	if err := os.WriteFile(configuration.RecoveryKeyFile, []byte(recoveryKey+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	log.Infof("Generated a new recovery key and saved it to %s", configuration.RecoveryKeyFile)
+	return privateKey, nil
+}
+
+// SetupKeyBackup finds the current key backup version on the server, or
+// creates a new one if there is none. It refuses to use a backup version that
+// was created with a different recovery key.
+func SetupKeyBackup() (*KeyBackup, error) {
+	privateKey, err := readOrGenerateRecoveryKey()
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	backup := &KeyBackup{
+		privateKey: privateKey,
+		publicKey:  publicKey,
+		uploaded:   map[mid.SessionID]uint32{},
+	}
+	encodedPublicKey := base64.RawStdEncoding.EncodeToString(publicKey)
+
+	var version KeyBackupVersion
+	_, err = client.MakeRequest("GET", client.BuildURL("room_keys", "version"), nil, &version)
+	if errors.Is(err, mautrix.MNotFound) {
+		log.Info("No key backup found on the server, creating one")
+		version = KeyBackupVersion{
+			Algorithm: keyBackupAlgorithm,
+			AuthData:  KeyBackupAuthData{PublicKey: encodedPublicKey},
+		}
+		if account, err := olmMachine.CryptoStore.GetAccount(); err == nil && account != nil {
+			signature, err := account.Internal.SignJSON(version.AuthData)
+			if err == nil {
+				version.AuthData.Signatures = map[mid.UserID]map[string]string{
+					client.UserID: {fmt.Sprintf("ed25519:%s", client.DeviceID): signature},
+				}
+			}
+		}
+		var resp KeyBackupVersion
+		if _, err = client.MakeRequest("POST", client.BuildURL("room_keys", "version"), version, &resp); err != nil {
+			return nil, fmt.Errorf("failed to create key backup version: %w", err)
+		}
+		backup.Version = resp.Version
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get key backup version: %w", err)
+	} else if version.Algorithm != keyBackupAlgorithm {
+		return nil, fmt.Errorf("unsupported key backup algorithm %s", version.Algorithm)
+	} else if version.AuthData.PublicKey != encodedPublicKey {
+		return nil, fmt.Errorf("key backup version %s was created with a different recovery key", version.Version)
+	} else {
+		backup.Version = version.Version
+	}
+	log.Infof("Using key backup version %s", backup.Version)
+	return backup, nil
+}
+
+// Restore imports all of the sessions in the key backup that are not already
+// in the crypto store.
+func (backup *KeyBackup) Restore() error {
+	var keys KeysBackup
+	url := client.BuildURLWithQuery(mautrix.URLPath{"room_keys", "keys"}, map[string]string{"version": backup.Version})
+	if _, err := client.MakeRequest("GET", url, nil, &keys); err != nil {
+		return err
+	}
+
+	count := 0
+	for roomID, room := range keys.Rooms {
+		for sessionID, data := range room.Sessions {
+			sessionData, err := backup.decrypt(data.SessionData)
+			if err != nil {
+				log.Warnf("Failed to decrypt backed up session %s: %+v", sessionID, err)
+				continue
+			}
+			imported, err := importBackedUpSession(roomID, sessionID, sessionData)
+			if err != nil {
+				log.Warnf("Failed to import backed up session %s: %+v", sessionID, err)
+				continue
+			} else if imported {
+				count++
+			}
+			backup.uploaded[sessionID] = data.FirstMessageIndex
+		}
+	}
+	log.Infof("Restored %d sessions from key backup version %s", count, backup.Version)
+	return nil
+}
+
+func importBackedUpSession(roomID mid.RoomID, sessionID mid.SessionID, sessionData *KeyBackupSessionData) (bool, error) {
+	if sessionData.Algorithm != mid.AlgorithmMegolmV1 {
+		return false, fmt.Errorf("unsupported session algorithm %s", sessionData.Algorithm)
+	}
+	internal, err := olm.InboundGroupSessionImport([]byte(sessionData.SessionKey))
+	if err != nil {
+		return false, err
+	} else if internal.ID() != sessionID {
+		return false, fmt.Errorf("session has ID %s", internal.ID())
+	}
+	session := &mcrypto.InboundGroupSession{
+		Internal:         *internal,
+		SigningKey:       mid.Ed25519(sessionData.SenderClaimedKeys["ed25519"]),
+		SenderKey:        sessionData.SenderKey,
+		RoomID:           roomID,
+		ForwardingChains: sessionData.ForwardingChains,
+	}
+	existing, _ := olmMachine.CryptoStore.GetGroupSession(roomID, session.SenderKey, sessionID)
+	if existing != nil && existing.Internal.FirstKnownIndex() <= session.Internal.FirstKnownIndex() {
+		return false, nil
+	}
+	return true, olmMachine.CryptoStore.PutGroupSession(roomID, session.SenderKey, sessionID, session)
+}
+
+// Upload uploads all of the sessions in the crypto store that have not been
+// uploaded yet, or that are now known from an earlier message index.
+func (backup *KeyBackup) Upload() error {
+	sessions, err := olmMachine.CryptoStore.GetAllGroupSessions()
+	if err != nil {
+		return err
+	}
+
+	keys := KeysBackup{Rooms: map[mid.RoomID]RoomKeyBackup{}}
+	count := 0
+	for _, session := range sessions {
+		firstKnownIndex := session.Internal.FirstKnownIndex()
+		if uploadedIndex, found := backup.uploaded[session.ID()]; found && uploadedIndex <= firstKnownIndex {
+			continue
+		}
+		sessionKey, err := session.Internal.Export(firstKnownIndex)
+		if err != nil {
+			log.Warnf("Failed to export session %s: %+v", session.ID(), err)
+			continue
+		}
+		forwardingChains := session.ForwardingChains
+		if forwardingChains == nil {
+			forwardingChains = []string{}
+		}
+		encrypted, err := backup.encrypt(&KeyBackupSessionData{
+			Algorithm:         mid.AlgorithmMegolmV1,
+			ForwardingChains:  forwardingChains,
+			SenderClaimedKeys: map[string]string{"ed25519": session.SigningKey.String()},
+			SenderKey:         session.SenderKey,
+			SessionKey:        sessionKey,
+		})
+		if err != nil {
+			log.Warnf("Failed to encrypt session %s for backup: %+v", session.ID(), err)
+			continue
+		}
+		if _, found := keys.Rooms[session.RoomID]; !found {
+			keys.Rooms[session.RoomID] = RoomKeyBackup{Sessions: map[mid.SessionID]KeyBackupData{}}
+		}
+		keys.Rooms[session.RoomID].Sessions[session.ID()] = KeyBackupData{
+			FirstMessageIndex: firstKnownIndex,
+			ForwardedCount:    len(session.ForwardingChains),
+			SessionData:       *encrypted,
+		}
+		count++
+	}
+	if count == 0 {
+		return nil
+	}
+
+	url := client.BuildURLWithQuery(mautrix.URLPath{"room_keys", "keys"}, map[string]string{"version": backup.Version})
+	if _, err := client.MakeRequest("PUT", url, keys, nil); err != nil {
+		return err
+	}
+	for _, room := range keys.Rooms {
+		for sessionID, data := range room.Sessions {
+			backup.uploaded[sessionID] = data.FirstMessageIndex
+		}
+	}
+	log.Infof("Uploaded %d sessions to key backup version %s", count, backup.Version)
+	return nil
+}
+
+// StartKeyBackupLoop periodically uploads new sessions to the key backup.
+func (backup *KeyBackup) StartKeyBackupLoop() {
+	go func() {
+		for {
+			if err := backup.Upload(); err != nil {
+				log.Errorf("Failed to upload sessions to key backup: %+v", err)
+			}
+			if !sleepContext(shutdownCtx, 5*time.Minute) {
+				return
+			}
+		}
+	}()
+}
+
+// deriveKeys derives the AES key, MAC key and AES IV from the shared secret.
+func deriveKeys(sharedSecret []byte) (aesKey, macKey, iv []byte, err error) {
+	derived := make([]byte, 80)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, sharedSecret, make([]byte, 32), nil), derived); err != nil {
+		return
+	}
+	return derived[:32], derived[32:64], derived[64:], nil
+}
+
+// backupMAC computes the MAC of the backup data. Due to a bug in libolm, the
+// MAC is calculated over an empty string instead of the ciphertext, and all
+// clients are compatible with that.
+func backupMAC(macKey []byte) []byte {
+	mac := hmac.New(sha256.New, macKey)
+	return mac.Sum(nil)[:8]
+}
+
+func (backup *KeyBackup) encrypt(sessionData *KeyBackupSessionData) (*EncryptedKeyBackupSessionData, error) {
+	plaintext, err := json.Marshal(sessionData)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeralPrivateKey := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeralPrivateKey); err != nil {
+		return nil, err
+	}
+	ephemeralPublicKey, err := curve25519.X25519(ephemeralPrivateKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	sharedSecret, err := curve25519.X25519(ephemeralPrivateKey, backup.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	aesKey, macKey, iv, err := deriveKeys(sharedSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	plaintext = append(plaintext, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+
+	return &EncryptedKeyBackupSessionData{
+		Ciphertext: base64.RawStdEncoding.EncodeToString(ciphertext),
+		Ephemeral:  base64.RawStdEncoding.EncodeToString(ephemeralPublicKey),
+		MAC:        base64.RawStdEncoding.EncodeToString(backupMAC(macKey)),
+	}, nil
+}
+
+func (backup *KeyBackup) decrypt(encrypted EncryptedKeyBackupSessionData) (*KeyBackupSessionData, error) {
+	ciphertext, err := base64.RawStdEncoding.DecodeString(encrypted.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralPublicKey, err := base64.RawStdEncoding.DecodeString(encrypted.Ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := base64.RawStdEncoding.DecodeString(encrypted.MAC)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedSecret, err := curve25519.X25519(backup.privateKey, ephemeralPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	aesKey, macKey, iv, err := deriveKeys(sharedSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, backupMAC(macKey)) {
+		return nil, errors.New("MAC mismatch")
+	}
+
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid ciphertext length")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, errors.New("invalid padding")
+	}
+	plaintext = plaintext[:len(plaintext)-padding]
+
+	var sessionData KeyBackupSessionData
+	if err := json.Unmarshal(plaintext, &sessionData); err != nil {
+		return nil, err
+	}
+	return &sessionData, nil
+}
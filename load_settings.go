@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix"
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+
+	"github.com/beeper/standupbot/types"
+)
+
+// LoadSettings fills the settings cache. The settings are cached in the
+// database, so they are only loaded from room state until that succeeded for
+// all config rooms, for example because the database was lost or because some
+// rooms could not be loaded the last time.
+func LoadSettings() {
+	users, err := stateStore.LoadSettings()
+	if err != nil {
+		log.Errorf("Failed to load the settings from the database: %+v", err)
+	} else {
+		log.Infof("Loaded the settings of %d users from the database", users)
+		loaded, err := stateStore.SettingsLoadedFromState()
+		if err != nil {
+			log.Errorf("Failed to check whether the settings were loaded from the config rooms: %+v", err)
+		} else if loaded {
+			return
+		}
+	}
+
+	log.Info("Loading settings from the config rooms...")
+	users, err = LoadSettingsFromState()
+	if err != nil {
+		log.Errorf("Failed to load the settings from the config rooms, trying again when the bot starts: %+v", err)
+		return
+	}
+	log.Infof("Loaded the settings of %d users from the config rooms", users)
+	if err := stateStore.SetSettingsLoadedFromState(); err != nil {
+		log.Errorf("Failed to record that the settings were loaded from the config rooms: %+v", err)
+	}
+}
+
+// LoadSettingsFromState loads the settings of the users from the state of
+// their config rooms and returns the number of users that had settings. Config
+// rooms are direct chats, so only the rooms that the bot and exactly one user
+// are joined to are checked, with a single state request each. If some of the
+// rooms could not be checked, the others are still loaded, but it returns an
+// error. The languages of the users' send rooms are loaded as well.
+func LoadSettingsFromState() (int, error) {
+	joinedRooms, err := matrixClient.JoinedRooms()
+	if err != nil {
+		return 0, err
+	}
+
+	users := 0
+	failed := 0
+	for _, roomID := range joinedRooms.JoinedRooms {
+		members, err := matrixClient.JoinedMembers(roomID)
+		if err != nil {
+			log.Warnf("Could not get the members of %s: %v", roomID, err)
+			failed++
+			continue
+		}
+		if _, found := members.Joined[mid.UserID(configuration.Username)]; !found || len(members.Joined) != 2 {
+			continue
+		}
+		var userID mid.UserID
+		for memberID := range members.Joined {
+			if memberID != mid.UserID(configuration.Username) {
+				userID = memberID
+			}
+		}
+
+		state, err := matrixClient.State(roomID)
+		if err != nil {
+			log.Warnf("Could not get the state of %s: %v", roomID, err)
+			failed++
+			continue
+		}
+		if loadUserSettingsFromState(userID, roomID, state) {
+			users++
+		}
+	}
+
+	// The languages of the send rooms are stored in the send rooms, which
+	// are not direct chats.
+	joined := map[mid.RoomID]bool{}
+	for _, roomID := range joinedRooms.JoinedRooms {
+		joined[roomID] = true
+	}
+	for _, user := range stateStore.GetKnownUsers() {
+		if !joined[user.SendRoomID] {
+			continue
+		}
+		var languageEventContent types.LanguageEventContent
+		err := matrixClient.StateEvent(user.SendRoomID, types.StateRoomLanguage, "", &languageEventContent)
+		if err == nil {
+			stateStore.SetRoomLanguage(user.SendRoomID, languageEventContent.Language)
+		} else if !errors.Is(err, mautrix.MNotFound) {
+			log.Warnf("Could not get the language of %s: %v", user.SendRoomID, err)
+			failed++
+		}
+		// Each send room is only checked once.
+		delete(joined, user.SendRoomID)
+	}
+	if failed > 0 {
+		return users, fmt.Errorf("could not check %d of %d rooms", failed, len(joinedRooms.JoinedRooms))
+	}
+	return users, nil
+}
+
+// loadUserSettingsFromState caches the settings of the user that are in the
+// state of their config room, and returns whether there were any.
+func loadUserSettingsFromState(userID mid.UserID, roomID mid.RoomID, state mautrix.RoomStateMap) bool {
+	stateKey := strings.TrimPrefix(userID.String(), "@")
+	found := false
+
+	var tzSettingEventContent types.TzSettingEventContent
+	if stateContent(state, types.StateTzSetting, stateKey, &tzSettingEventContent) {
+		if location, err := time.LoadLocation(tzSettingEventContent.TzString); err == nil {
+			log.Debugf("Loaded timezone (%s) for %s from state", location, userID)
+			stateStore.SetTimezone(userID, location.String())
+			found = true
+		}
+	}
+
+	var notifyEventContent types.NotifyEventContent
+	if stateContent(state, types.StateNotify, stateKey, &notifyEventContent) && notifyEventContent.MinutesAfterMidnight != nil {
+		log.Debugf("Loaded notification minutes after midnight (%d) for %s from state", *notifyEventContent.MinutesAfterMidnight, userID)
+		stateStore.SetNotify(userID, *notifyEventContent.MinutesAfterMidnight)
+		found = true
+	}
+
+	var sendRoomEventContent types.SendRoomEventContent
+	if stateContent(state, types.StateSendRoom, stateKey, &sendRoomEventContent) {
+		log.Debugf("Loaded send room (%s) for %s from state", sendRoomEventContent.SendRoomID, userID)
+		stateStore.SetSendRoomId(userID, sendRoomEventContent.SendRoomID)
+		found = true
+	}
+
+	var weeklyRoomEventContent types.WeeklyRoomEventContent
+	if stateContent(state, types.StateWeeklyRoom, stateKey, &weeklyRoomEventContent) {
+		log.Debugf("Loaded weekly room (%s) for %s from state", weeklyRoomEventContent.WeeklyRoomID, userID)
+		stateStore.SetWeeklyRoomId(userID, weeklyRoomEventContent.WeeklyRoomID)
+		found = true
+	}
+
+	var useThreadsEventContent types.UseThreadsEventContent
+	if stateContent(state, types.StateUseThreads, stateKey, &useThreadsEventContent) {
+		log.Debugf("Loaded thread usage setting (%t) for %s from state", useThreadsEventContent.UseThreads, userID)
+		stateStore.SetUseThreads(userID, useThreadsEventContent.UseThreads)
+		found = true
+	}
+
+	var languageEventContent types.LanguageEventContent
+	if stateContent(state, types.StateLanguage, stateKey, &languageEventContent) {
+		log.Debugf("Loaded language (%s) for %s from state", languageEventContent.Language, userID)
+		stateStore.SetLanguage(userID, languageEventContent.Language)
+		found = true
+	}
+
+	if found {
+		stateStore.SetConfigRoom(userID, roomID)
+	}
+	return found
+}
+
+// stateContent parses the content of the state event with the type and state
+// key into outContent, and returns whether the room has such a state event.
+func stateContent(state mautrix.RoomStateMap, eventType mevent.Type, stateKey string, outContent interface{}) bool {
+	event, found := stateEvents(state, eventType)[stateKey]
+	if !found || len(event.Content.VeryRaw) == 0 {
+		return false
+	}
+	return json.Unmarshal(event.Content.VeryRaw, outContent) == nil
+}
+
+// stateEvents returns the state events of the type by their state key. The
+// types in the state map are compared by name, since mautrix does not know
+// that the bot's event types are state event types.
+func stateEvents(state mautrix.RoomStateMap, eventType mevent.Type) map[string]*mevent.Event {
+	for stateType, events := range state {
+		if stateType.Type == eventType.Type {
+			return events
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+
+	"github.com/beeper/standupbot/store"
+	"github.com/beeper/standupbot/types"
+)
+
+// newStateStore replaces the state store with one that uses the database,
+// like the bot does when it starts.
+func newStateStore(t *testing.T, db *sql.DB, hs *fakeHomeserver) {
+	t.Helper()
+	stateStore = store.NewStateStore(db, store.SQLite)
+	if err := stateStore.Upgrade(); err != nil {
+		t.Fatal(err)
+	}
+	stateStore.Client = hs
+}
+
+// offlineClient fails the test if the bot sends any request to the homeserver.
+type offlineClient struct {
+	t *testing.T
+}
+
+func (c offlineClient) fail(request string) error {
+	c.t.Helper()
+	c.t.Errorf("Unexpected %s request", request)
+	return errors.New("offline")
+}
+
+func (c offlineClient) SendMessageEvent(roomID mid.RoomID, eventType mevent.Type, contentJSON interface{}, extra ...mautrix.ReqSendEvent) (*mautrix.RespSendEvent, error) {
+	return nil, c.fail("send")
+}
+
+func (c offlineClient) SendStateEvent(roomID mid.RoomID, eventType mevent.Type, stateKey string, contentJSON interface{}) (*mautrix.RespSendEvent, error) {
+	return nil, c.fail("send state")
+}
+
+func (c offlineClient) StateEvent(roomID mid.RoomID, eventType mevent.Type, stateKey string, outContent interface{}) error {
+	return c.fail("state event " + eventType.Type)
+}
+
+func (c offlineClient) RedactEvent(roomID mid.RoomID, eventID mid.EventID, extra ...mautrix.ReqRedact) (*mautrix.RespSendEvent, error) {
+	return nil, c.fail("redact")
+}
+
+func (c offlineClient) SendReaction(roomID mid.RoomID, eventID mid.EventID, reaction string) (*mautrix.RespSendEvent, error) {
+	return nil, c.fail("reaction")
+}
+
+func (c offlineClient) JoinRoom(roomIDorAlias, serverName string, content interface{}) (*mautrix.RespJoinRoom, error) {
+	return nil, c.fail("join")
+}
+
+func (c offlineClient) LeaveRoom(roomID mid.RoomID, optionalReq ...*mautrix.ReqLeave) (*mautrix.RespLeaveRoom, error) {
+	return nil, c.fail("leave")
+}
+
+func (c offlineClient) MarkRead(roomID mid.RoomID, eventID mid.EventID) error {
+	return c.fail("read receipt")
+}
+
+func (c offlineClient) Members(roomID mid.RoomID, req ...mautrix.ReqMembers) (*mautrix.RespMembers, error) {
+	return nil, c.fail("members")
+}
+
+func (c offlineClient) JoinedMembers(roomID mid.RoomID) (*mautrix.RespJoinedMembers, error) {
+	return nil, c.fail("joined members")
+}
+
+func (c offlineClient) State(roomID mid.RoomID) (mautrix.RoomStateMap, error) {
+	return nil, c.fail("state")
+}
+
+func (c offlineClient) JoinedRooms() (*mautrix.RespJoinedRooms, error) {
+	return nil, c.fail("joined rooms")
+}
+
+func (c offlineClient) ResolveAlias(alias mid.RoomAlias) (*mautrix.RespAliasResolve, error) {
+	return nil, c.fail("resolve alias")
+}
+
+func TestLoadSettings(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	// The fake homeserver gets the members of the rooms from the state store.
+	joinRooms := func() {
+		for roomID, userIDs := range map[mid.RoomID][]mid.UserID{
+			testConfigRoom:       {testUser},
+			"!group:example.com": {testUser, "@bob:example.com"},
+		} {
+			for _, userID := range userIDs {
+				stateKey := userID.String()
+				stateStore.SetMembership(&mevent.Event{
+					RoomID:   roomID,
+					Type:     mevent.StateMember,
+					StateKey: &stateKey,
+					Content:  mevent.Content{Parsed: &mevent.MemberEventContent{Membership: mevent.MembershipJoin}},
+				})
+			}
+		}
+	}
+	joinRooms()
+	hs.sendText("!su tz Europe/Berlin")
+	hs.sendText("!su notify 9:30")
+	hs.sendText("!su room " + testSendRoom.String())
+	hs.sendText("!su roomlang de")
+	// Settings in rooms with other users are not loaded.
+	hs.SendStateEvent("!group:example.com", types.StateTzSetting, "bob:example.com", types.TzSettingEventContent{TzString: "Asia/Tokyo"})
+
+	// A lost database is rebuilt from state.
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	newStateStore(t, db, hs)
+	joinRooms()
+	// If the state of the config room cannot be loaded, it is loaded again
+	// after the next restart.
+	matrixClient = &unreachableClient{fakeHomeserver: hs, failures: 1}
+	LoadSettings()
+	if _, found := stateStore.UserConfigRooms[testUser]; found {
+		t.Fatal("Expected the settings not to be loaded while the homeserver is unreachable")
+	}
+
+	matrixClient = hs
+	newStateStore(t, db, hs)
+	joinRooms()
+	LoadSettings()
+	if roomID := stateStore.GetConfigRoomId(testUser); roomID != testConfigRoom {
+		t.Errorf("Expected the config room to be loaded from state, got %s", roomID)
+	}
+	if _, found := stateStore.UserConfigRooms["@bob:example.com"]; found {
+		t.Error("Expected the group room not to be a config room")
+	}
+
+	// After a restart, the settings are loaded from the database without
+	// asking the homeserver.
+	newStateStore(t, db, hs)
+	matrixClient = offlineClient{t}
+	stateStore.Client = offlineClient{t}
+	LoadSettings()
+	if roomID := stateStore.GetConfigRoomId(testUser); roomID != testConfigRoom {
+		t.Errorf("Expected the config room to be loaded, got %s", roomID)
+	}
+	if location := stateStore.GetTimezone(testUser); location.String() != "Europe/Berlin" {
+		t.Errorf("Expected the timezone to be loaded, got %s", location)
+	}
+	if minutes, err := stateStore.GetNotify(testUser); err != nil || minutes != 9*60+30 {
+		t.Errorf("Expected the notify time to be loaded, got %d (%v)", minutes, err)
+	}
+	if sendRoomID, err := stateStore.GetSendRoomId(testUser); err != nil || sendRoomID != testSendRoom {
+		t.Errorf("Expected the send room to be loaded, got %s (%v)", sendRoomID, err)
+	}
+	if language := stateStore.GetRoomLanguage(testSendRoom); language != "de" {
+		t.Errorf("Expected the language of the send room to be loaded, got %q", language)
+	}
+
+	// Settings that are not cached are not set, so the defaults are used.
+	stateStore.SetDefaults(store.UserDefaults{Timezone: "Asia/Tokyo", NotifyMinutesAfterMidnight: 8 * 60})
+	if language := stateStore.GetLanguage(testUser); language != "" {
+		t.Errorf("Expected no language, got %q", language)
+	}
+	if roomID := stateStore.GetWeeklyRoomId(testUser); roomID != "" {
+		t.Errorf("Expected no weekly room, got %s", roomID)
+	}
+	if useThreads, _ := stateStore.GetUseThreads(testUser); useThreads {
+		t.Error("Expected threads not to be used")
+	}
+	if location := stateStore.GetTimezone("@bob:example.com"); location.String() != "Asia/Tokyo" {
+		t.Errorf("Expected the default timezone, got %s", location)
+	}
+	if minutes, err := stateStore.GetNotify("@bob:example.com"); err != nil || minutes != 8*60 {
+		t.Errorf("Expected the default notify time, got %d (%v)", minutes, err)
+	}
+	if language := stateStore.GetRoomLanguage("!other:example.com"); language != "" {
+		t.Errorf("Expected no room language, got %q", language)
+	}
+}
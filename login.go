@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix"
+	mid "maunium.net/go/mautrix/id"
+)
+
+// How long to wait for the user to log in using SSO.
+const ssoLoginTimeout = 10 * time.Minute
+
+// Login creates a client for the bot using whichever authentication method is
+// configured. The deviceID is the device ID stored in the crypto store, if
+// any, and is reused so that the E2EE sessions stay stable.
+func Login(deviceID mid.DeviceID, ssoLogin bool) (*mautrix.Client, error) {
+	username := mid.UserID(configuration.Username)
+
+	if configuration.AccessTokenFile != "" {
+		return loginWithAccessToken(deviceID)
+	}
+
+	if ssoLogin {
+		client, err := loginWithSSO(deviceID)
+		if err != nil {
+			return nil, err
+		}
+		if err := stateStore.SaveSession(client.UserID, client.DeviceID, client.AccessToken); err != nil {
+			log.Errorf("Failed to save session: %+v", err)
+		}
+		return client, nil
+	}
+
+	if sessionDeviceID, accessToken := stateStore.LoadSession(username); accessToken != "" {
+		client, err := mautrix.NewClient(configuration.Homeserver, username, accessToken)
+		if err != nil {
+			return nil, err
+		}
+		client.DeviceID = sessionDeviceID
+		_, err = DoRetry("validate stored session", func() (interface{}, error) {
+			return nil, validateSession(client, deviceID)
+		})
+		if err == nil {
+			log.Info("Using stored session")
+			return client, nil
+		} else if !errors.As(err, &permanentError{}) {
+			// Keep the session so that it can be used once the homeserver
+			// is reachable again.
+			return nil, fmt.Errorf("could not validate the stored session: %w", err)
+		}
+		log.Warnf("Stored session is no longer valid: %+v", err)
+		stateStore.DeleteSession(username)
+	}
+
+	if configuration.PasswordFile != "" {
+		return loginWithPassword(deviceID)
+	}
+
+	return nil, errors.New("no valid session found and no PasswordFile or AccessTokenFile configured. Run with -sso-login to log in using SSO")
+}
+
+// Relogin replaces the client's access token after the homeserver invalidated
+// it, keeping the device ID. After a hard logout, the device was deleted, so
+// its keys are uploaded again.
+func Relogin(softLogout bool) error {
+	deviceID := client.DeviceID
+	if appService != nil {
+		client.AccessToken = appService.Registration.AppToken
+		_, err := DoRetry("appservice login", func() (interface{}, error) {
+			return client.Login(&mautrix.ReqLogin{
+				Type: mautrix.AuthTypeAppservice,
+				Identifier: mautrix.UserIdentifier{
+					Type: mautrix.IdentifierTypeUser,
+					User: configuration.Username,
+				},
+				DeviceID:         deviceID,
+				StoreCredentials: true,
+			})
+		})
+		if err != nil {
+			return err
+		}
+	} else if configuration.AccessTokenFile != "" {
+		// The token may have been replaced in the file.
+		accessToken, err := configuration.GetAccessToken()
+		if err != nil {
+			return fmt.Errorf("could not read access token from %s: %w", configuration.AccessTokenFile, err)
+		} else if accessToken == client.AccessToken {
+			return fmt.Errorf("the access token in %s is no longer valid", configuration.AccessTokenFile)
+		}
+		client.AccessToken = accessToken
+		if err := validateSession(client, deviceID); err != nil {
+			return err
+		}
+	} else if configuration.PasswordFile != "" {
+		password, err := configuration.GetPassword()
+		if err != nil {
+			return fmt.Errorf("could not read password from %s: %w", configuration.PasswordFile, err)
+		}
+		client.AccessToken = ""
+		_, err = DoRetry("login", func() (interface{}, error) {
+			return client.Login(&mautrix.ReqLogin{
+				Type: mautrix.AuthTypePassword,
+				Identifier: mautrix.UserIdentifier{
+					Type: mautrix.IdentifierTypeUser,
+					User: configuration.Username,
+				},
+				Password:         password,
+				DeviceID:         deviceID,
+				StoreCredentials: true,
+			})
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		stateStore.DeleteSession(client.UserID)
+		return errors.New("no PasswordFile or AccessTokenFile configured. Restart with -sso-login to log in using SSO")
+	}
+
+	if !softLogout && olmMachine != nil {
+		account, err := olmMachine.CryptoStore.GetAccount()
+		if err != nil {
+			return fmt.Errorf("failed to load the Olm account: %w", err)
+		}
+		log.Info("The device was deleted, uploading its keys again")
+		account.Shared = false
+		if err := olmMachine.ShareKeys(0); err != nil {
+			return fmt.Errorf("failed to upload the device keys: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateSession checks that the access token on the client is valid and
+// belongs to the bot's user and the device in the crypto store. Errors that
+// retrying does not fix, like an unknown token, are permanentErrors.
+func validateSession(client *mautrix.Client, deviceID mid.DeviceID) error {
+	whoami, err := client.Whoami()
+	if err != nil && !isTemporarySendError(err) {
+		return permanentError{err}
+	} else if err != nil {
+		return err
+	}
+	if whoami.UserID.String() != configuration.Username {
+		return permanentError{fmt.Errorf("access token belongs to %s, not %s", whoami.UserID, configuration.Username)}
+	}
+	if whoami.DeviceID != "" {
+		if client.DeviceID != "" && client.DeviceID != whoami.DeviceID {
+			return permanentError{fmt.Errorf("access token belongs to device %s, not %s", whoami.DeviceID, client.DeviceID)}
+		}
+		client.DeviceID = whoami.DeviceID
+	}
+	if client.DeviceID == "" {
+		return permanentError{errors.New("could not determine the device ID of the access token. Set DeviceID in the config")}
+	}
+	if deviceID != "" && client.DeviceID != deviceID {
+		return permanentError{fmt.Errorf("access token belongs to device %s, but the crypto store is for device %s", client.DeviceID, deviceID)}
+	}
+	return nil
+}
+
+func loginWithAccessToken(deviceID mid.DeviceID) (*mautrix.Client, error) {
+	accessToken, err := configuration.GetAccessToken()
+	if err != nil {
+		return nil, fmt.Errorf("could not read access token from %s: %w", configuration.AccessTokenFile, err)
+	}
+	client, err := mautrix.NewClient(configuration.Homeserver, mid.UserID(configuration.Username), accessToken)
+	if err != nil {
+		return nil, err
+	}
+	client.DeviceID = mid.DeviceID(configuration.DeviceID)
+
+	_, err = DoRetry("validate access token", func() (interface{}, error) {
+		return nil, validateSession(client, deviceID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func loginWithPassword(deviceID mid.DeviceID) (*mautrix.Client, error) {
+	password, err := configuration.GetPassword()
+	if err != nil {
+		return nil, fmt.Errorf("could not read password from %s: %w", configuration.PasswordFile, err)
+	}
+	client, err := mautrix.NewClient(configuration.Homeserver, "", "")
+	if err != nil {
+		return nil, err
+	}
+	_, err = DoRetry("login", func() (interface{}, error) {
+		return client.Login(&mautrix.ReqLogin{
+			Type: mautrix.AuthTypePassword,
+			Identifier: mautrix.UserIdentifier{
+				Type: mautrix.IdentifierTypeUser,
+				User: configuration.Username,
+			},
+			Password:                 password,
+			InitialDeviceDisplayName: "standupbot",
+			DeviceID:                 deviceID,
+			StoreCredentials:         true,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// loginWithSSO prints the SSO URL for the homeserver, waits for the login token
+// to be sent to the callback listener, and then logs in using the token.
+func loginWithSSO(deviceID mid.DeviceID) (*mautrix.Client, error) {
+	client, err := mautrix.NewClient(configuration.Homeserver, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	flows, err := client.GetLoginFlows()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get login flows: %w", err)
+	}
+	if flows.FirstFlowOfType(mautrix.AuthTypeSSO) == nil || flows.FirstFlowOfType(mautrix.AuthTypeToken) == nil {
+		return nil, errors.New("the homeserver does not support SSO login")
+	}
+
+	listenAddress := configuration.SSOListenAddress
+	if listenAddress == "" {
+		listenAddress = "localhost:29334"
+	}
+	listener, err := net.Listen("tcp", listenAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for the SSO callback on %s: %w", listenAddress, err)
+	}
+	defer listener.Close()
+
+	loginTokens := make(chan string, 1)
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			loginToken := r.URL.Query().Get("loginToken")
+			if loginToken == "" {
+				http.Error(w, "No login token in the request.", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintln(w, "Login token received. You can close this window.")
+			select {
+			case loginTokens <- loginToken:
+			default:
+			}
+		}),
+	}
+	go server.Serve(listener)
+	defer func() {
+		// Let the browser get the response before stopping.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	ssoURL := client.BuildURLWithQuery(mautrix.URLPath{"login", "sso", "redirect"}, map[string]string{
+		"redirectUrl": fmt.Sprintf("http://%s/", listener.Addr()),
+	})
+	log.Infof("Open %s in a browser to log in as %s within %s", ssoURL, configuration.Username, ssoLoginTimeout)
+	var loginToken string
+	select {
+	case loginToken = <-loginTokens:
+	case <-time.After(ssoLoginTimeout):
+		return nil, fmt.Errorf("did not receive a login token within %s", ssoLoginTimeout)
+	case <-shutdownCtx.Done():
+		return nil, errors.New("the bot is shutting down")
+	}
+
+	_, err = client.Login(&mautrix.ReqLogin{
+		Type:                     mautrix.AuthTypeToken,
+		Token:                    loginToken,
+		InitialDeviceDisplayName: "standupbot",
+		DeviceID:                 deviceID,
+		StoreCredentials:         true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if client.UserID.String() != configuration.Username {
+		return nil, fmt.Errorf("logged in as %s, not %s", client.UserID, configuration.Username)
+	}
+	return client, nil
+}
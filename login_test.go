@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"maunium.net/go/mautrix"
+)
+
+func TestLoginWithRevokedAccessToken(t *testing.T) {
+	setupTest(t, tuesday)
+	var requests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"errcode": "M_UNKNOWN_TOKEN", "error": "Unknown token"}`))
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("revoked"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	configuration.Homeserver = server.URL
+	configuration.AccessTokenFile = tokenFile
+	configuration.DeviceID = "DEVICE"
+
+	// A revoked token is not retried.
+	if _, err := loginWithAccessToken("DEVICE"); !errors.Is(err, mautrix.MUnknownToken) {
+		t.Errorf("Expected M_UNKNOWN_TOKEN, got %v", err)
+	}
+	if requests := atomic.LoadInt64(&requests); requests != 1 {
+		t.Errorf("Expected one whoami request, got %d", requests)
+	}
+}
+
+func TestLoginWithStoredSession(t *testing.T) {
+	setupTest(t, tuesday)
+	var requests int64
+	status := http.StatusBadGateway
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first request fails as if the homeserver was down.
+		if atomic.AddInt64(&requests, 1) == 1 {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"errcode": "M_UNKNOWN", "error": "Bad gateway"}`))
+			return
+		}
+		w.Write([]byte(`{"user_id": "` + testBotUser.String() + `", "device_id": "DEVICE"}`))
+	}))
+	defer server.Close()
+	configuration.Homeserver = server.URL
+	if err := stateStore.SaveSession(testBotUser, "DEVICE", "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Temporary errors are retried and keep the session.
+	client, err := Login("DEVICE", false)
+	if err != nil {
+		t.Fatalf("Expected the stored session to be used, got %v", err)
+	}
+	if client.AccessToken != "token" || atomic.LoadInt64(&requests) != 2 {
+		t.Errorf("Expected the stored session to be validated again, got %s after %d requests", client.AccessToken, requests)
+	}
+
+	// An invalid token removes the session.
+	atomic.StoreInt64(&requests, 0)
+	status = http.StatusUnauthorized
+	if _, err := Login("DEVICE", false); err == nil {
+		t.Error("Expected an error without a valid session")
+	}
+	if _, accessToken := stateStore.LoadSession(testBotUser); accessToken != "" {
+		t.Error("Expected the invalid session to be removed")
+	}
+}
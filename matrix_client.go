@@ -0,0 +1,28 @@
+package main
+
+import (
+	"maunium.net/go/mautrix"
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+)
+
+// MatrixClient is the part of the Matrix client API that the command and
+// standup post handlers use. It is implemented by *mautrix.Client, and by a
+// fake homeserver in the tests.
+type MatrixClient interface {
+	SendMessageEvent(roomID mid.RoomID, eventType mevent.Type, contentJSON interface{}, extra ...mautrix.ReqSendEvent) (*mautrix.RespSendEvent, error)
+	SendStateEvent(roomID mid.RoomID, eventType mevent.Type, stateKey string, contentJSON interface{}) (*mautrix.RespSendEvent, error)
+	StateEvent(roomID mid.RoomID, eventType mevent.Type, stateKey string, outContent interface{}) error
+	RedactEvent(roomID mid.RoomID, eventID mid.EventID, extra ...mautrix.ReqRedact) (*mautrix.RespSendEvent, error)
+	SendReaction(roomID mid.RoomID, eventID mid.EventID, reaction string) (*mautrix.RespSendEvent, error)
+	JoinRoom(roomIDorAlias, serverName string, content interface{}) (*mautrix.RespJoinRoom, error)
+	LeaveRoom(roomID mid.RoomID, optionalReq ...*mautrix.ReqLeave) (*mautrix.RespLeaveRoom, error)
+	MarkRead(roomID mid.RoomID, eventID mid.EventID) error
+	Members(roomID mid.RoomID, req ...mautrix.ReqMembers) (*mautrix.RespMembers, error)
+	JoinedMembers(roomID mid.RoomID) (*mautrix.RespJoinedMembers, error)
+	State(roomID mid.RoomID) (mautrix.RoomStateMap, error)
+	JoinedRooms() (*mautrix.RespJoinedRooms, error)
+	ResolveAlias(alias mid.RoomAlias) (*mautrix.RespAliasResolve, error)
+}
+
+var _ MatrixClient = (*mautrix.Client)(nil)
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+
+	"github.com/beeper/standupbot/types"
+)
+
+// MigrateState copies the settings from the state events in the given
+// namespace to the state events in the configured namespace in every joined
+// room. Settings that are already set in the configured namespace are not
+// overwritten. If dryRun is true, the settings are only logged.
+func MigrateState(fromNamespace string, dryRun bool) error {
+	fromNamespace = strings.TrimSuffix(fromNamespace, ".")
+	toNamespace := configuration.GetStateEventNamespace()
+	if fromNamespace == toNamespace {
+		return fmt.Errorf("the settings are already stored in %s", toNamespace)
+	}
+	log.Infof("Copying the settings from %s to %s", fromNamespace, toNamespace)
+
+	joinedRooms, err := matrixClient.JoinedRooms()
+	if err != nil {
+		return err
+	}
+
+	names := append(append([]string{}, types.RoomStateEventNames...), types.UserStateEventNames...)
+	copied, failed := 0, 0
+	for _, roomID := range joinedRooms.JoinedRooms {
+		state, err := matrixClient.State(roomID)
+		if err != nil {
+			log.Errorf("Failed to get the state of %s: %+v", roomID, err)
+			failed++
+			continue
+		}
+
+		for _, name := range names {
+			from := types.StateEventType(fromNamespace, name)
+			to := types.StateEventType(toNamespace, name)
+			existing := stateEvents(state, to)
+			for stateKey, event := range stateEvents(state, from) {
+				if _, found := existing[stateKey]; found {
+					log.Infof("Not copying %s/%s in %s because %s is already set", from.Type, stateKey, roomID, to.Type)
+					continue
+				}
+				if err := copyStateEvent(roomID, from, to, stateKey, event.Content.VeryRaw, dryRun); err != nil {
+					log.Errorf("Failed to copy %s/%s in %s: %+v", from.Type, stateKey, roomID, err)
+					failed++
+				} else {
+					copied++
+				}
+			}
+		}
+	}
+
+	if dryRun {
+		log.Infof("Would copy %d state events", copied)
+	} else {
+		log.Infof("Copied %d state events", copied)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to copy %d state events or the state of their rooms", failed)
+	}
+	return nil
+}
+
+// copyStateEvent copies the content of a state event to a state event of
+// another type.
+func copyStateEvent(roomID mid.RoomID, from, to mevent.Type, stateKey string, content json.RawMessage, dryRun bool) error {
+	if dryRun {
+		log.Infof("Would copy %s/%s in %s to %s: %s", from.Type, stateKey, roomID, to.Type, content)
+		return nil
+	}
+	if _, err := matrixClient.SendStateEvent(roomID, to, stateKey, content); err != nil {
+		return err
+	}
+	log.Infof("Copied %s/%s in %s to %s", from.Type, stateKey, roomID, to.Type)
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	mevent "maunium.net/go/mautrix/event"
+
+	"github.com/beeper/standupbot/types"
+)
+
+func TestMigrateState(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	stateKey := testUser.String()
+	stateStore.SetMembership(&mevent.Event{
+		RoomID:   testConfigRoom,
+		Type:     mevent.StateMember,
+		StateKey: &stateKey,
+		Content:  mevent.Content{Parsed: &mevent.MemberEventContent{Membership: mevent.MembershipJoin}},
+	})
+	hs.sendText("!su tz Europe/Berlin")
+	hs.sendText("!su room " + testSendRoom.String())
+	hs.sendText("!su roomlang de")
+
+	configuration.StateEventNamespace = "com.example.standup"
+	types.SetNamespace(configuration.GetStateEventNamespace())
+	t.Cleanup(func() { types.SetNamespace(types.DefaultNamespace) })
+
+	if err := MigrateState(types.DefaultNamespace, true); err != nil {
+		t.Fatal(err)
+	}
+	var tz types.TzSettingEventContent
+	if err := hs.StateEvent(testConfigRoom, types.StateTzSetting, "alice:example.com", &tz); err == nil {
+		t.Errorf("Expected a dry run not to copy the timezone")
+	}
+
+	if err := MigrateState(types.DefaultNamespace, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := hs.StateEvent(testConfigRoom, types.StateTzSetting, "alice:example.com", &tz); err != nil || tz.TzString != "Europe/Berlin" {
+		t.Errorf("Expected the timezone to be copied, got %+v (%v)", tz, err)
+	}
+	var sendRoom types.SendRoomEventContent
+	if err := hs.StateEvent(testConfigRoom, types.StateSendRoom, "alice:example.com", &sendRoom); err != nil || sendRoom.SendRoomID != testSendRoom {
+		t.Errorf("Expected the send room to be copied, got %+v (%v)", sendRoom, err)
+	}
+	var language types.LanguageEventContent
+	if err := hs.StateEvent(testSendRoom, types.StateRoomLanguage, "", &language); err != nil || language.Language != "de" {
+		t.Errorf("Expected the room language to be copied, got %+v (%v)", language, err)
+	}
+
+	// Settings in rooms whose state cannot be loaded are not skipped silently.
+	matrixClient = &unreachableClient{fakeHomeserver: hs, failures: 1}
+	if err := MigrateState("com.example.old", false); err == nil {
+		t.Error("Expected an error if the state of a room could not be loaded")
+	}
+
+	if err := MigrateState("com.example.standup.", false); err == nil {
+		t.Errorf("Expected migrating to the same namespace to fail")
+	}
+}
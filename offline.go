@@ -0,0 +1,251 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/crypto/olm"
+	mid "maunium.net/go/mautrix/id"
+
+	"github.com/beeper/standupbot/store"
+)
+
+// The tables of the crypto store that the bot needs.
+var cryptoTables = []string{
+	"crypto_account",
+	"crypto_olm_session",
+	"crypto_megolm_inbound_session",
+	"crypto_megolm_outbound_session",
+	"crypto_device",
+	"crypto_tracked_user",
+	"crypto_message_index",
+}
+
+// missingTables returns the tables in names that do not exist in the database.
+func missingTables(db *sql.DB, dialect store.Dialect, names []string) ([]string, error) {
+	var missing []string
+	for _, name := range names {
+		exists, err := dialect.TableExists(db, name)
+		if err != nil {
+			return nil, err
+		} else if !exists {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
+func sortedFlowUsers(flows map[mid.UserID]*StandupFlow) []mid.UserID {
+	userIDs := make([]mid.UserID, 0, len(flows))
+	for userID := range flows {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Slice(userIDs, func(i, j int) bool { return userIDs[i] < userIDs[j] })
+	return userIDs
+}
+
+// RunDoctor checks the configuration, the database and the saved flows, and
+// writes a report to out. It returns an error if there are any problems.
+func RunDoctor(out io.Writer, db *sql.DB, dialect store.Dialect, flowsPath string) error {
+	problems := 0
+	report := func(ok bool, format string, args ...interface{}) {
+		status := "OK"
+		if !ok {
+			status = "PROBLEM"
+			problems++
+		}
+		fmt.Fprintf(out, "%-8s%s\n", status, fmt.Sprintf(format, args...))
+	}
+
+	if configProblems := configuration.Validate(); len(configProblems) > 0 {
+		for _, problem := range configProblems {
+			report(false, "config: %v", problem)
+		}
+	} else {
+		report(true, "config: %s is valid", configPath)
+	}
+
+	if version, err := store.NewStateStore(db, dialect).SchemaVersion(); err != nil {
+		report(false, "database: %v", err)
+	} else if version > store.LatestSchemaVersion {
+		report(false, "database: schema version %d is newer than the latest version %d that this version of standupbot supports", version, store.LatestSchemaVersion)
+	} else if version < store.LatestSchemaVersion {
+		report(true, "database: schema version %d. It is upgraded to version %d when the bot starts", version, store.LatestSchemaVersion)
+	} else {
+		report(true, "database: schema version %d is up to date", version)
+	}
+
+	checkCryptoStore(db, dialect, report)
+
+	flows, err := LoadFlows(flowsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		report(true, "flows: %s does not exist", flowsPath)
+	} else if err != nil {
+		report(false, "flows: could not load %s: %v", flowsPath, err)
+	} else {
+		report(true, "flows: %d flows in %s", len(flows), flowsPath)
+		for _, userID := range sortedFlowUsers(flows) {
+			fmt.Fprintf(out, "        %s: %s, %d items\n", userID, flows[userID].State, flows[userID].itemCount())
+		}
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("found %d problems", problems)
+	}
+	return nil
+}
+
+func checkCryptoStore(db *sql.DB, dialect store.Dialect, report func(ok bool, format string, args ...interface{})) {
+	if missing, err := missingTables(db, dialect, cryptoTables); err != nil {
+		report(false, "crypto store: %v", err)
+		return
+	} else if len(missing) > 0 {
+		report(false, "crypto store: missing tables %s. They are created when the bot starts", strings.Join(missing, ", "))
+		return
+	}
+
+	var pickled []byte
+	err := db.QueryRow("SELECT account FROM crypto_account WHERE account_id = $1", configuration.Username).Scan(&pickled)
+	if err == sql.ErrNoRows {
+		report(true, "crypto store: there is no Olm account yet. It is created when the bot starts")
+		return
+	} else if err != nil {
+		report(false, "crypto store: %v", err)
+		return
+	}
+	pickleKey, err := configuration.GetPickleKey()
+	if err != nil {
+		report(false, "crypto store: could not read the pickle key: %v", err)
+		return
+	}
+	if _, err := olm.AccountFromPickled(pickled, pickleKey); err != nil {
+		report(false, "crypto store: the Olm account cannot be decrypted using the pickle key: %v", err)
+		return
+	}
+
+	var sessions, groupSessions int
+	if err := db.QueryRow("SELECT COUNT(*) FROM crypto_olm_session").Scan(&sessions); err != nil {
+		report(false, "crypto store: could not count the Olm sessions: %v", err)
+		return
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM crypto_megolm_inbound_session").Scan(&groupSessions); err != nil {
+		report(false, "crypto store: could not count the Megolm sessions: %v", err)
+		return
+	}
+	report(true, "crypto store: the Olm account can be decrypted. %d Olm sessions and %d Megolm sessions", sessions, groupSessions)
+}
+
+// ExportData writes the saved flows, the post history, the queued
+// undecryptable events and the unsent messages to out as JSON. Only the posts
+// that are kept for the weekly summaries are in the post history.
+func ExportData(out io.Writer, db *sql.DB, dialect store.Dialect, flowsPath string) error {
+	flows, err := LoadFlows(flowsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		flows = map[mid.UserID]*StandupFlow{}
+	} else if err != nil {
+		return err
+	}
+
+	var undecryptableEvents []store.UndecryptableEvent
+	if missing, err := missingTables(db, dialect, []string{"undecryptable_events"}); err != nil {
+		return err
+	} else if len(missing) == 0 {
+		undecryptableEvents = store.NewStateStore(db, dialect).GetUndecryptableEvents()
+	}
+
+	var outgoingMessages []store.OutgoingMessage
+	if missing, err := missingTables(db, dialect, []string{"outbox"}); err != nil {
+		return err
+	} else if len(missing) == 0 {
+		outgoingMessages = store.NewStateStore(db, dialect).GetOutgoingMessages()
+	}
+
+	var posts []store.Post
+	if missing, err := missingTables(db, dialect, []string{"posts"}); err != nil {
+		return err
+	} else if len(missing) == 0 {
+		if posts, err = store.NewStateStore(db, dialect).GetAllPosts(); err != nil {
+			return err
+		}
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		ExportedAt          time.Time
+		Flows               map[mid.UserID]*StandupFlow
+		Posts               []store.Post
+		UndecryptableEvents []store.UndecryptableEvent
+		OutgoingMessages    []store.OutgoingMessage
+	}{time.Now().UTC(), flows, posts, undecryptableEvents, outgoingMessages})
+}
+
+// RunFlowsCommand lists the saved flows, or clears the saved flow of a user.
+// The bot saves its flows when it stops, so it must not be running.
+func RunFlowsCommand(out io.Writer, flowsPath string, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: standupbot flows list|clear <user ID>")
+	}
+	flows, err := LoadFlows(flowsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		flows = map[mid.UserID]*StandupFlow{}
+	} else if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		if len(flows) == 0 {
+			fmt.Fprintln(out, "There are no saved flows.")
+		}
+		for _, userID := range sortedFlowUsers(flows) {
+			flow := flows[userID]
+			fmt.Fprintf(out, "%s: %s, %d items, flow %s\n", userID, flow.State, flow.itemCount(), flow.FlowID)
+		}
+		return nil
+	case "clear":
+		if len(args) != 2 {
+			return errors.New("usage: standupbot flows clear <user ID>")
+		}
+		userID := mid.UserID(args[1])
+		if _, found := flows[userID]; !found {
+			return fmt.Errorf("there is no saved flow for %s", userID)
+		}
+		delete(flows, userID)
+		if err := SaveFlows(flowsPath, flows); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Cleared the flow of %s.\n", userID)
+		return nil
+	default:
+		return fmt.Errorf("unknown flows subcommand %s. Use list or clear", args[0])
+	}
+}
+
+// RunDBCommand runs maintenance tasks on the database.
+func RunDBCommand(out io.Writer, db *sql.DB, dialect store.Dialect, args []string) error {
+	if len(args) != 1 || args[0] != "vacuum" {
+		return errors.New("usage: standupbot db vacuum")
+	}
+
+	before, err := dialect.DatabaseSize(db)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec("VACUUM"); err != nil {
+		return err
+	}
+	after, err := dialect.DatabaseSize(db)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Vacuumed the database. Its size went from %d to %d bytes.\n", before, after)
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	mid "maunium.net/go/mautrix/id"
+
+	"github.com/beeper/standupbot/store"
+)
+
+func TestConfigurationValidate(t *testing.T) {
+	config := Configuration{
+		Username:        "standupbot",
+		AccessTokenFile: "/nonexistent",
+		Admins:          []mid.UserID{"@admin:example.com", "admin"},
+	}
+	var problems []string
+	for _, problem := range config.Validate() {
+		problems = append(problems, problem.Error())
+	}
+	if len(problems) != 5 {
+		t.Fatalf("Expected 5 problems, got %q", problems)
+	}
+	assertContains(t, problems[0], "Homeserver is not set")
+	assertContains(t, problems[1], `Username "standupbot" is not a valid user ID`)
+	assertContains(t, problems[2], "AccessTokenFile cannot be read")
+	assertContains(t, problems[3], "DeviceID must be set")
+	assertContains(t, problems[4], `"admin" is not a valid user ID`)
+}
+
+func TestFlowsCommand(t *testing.T) {
+	flowsPath := filepath.Join(t.TempDir(), "current-flows.json")
+	flow := BlankStandupFlow()
+	flow.State = Today
+	flow.Yesterday = []StandupItem{{Body: "Wrote tests"}}
+	if err := SaveFlows(flowsPath, map[mid.UserID]*StandupFlow{testUser: flow, "@bob:example.com": BlankStandupFlow()}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := RunFlowsCommand(&out, flowsPath, []string{"list"}); err != nil {
+		t.Fatal(err)
+	}
+	assertContains(t, out.String(), "@alice:example.com: today, 1 items", "@bob:example.com: not started, 0 items")
+
+	out.Reset()
+	if err := RunFlowsCommand(&out, flowsPath, []string{"clear", "@alice:example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	flows, err := LoadFlows(flowsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := flows[testUser]; found || len(flows) != 1 {
+		t.Errorf("Expected only the flow of alice to be cleared, got %v", flows)
+	}
+
+	if err := RunFlowsCommand(&out, flowsPath, []string{"clear", "@alice:example.com"}); err == nil {
+		t.Errorf("Expected clearing a missing flow to fail")
+	}
+}
+
+func TestDoctorAndVacuum(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "standupbot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := store.NewStateStore(db, store.SQLite).Upgrade(); err != nil {
+		t.Fatal(err)
+	}
+	configuration = Configuration{Homeserver: "https://example.com", Username: testBotUser.String()}
+
+	var out bytes.Buffer
+	if err := RunDoctor(&out, db, store.SQLite, filepath.Join(t.TempDir(), "current-flows.json")); err == nil {
+		t.Errorf("Expected the doctor to report the missing crypto tables")
+	}
+	assertContains(t, out.String(), fmt.Sprintf("OK      database: schema version %d is up to date", store.LatestSchemaVersion), "PROBLEM crypto store: missing tables crypto_account", "current-flows.json does not exist")
+
+	out.Reset()
+	if err := RunDBCommand(&out, db, store.SQLite, []string{"vacuum"}); err != nil {
+		t.Fatal(err)
+	}
+	assertContains(t, out.String(), "Vacuumed the database.")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix/crypto/olm"
+)
+
+type pickler interface {
+	Pickle(key []byte) []byte
+}
+
+// A table in the crypto store that contains pickled Olm or Megolm data.
+type pickledTable struct {
+	name       string
+	keyColumns []string
+	column     string
+	unpickle   func(pickled, key []byte) (pickler, error)
+}
+
+var pickledTables = []pickledTable{
+	{"crypto_account", []string{"account_id"}, "account", func(pickled, key []byte) (pickler, error) {
+		return olm.AccountFromPickled(pickled, key)
+	}},
+	{"crypto_olm_session", []string{"account_id", "session_id"}, "session", func(pickled, key []byte) (pickler, error) {
+		return olm.SessionFromPickled(pickled, key)
+	}},
+	{"crypto_megolm_inbound_session", []string{"account_id", "session_id"}, "session", func(pickled, key []byte) (pickler, error) {
+		return olm.InboundGroupSessionFromPickled(pickled, key)
+	}},
+	{"crypto_megolm_outbound_session", []string{"account_id", "room_id"}, "session", func(pickled, key []byte) (pickler, error) {
+		return olm.OutboundGroupSessionFromPickled(pickled, key)
+	}},
+}
+
+type pickledRow struct {
+	keys    []interface{}
+	pickled []byte
+}
+
+// RekeyCryptoStore re-encrypts all of the pickled data in the crypto store
+// using the pickle key in newPickleKeyFile. After it succeeds, PickleKeyFile
+// must be changed to point at the new key file.
+func RekeyCryptoStore(db *sql.DB, newPickleKeyFile string) error {
+	if newPickleKeyFile == "" {
+		return errors.New("usage: standupbot rekey <new pickle key file>")
+	}
+	oldPickleKey, err := configuration.GetPickleKey()
+	if err != nil {
+		return fmt.Errorf("could not read the current pickle key: %w", err)
+	}
+	newPickleKeyStr, err := readSecretFile(newPickleKeyFile)
+	if err != nil {
+		return fmt.Errorf("could not read the new pickle key: %w", err)
+	} else if newPickleKeyStr == "" {
+		return fmt.Errorf("new pickle key file %s is empty", newPickleKeyFile)
+	}
+	newPickleKey := []byte(newPickleKeyStr)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, table := range pickledTables {
+		count, err := rekeyTable(tx, table, oldPickleKey, newPickleKey)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to rekey %s: %w", table.name, err)
+		}
+		log.Infof("Re-encrypted %d rows in %s", count, table.name)
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	log.Infof("Successfully re-encrypted the crypto store. Set PickleKeyFile to %s in the config.", newPickleKeyFile)
+	return nil
+}
+
+func rekeyTable(tx *sql.Tx, table pickledTable, oldPickleKey, newPickleKey []byte) (int, error) {
+	query := fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s IS NOT NULL",
+		strings.Join(table.keyColumns, ", "), table.column, table.name, table.column)
+	rows, err := tx.Query(query)
+	if err != nil {
+		return 0, err
+	}
+
+	// Read all of the rows before updating any of them.
+	pickledRows := make([]pickledRow, 0)
+	for rows.Next() {
+		keys := make([]interface{}, len(table.keyColumns))
+		keyStrs := make([]string, len(table.keyColumns))
+		dest := make([]interface{}, 0, len(keys)+1)
+		for i := range keyStrs {
+			dest = append(dest, &keyStrs[i])
+		}
+		var pickled []byte
+		dest = append(dest, &pickled)
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		for i, key := range keyStrs {
+			keys[i] = key
+		}
+		pickledRows = append(pickledRows, pickledRow{keys, pickled})
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, err
+	}
+	rows.Close()
+
+	conditions := make([]string, len(table.keyColumns))
+	for i, column := range table.keyColumns {
+		conditions[i] = fmt.Sprintf("%s = $%d", column, i+2)
+	}
+	update := fmt.Sprintf("UPDATE %s SET %s = $1 WHERE %s", table.name, table.column, strings.Join(conditions, " AND "))
+	for _, row := range pickledRows {
+		unpickled, err := table.unpickle(row.pickled, oldPickleKey)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt row %v (is the current pickle key correct?): %w", row.keys, err)
+		}
+		args := append([]interface{}{unpickled.Pickle(newPickleKey)}, row.keys...)
+		if _, err := tx.Exec(update, args...); err != nil {
+			return 0, err
+		}
+	}
+	return len(pickledRows), nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix"
+	mid "maunium.net/go/mautrix/id"
+)
+
+// scheduledReminder is the next reminder of a user.
+type scheduledReminder struct {
+	userID mid.UserID
+	at     time.Time
+	// Whether calculating the user's next reminder failed, so that it is
+	// calculated again at this time instead of reminding the user.
+	retry bool
+	index int
+}
+
+// reminderQueue is a heap of reminders ordered by their time.
+type reminderQueue []*scheduledReminder
+
+func (q reminderQueue) Len() int           { return len(q) }
+func (q reminderQueue) Less(i, j int) bool { return q[i].at.Before(q[j].at) }
+func (q reminderQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *reminderQueue) Push(x interface{}) {
+	reminder := x.(*scheduledReminder)
+	reminder.index = len(*q)
+	*q = append(*q, reminder)
+}
+
+func (q *reminderQueue) Pop() interface{} {
+	old := *q
+	reminder := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return reminder
+}
+
+// ReminderScheduler keeps the time of the next reminder of every user in a
+// priority queue, so that finding the due reminders only looks at the users
+// that are due. A user's next reminder is recalculated when their settings
+// change and after they are reminded. If it cannot be calculated, for example
+// because the homeserver is unreachable, it is calculated again in the next
+// minute. The weekly summaries are scheduled the same way.
+type ReminderScheduler struct {
+	// Returns the first time at or after from that the user is due.
+	next      func(userID mid.UserID, from time.Time) (time.Time, bool, error)
+	lock      sync.Mutex
+	queue     reminderQueue
+	scheduled map[mid.UserID]*scheduledReminder
+	// The first minute that has not been processed yet. Reminders are only
+	// scheduled at or after this time. It is zero until the scheduler is
+	// started.
+	from time.Time
+}
+
+func NewReminderScheduler() *ReminderScheduler {
+	return &ReminderScheduler{next: nextReminderTime, scheduled: map[mid.UserID]*scheduledReminder{}}
+}
+
+func NewWeeklySummaryScheduler() *ReminderScheduler {
+	return &ReminderScheduler{next: nextWeeklySummaryTime, scheduled: map[mid.UserID]*scheduledReminder{}}
+}
+
+// start schedules the reminders of all users from the given minute on, unless
+// the scheduler was already started.
+func (s *ReminderScheduler) start(from time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.from.IsZero() {
+		return
+	}
+	s.from = from
+	for userID := range stateStore.UserConfigRooms {
+		s.reschedule(userID)
+	}
+}
+
+// Reschedule recalculates the next reminder of the user.
+func (s *ReminderScheduler) Reschedule(userID mid.UserID) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.from.IsZero() {
+		s.reschedule(userID)
+	}
+}
+
+// RescheduleAll recalculates the next reminder of every user, for example
+// after the default settings changed.
+func (s *ReminderScheduler) RescheduleAll() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.from.IsZero() {
+		return
+	}
+	for userID := range stateStore.UserConfigRooms {
+		s.reschedule(userID)
+	}
+}
+
+func (s *ReminderScheduler) reschedule(userID mid.UserID) {
+	at, ok, err := s.next(userID, s.from)
+	retry := err != nil
+	if retry {
+		log.Warnf("Could not schedule %s, trying again in the next minute: %v", userID, err)
+		at, ok = s.from, true
+	}
+	reminder, scheduled := s.scheduled[userID]
+	switch {
+	case !ok && scheduled:
+		heap.Remove(&s.queue, reminder.index)
+		delete(s.scheduled, userID)
+	case ok && scheduled:
+		reminder.at = at
+		reminder.retry = retry
+		heap.Fix(&s.queue, reminder.index)
+	case ok:
+		reminder = &scheduledReminder{userID: userID, at: at, retry: retry}
+		heap.Push(&s.queue, reminder)
+		s.scheduled[userID] = reminder
+	}
+}
+
+// popDue removes the reminders that are due at or before the given minute, and
+// schedules the next reminders of those users after it. The users whose
+// reminders could not be scheduled before are scheduled again.
+func (s *ReminderScheduler) popDue(until time.Time) []scheduledReminder {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var due []scheduledReminder
+	var popped []mid.UserID
+	for len(s.queue) > 0 && !s.queue[0].at.After(until) {
+		reminder := heap.Pop(&s.queue).(*scheduledReminder)
+		delete(s.scheduled, reminder.userID)
+		popped = append(popped, reminder.userID)
+		if !reminder.retry {
+			due = append(due, *reminder)
+		}
+	}
+	s.from = until.Add(time.Minute)
+	for _, userID := range popped {
+		s.reschedule(userID)
+	}
+	return due
+}
+
+// nextReminderTime returns the first time at or after from that the user
+// should be reminded at, if they have a notification time. The time is
+// calculated in the user's timezone, so it moves with daylight saving time,
+// and users are not reminded on the weekend in their timezone. It returns an
+// error if the notification time could not be loaded.
+func nextReminderTime(userID mid.UserID, from time.Time) (time.Time, bool, error) {
+	if _, found := stateStore.UserConfigRooms[userID]; !found {
+		return time.Time{}, false, nil
+	}
+	minutesAfterMidnight, err := stateStore.GetNotify(userID)
+	if errors.Is(err, mautrix.MNotFound) {
+		return time.Time{}, false, nil
+	} else if err != nil {
+		return time.Time{}, false, err
+	} else if minutesAfterMidnight == 0 {
+		return time.Time{}, false, nil
+	}
+
+	location := stateStore.GetTimezone(userID)
+	local := from.In(location)
+	for days := 0; days <= 7; days++ {
+		// If the time does not exist on that day because of daylight saving
+		// time, time.Date moves it forward.
+		at := time.Date(local.Year(), local.Month(), local.Day()+days, 0, minutesAfterMidnight, 0, 0, location)
+		if at.Before(from) || at.Weekday() == time.Saturday || at.Weekday() == time.Sunday {
+			continue
+		}
+		return at.UTC(), true, nil
+	}
+	return time.Time{}, false, nil
+}
+
+// nextWeeklySummaryTime returns the first time at or after from that the
+// user's weekly summary should be compiled at, if the weekly summaries are
+// enabled. Like the reminders, it is calculated in the user's timezone.
+func nextWeeklySummaryTime(userID mid.UserID, from time.Time) (time.Time, bool, error) {
+	weeklySummary := currentConfiguration().WeeklySummary
+	if _, found := stateStore.UserConfigRooms[userID]; !found || !weeklySummary.Enabled {
+		return time.Time{}, false, nil
+	}
+	day, minutesAfterMidnight := weeklySummary.Schedule()
+
+	location := stateStore.GetTimezone(userID)
+	local := from.In(location)
+	for days := 0; days <= 7; days++ {
+		at := time.Date(local.Year(), local.Month(), local.Day()+days, 0, minutesAfterMidnight, 0, 0, location)
+		if at.Before(from) || at.Weekday() != day {
+			continue
+		}
+		return at.UTC(), true, nil
+	}
+	return time.Time{}, false, nil
+}
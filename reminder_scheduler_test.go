@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix"
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+
+	"github.com/beeper/standupbot/types"
+)
+
+func TestNextReminderTime(t *testing.T) {
+	setupTest(t, tuesday)
+	stateStore.SetConfigRoom(testUser, testConfigRoom)
+	stateStore.SetNotify(testUser, 8*60)
+
+	for _, test := range []struct {
+		name     string
+		timezone string
+		from     time.Time
+		expected time.Time
+	}{
+		{
+			// Daylight saving time started on Sunday, March 13 2022 in
+			// New York, so 08:00 moves from 13:00 to 12:00 UTC.
+			name:     "skips the weekend and follows daylight saving time",
+			timezone: "America/New_York",
+			from:     time.Date(2022, time.March, 11, 14, 0, 0, 0, time.UTC),
+			expected: time.Date(2022, time.March, 14, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			// It is already 09:00 on Monday in Auckland, so the next
+			// reminder is on Tuesday, which is still Monday in UTC.
+			name:     "uses the local date",
+			timezone: "Pacific/Auckland",
+			from:     time.Date(2022, time.March, 13, 20, 0, 0, 0, time.UTC),
+			expected: time.Date(2022, time.March, 14, 19, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "includes the starting minute",
+			timezone: "UTC",
+			from:     time.Date(2022, time.March, 15, 8, 0, 0, 0, time.UTC),
+			expected: time.Date(2022, time.March, 15, 8, 0, 0, 0, time.UTC),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			stateStore.SetTimezone(testUser, test.timezone)
+			if at, ok, err := nextReminderTime(testUser, test.from); err != nil || !ok || !at.Equal(test.expected) {
+				t.Errorf("Expected %s, got %s (%t, %v)", test.expected, at, ok, err)
+			}
+		})
+	}
+
+	stateStore.SetNotify(testUser, 0)
+	if at, ok, _ := nextReminderTime(testUser, tuesday); ok {
+		t.Errorf("Expected no reminder without a notification time, got %s", at)
+	}
+}
+
+func TestReminderSchedulerReschedules(t *testing.T) {
+	setupTest(t, tuesday)
+	bob := mid.UserID("@bob:example.com")
+	for _, userID := range []mid.UserID{testUser, bob} {
+		stateStore.SetConfigRoom(userID, testConfigRoom)
+		stateStore.SetTimezone(userID, "UTC")
+	}
+	stateStore.SetNotify(testUser, 13*60)
+	stateStore.SetNotify(bob, 12*60+30)
+
+	scheduler.start(tuesday)
+	due := scheduler.popDue(tuesday.Add(time.Hour))
+	if len(due) != 2 || due[0].userID != bob || due[1].userID != testUser {
+		t.Fatalf("Expected bob and then alice to be due, got %+v", due)
+	}
+
+	// Both users are scheduled for the next day.
+	if due := scheduler.popDue(tuesday.Add(23 * time.Hour)); len(due) != 0 {
+		t.Errorf("Expected nobody to be due again on the same day, got %+v", due)
+	}
+
+	// Changing the notification time reschedules the user.
+	stateStore.SetNotify(testUser, 11*60+15)
+	due = scheduler.popDue(tuesday.Add(23*time.Hour + 15*time.Minute))
+	if len(due) != 1 || due[0].userID != testUser {
+		t.Errorf("Expected alice to be due at her new time, got %+v", due)
+	}
+
+	stateStore.SetNotify(bob, 0)
+	if due := scheduler.popDue(tuesday.Add(48 * time.Hour)); len(due) != 1 || due[0].userID != testUser {
+		t.Errorf("Expected bob to no longer be reminded, got %+v", due)
+	}
+}
+
+// unreachableClient fails to get state events a number of times before passing
+// the requests on to the fake homeserver.
+type unreachableClient struct {
+	*fakeHomeserver
+	failures int
+}
+
+func (c *unreachableClient) StateEvent(roomID mid.RoomID, eventType mevent.Type, stateKey string, outContent interface{}) error {
+	if c.failures > 0 {
+		c.failures--
+		return httpError(http.StatusBadGateway, "", nil)
+	}
+	return c.fakeHomeserver.StateEvent(roomID, eventType, stateKey, outContent)
+}
+
+func (c *unreachableClient) State(roomID mid.RoomID) (mautrix.RoomStateMap, error) {
+	if c.failures > 0 {
+		c.failures--
+		return nil, httpError(http.StatusBadGateway, "", nil)
+	}
+	return c.fakeHomeserver.State(roomID)
+}
+
+func TestReminderSchedulerRetries(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	stateStore.SetConfigRoom(testUser, testConfigRoom)
+	stateStore.SetTimezone(testUser, "UTC")
+	minutes := 13 * 60
+	hs.SendStateEvent(testConfigRoom, types.StateNotify, "alice:example.com", types.NotifyEventContent{MinutesAfterMidnight: &minutes})
+	stateStore.Client = &unreachableClient{fakeHomeserver: hs, failures: 2}
+
+	// The notification time cannot be loaded in the first two minutes, so the
+	// user is not due, but is scheduled again.
+	scheduler.start(tuesday)
+	for minute := 0; minute < 2; minute++ {
+		if due := scheduler.popDue(tuesday.Add(time.Duration(minute) * time.Minute)); len(due) != 0 {
+			t.Errorf("Expected nobody to be due while the homeserver is unreachable, got %+v", due)
+		}
+	}
+	if due := scheduler.popDue(tuesday.Add(time.Hour)); len(due) != 1 || due[0].userID != testUser {
+		t.Errorf("Expected alice to be due once the notification time was loaded, got %+v", due)
+	}
+}
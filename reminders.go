@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mevent "maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	mid "maunium.net/go/mautrix/id"
+)
+
+// RunReminderLoop sends the reminders every minute until the context is done.
+func RunReminderLoop(ctx context.Context) {
+	log.Debugf("Starting notification loop")
+	for {
+		SendDueReminders(time.Now())
+
+		// Sleep until the next minute comes around
+		now := time.Now()
+		if !sleepContext(ctx, now.Truncate(time.Minute).Add(time.Minute).Sub(now)) {
+			log.Debugf("Stopped notification loop")
+			return
+		}
+	}
+}
+
+// The schedulers of the reminders and of the weekly summaries. Replaced in
+// tests.
+var scheduler = NewReminderScheduler()
+var weeklySummaryScheduler = NewWeeklySummaryScheduler()
+
+// rescheduleUser recalculates when the user is reminded and when their weekly
+// summary is compiled after their settings changed.
+func rescheduleUser(userID mid.UserID) {
+	scheduler.Reschedule(userID)
+	weeklySummaryScheduler.Reschedule(userID)
+}
+
+// SendDueReminders sends the reminders for the minutes since the last minute
+// that reminders were sent for, up to and including the current one. This
+// catches up on the reminders that were missed while the bot was down or the
+// loop was stalled, unless they are more than the grace period late. The
+// weekly summaries that are due are compiled the same way.
+func SendDueReminders(now time.Time) {
+	current := now.UTC().Truncate(time.Minute)
+	start := current
+	last, found, err := stateStore.GetLastReminderMinute()
+	if err != nil {
+		log.Errorf("Failed to get the last minute that reminders were sent for: %+v", err)
+	} else if found && !last.Before(current) {
+		// The reminders for this minute were already sent.
+		return
+	} else if found {
+		start = last.Add(time.Minute)
+		if oldest := current.Add(-configuration.GetMissedReminderGracePeriod()); start.Before(oldest) {
+			log.Warnf("Not sending the reminders between %s and %s because they are too late", start, oldest.Add(-time.Minute))
+			start = oldest
+		}
+	}
+	scheduler.start(start)
+	weeklySummaryScheduler.start(start)
+
+	// Record the minute before sending the reminders so that users are not
+	// reminded twice if the bot crashes while sending them.
+	if err := stateStore.SetLastReminderMinute(current); err != nil {
+		log.Errorf("Failed to record that the reminders for %s were sent: %+v", current, err)
+	}
+	for _, reminder := range scheduler.popDue(current) {
+		if reminder.at.Before(start) {
+			log.Warnf("Not reminding %s because the reminder at %s is too late", reminder.userID, reminder.at)
+			continue
+		} else if !canUseBot(reminder.userID) {
+			log.Debugf("Not reminding %s because they are not allowed to use the bot", reminder.userID)
+			continue
+		}
+		remind(reminder.userID, stateStore.GetConfigRoomId(reminder.userID), reminder.at.Before(current))
+	}
+	for _, summary := range weeklySummaryScheduler.popDue(current) {
+		if summary.at.Before(start) {
+			log.Warnf("Not compiling the weekly summary of %s because it is too late", summary.userID)
+			continue
+		} else if !canUseBot(summary.userID) {
+			log.Debugf("Not compiling the weekly summary of %s because they are not allowed to use the bot", summary.userID)
+			continue
+		}
+		ShowWeeklySummary(summary.userID, stateStore.GetConfigRoomId(summary.userID), now, false)
+	}
+}
+
+func remind(userID mid.UserID, roomID mid.RoomID, late bool) {
+	log.Infof("Notifying %s", userID)
+	lang := languageFor(userID)
+	if currentFlow, found := currentStandupFlows[userID]; !found || currentFlow.State == FlowNotStarted || currentFlow.State == Sent {
+		reminder := T(lang, "reminder")
+		if late {
+			reminder = T(lang, "reminder.late")
+		}
+		SendMessage(roomID, &mevent.MessageEventContent{
+			MsgType: mevent.MsgText,
+			Body:    reminder,
+		})
+		currentStandupFlows[userID] = BlankStandupFlow()
+		goTracked(func() { CreatePost(roomID, userID) })
+	} else {
+		content := format.RenderMarkdown(T(lang, "reminder.writing"), true, false)
+		SendMessage(roomID, &content)
+	}
+}
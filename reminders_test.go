@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupReminderTest(t *testing.T, notifyTime time.Time) *fakeHomeserver {
+	hs := setupTest(t, tuesday)
+	stateStore.SetConfigRoom(testUser, testConfigRoom)
+	stateStore.SetTimezone(testUser, "UTC")
+	stateStore.SetNotify(testUser, notifyTime.Hour()*60+notifyTime.Minute())
+	return hs
+}
+
+// sendDueReminders sends the reminders and waits for the standup posts that
+// they start.
+func sendDueReminders(now time.Time) {
+	SendDueReminders(now)
+	inFlight.wg.Wait()
+}
+
+func reminders(hs *fakeHomeserver) []string {
+	var reminders []string
+	for _, message := range hs.messages(testConfigRoom) {
+		if body := message.Content.AsMessage().Body; strings.HasPrefix(body, "Time to write your standup post!") {
+			reminders = append(reminders, body)
+		}
+	}
+	return reminders
+}
+
+func TestReminderOnTime(t *testing.T) {
+	hs := setupReminderTest(t, tuesday)
+	sendDueReminders(tuesday.Add(-time.Minute))
+	if len(reminders(hs)) != 0 {
+		t.Fatalf("Expected no reminder before the notification time, got %q", reminders(hs))
+	}
+
+	sendDueReminders(tuesday)
+	if sent := reminders(hs); len(sent) != 1 || strings.Contains(sent[0], "late") {
+		t.Errorf("Expected one reminder on time, got %q", sent)
+	}
+}
+
+func TestMissedReminderCatchUp(t *testing.T) {
+	hs := setupReminderTest(t, tuesday.Add(-30*time.Minute))
+	if err := stateStore.SetLastReminderMinute(tuesday.Add(-45 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	sendDueReminders(tuesday)
+	sent := reminders(hs)
+	if len(sent) != 1 {
+		t.Fatalf("Expected the missed reminder to be sent, got %q", sent)
+	}
+	assertContains(t, sent[0], "this reminder is late")
+
+	// Running again in the same minute does not remind the user again.
+	sendDueReminders(tuesday.Add(30 * time.Second))
+	if sent := reminders(hs); len(sent) != 1 {
+		t.Errorf("Expected the user to be reminded once, got %q", sent)
+	}
+}
+
+func TestMissedReminderGracePeriod(t *testing.T) {
+	hs := setupReminderTest(t, tuesday.Add(-2*time.Hour))
+	if err := stateStore.SetLastReminderMinute(tuesday.Add(-3 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	sendDueReminders(tuesday)
+	if sent := reminders(hs); len(sent) != 0 {
+		t.Errorf("Expected the reminder from two hours ago to be skipped, got %q", sent)
+	}
+	if last, _, _ := stateStore.GetLastReminderMinute(); !last.Equal(tuesday) {
+		t.Errorf("Expected the last reminder minute to be %s, got %s", tuesday, last)
+	}
+}
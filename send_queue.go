@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix"
+	mcrypto "maunium.net/go/mautrix/crypto"
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+
+	"github.com/beeper/standupbot/store"
+)
+
+// How many times to try sending a message that fails with an error other than
+// a rate limit before giving up.
+const maxSendAttempts = 5
+
+// How long to wait after being rate limited if the homeserver does not say.
+const defaultRateLimitDelay = 5 * time.Second
+
+// errShuttingDown is returned for messages that were not sent because the bot
+// is shutting down. They are sent when the bot starts again.
+var errShuttingDown = errors.New("the bot is shutting down")
+
+type sendResult struct {
+	resp *mautrix.RespSendEvent
+	err  error
+}
+
+// queuedMessage is a message in the send queue of a room.
+type queuedMessage struct {
+	store.OutgoingMessage
+	content *mevent.Content
+	// Receives the result of sending the message. Messages that were restored
+	// from the database have no result channel.
+	result chan sendResult
+}
+
+// The send queues of the rooms that have messages waiting to be sent. Each
+// queue has a goroutine that sends its messages in order, and that exits when
+// the queue is empty.
+var sendQueues = struct {
+	sync.Mutex
+	rooms map[mid.RoomID][]*queuedMessage
+}{rooms: map[mid.RoomID][]*queuedMessage{}}
+
+// Rate limits apply to the bot's account rather than to a room, so all of the
+// queues wait until this time (in Unix nanoseconds) after being rate limited.
+var rateLimitedUntil int64
+
+var transactionCounter int64
+
+func newTransactionID() string {
+	return fmt.Sprintf("standupbot-%d-%d", time.Now().UnixNano(), atomic.AddInt64(&transactionCounter, 1))
+}
+
+// enqueueMessage stores the message in the outbox and adds it to the send queue
+// of its room.
+func enqueueMessage(message *queuedMessage) {
+	if err := stateStore.QueueOutgoingMessage(message.OutgoingMessage); err != nil {
+		log.Errorf("Failed to store outgoing message %s: %+v", message.TransactionID, err)
+	}
+
+	sendQueues.Lock()
+	defer sendQueues.Unlock()
+	queue, running := sendQueues.rooms[message.RoomID]
+	sendQueues.rooms[message.RoomID] = append(queue, message)
+	if running {
+		return
+	}
+	// The shutdown waits for the queue so that the database is still open
+	// when the message is removed from the outbox.
+	if !goTracked(func() { runSendQueue(message.RoomID) }) {
+		log.Infof("Not sending message %s to %s until the bot starts again", message.TransactionID, message.RoomID)
+		delete(sendQueues.rooms, message.RoomID)
+		if message.result != nil {
+			message.result <- sendResult{nil, errShuttingDown}
+		}
+	}
+}
+
+// ResumeSendQueues queues the messages that were not sent before the bot
+// stopped.
+func ResumeSendQueues() {
+	messages := stateStore.GetOutgoingMessages()
+	if len(messages) > 0 {
+		log.Infof("Resuming sending %d messages", len(messages))
+	}
+	for _, outgoing := range messages {
+		// Unmarshalling keeps the fields that are not in the parsed struct,
+		// like the user that the message is on behalf of.
+		content := &mevent.Content{}
+		if err := json.Unmarshal(outgoing.Content, content); err != nil {
+			log.Errorf("Failed to parse outgoing message %s: %+v", outgoing.TransactionID, err)
+			stateStore.RemoveOutgoingMessage(outgoing.TransactionID)
+			continue
+		} else if err := content.ParseRaw(mevent.EventMessage); err != nil {
+			log.Errorf("Failed to parse outgoing message %s: %+v", outgoing.TransactionID, err)
+			stateStore.RemoveOutgoingMessage(outgoing.TransactionID)
+			continue
+		}
+		enqueueMessage(&queuedMessage{OutgoingMessage: outgoing, content: content})
+	}
+}
+
+func runSendQueue(roomID mid.RoomID) {
+	for {
+		sendQueues.Lock()
+		queue := sendQueues.rooms[roomID]
+		if len(queue) == 0 {
+			delete(sendQueues.rooms, roomID)
+			sendQueues.Unlock()
+			return
+		}
+		message := queue[0]
+		sendQueues.rooms[roomID] = queue[1:]
+		sendQueues.Unlock()
+
+		resp, err := sendWithRetries(message)
+		if err == nil {
+			log.Debugf("Sent message %s to %s as %s", message.TransactionID, roomID, resp.EventID)
+		} else if err == errShuttingDown {
+			log.Infof("Not sending message %s to %s until the bot starts again", message.TransactionID, roomID)
+		} else {
+			log.Errorf("Failed to send message %s to %s: %+v", message.TransactionID, roomID, err)
+		}
+		if err != errShuttingDown {
+			stateStore.RemoveOutgoingMessage(message.TransactionID)
+		}
+		if message.result != nil {
+			message.result <- sendResult{resp, err}
+		}
+	}
+}
+
+// sendWithRetries sends the message, waiting as long as the homeserver asks
+// when rate limited and backing off after other temporary errors. While the bot
+// is shutting down, it only sends messages that do not have to wait.
+func sendWithRetries(message *queuedMessage) (*mautrix.RespSendEvent, error) {
+	attempts := 0
+	for {
+		if wait := time.Until(time.Unix(0, atomic.LoadInt64(&rateLimitedUntil))); wait > 0 {
+			if !sleepContext(shutdownCtx, wait) {
+				return nil, errShuttingDown
+			}
+		}
+
+		resp, err := sendMessageEvent(message)
+		if err == nil {
+			return resp, nil
+		}
+
+		var delay time.Duration
+		if retryAfter, limited := rateLimitDelay(err); limited {
+			log.Warnf("Rate limited while sending to %s, retrying in %s", message.RoomID, retryAfter)
+			atomic.StoreInt64(&rateLimitedUntil, time.Now().Add(retryAfter).UnixNano())
+			continue
+		} else if !isTemporarySendError(err) {
+			return nil, err
+		} else if attempts++; attempts >= maxSendAttempts {
+			return nil, err
+		} else {
+			delay = time.Second << uint(attempts-1)
+		}
+		log.Debugf("Sending %s to %s failed. Retrying in %s. Error: %+v", message.TransactionID, message.RoomID, delay, err)
+		if !sleepContext(shutdownCtx, delay) {
+			return nil, errShuttingDown
+		}
+	}
+}
+
+// rateLimitDelay returns how long to wait if the error is a rate limit.
+func rateLimitDelay(err error) (time.Duration, bool) {
+	var httpErr mautrix.HTTPError
+	if !errors.As(err, &httpErr) {
+		return 0, false
+	}
+	if !httpErr.IsStatus(429) && (httpErr.RespError == nil || httpErr.RespError.ErrCode != mautrix.MLimitExceeded.ErrCode) {
+		return 0, false
+	}
+	if httpErr.RespError != nil {
+		if retryAfterMs, ok := httpErr.RespError.ExtraData["retry_after_ms"].(float64); ok && retryAfterMs > 0 {
+			return time.Duration(retryAfterMs) * time.Millisecond, true
+		}
+	}
+	return defaultRateLimitDelay, true
+}
+
+// isTemporarySendError returns whether sending might succeed if it is retried.
+// Errors that the homeserver responds to with a 4xx status code, like not
+// being allowed to send to the room, are not temporary.
+func isTemporarySendError(err error) bool {
+	var httpErr mautrix.HTTPError
+	if errors.As(err, &httpErr) && httpErr.Response != nil {
+		return httpErr.Response.StatusCode >= 500
+	}
+	return true
+}
+
+func sendMessageEvent(message *queuedMessage) (*mautrix.RespSendEvent, error) {
+	roomID := message.RoomID
+	req := mautrix.ReqSendEvent{TransactionID: message.TransactionID}
+	if !stateStore.IsEncrypted(roomID) {
+		log.Debugf("Sending unencrypted event to %s", roomID)
+		sender, err := clientFor(message.OnBehalfOf, roomID)
+		if err != nil {
+			return nil, err
+		}
+		return sender.SendMessageEvent(roomID, mevent.EventMessage, message.content, req)
+	}
+
+	log.Debugf("Sending encrypted event to %s", roomID)
+	encrypted, err := olmMachine.EncryptMegolmEvent(roomID, mevent.EventMessage, message.content)
+
+	// These three errors mean we have to make a new Megolm session
+	if err == mcrypto.SessionExpired || err == mcrypto.SessionNotShared || err == mcrypto.NoGroupSession {
+		err = olmMachine.ShareGroupSession(roomID, stateStore.GetRoomMembers(roomID))
+		if err != nil {
+			log.Errorf("Failed to share group session to %s: %s", roomID, err)
+			return nil, err
+		}
+
+		encrypted, err = olmMachine.EncryptMegolmEvent(roomID, mevent.EventMessage, message.content)
+	}
+
+	if err != nil {
+		log.Errorf("Failed to encrypt message to %s: %s", roomID, err)
+		return nil, err
+	}
+
+	encrypted.RelatesTo = message.content.AsMessage().RelatesTo // The m.relates_to field should be unencrypted, so copy it.
+	return matrixClient.SendMessageEvent(roomID, mevent.EventEncrypted, encrypted, req)
+}
+
+// queueMessage adds the message to the send queue of the room and waits until
+// it is sent.
+func queueMessage(user *mid.UserID, roomID mid.RoomID, content *mevent.Content) (*mautrix.RespSendEvent, error) {
+	contentJSON, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	message := &queuedMessage{
+		OutgoingMessage: store.OutgoingMessage{
+			TransactionID: newTransactionID(),
+			RoomID:        roomID,
+			OnBehalfOf:    user,
+			Content:       contentJSON,
+			QueuedAt:      time.Now(),
+		},
+		content: content,
+		result:  make(chan sendResult, 1),
+	}
+	enqueueMessage(message)
+	result := <-message.result
+	return result.resp, result.err
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix"
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+
+	"github.com/beeper/standupbot/store"
+)
+
+// failingClient fails sending messages with the queued errors before passing
+// them on to the fake homeserver.
+type failingClient struct {
+	*fakeHomeserver
+	lock           sync.Mutex
+	errors         []error
+	transactionIDs []string
+}
+
+func (c *failingClient) SendMessageEvent(roomID mid.RoomID, eventType mevent.Type, contentJSON interface{}, extra ...mautrix.ReqSendEvent) (*mautrix.RespSendEvent, error) {
+	c.lock.Lock()
+	c.transactionIDs = append(c.transactionIDs, extra[0].TransactionID)
+	if len(c.errors) > 0 {
+		err := c.errors[0]
+		c.errors = c.errors[1:]
+		c.lock.Unlock()
+		return nil, err
+	}
+	c.lock.Unlock()
+	return c.fakeHomeserver.SendMessageEvent(roomID, eventType, contentJSON, extra...)
+}
+
+func httpError(status int, errcode string, extra map[string]interface{}) error {
+	if extra == nil {
+		extra = map[string]interface{}{}
+	}
+	return mautrix.HTTPError{
+		Request:   httptest.NewRequest(http.MethodPut, "/_matrix/client/r0/rooms/!send:example.com/send", nil),
+		Response:  &http.Response{StatusCode: status},
+		RespError: &mautrix.RespError{ErrCode: errcode, Err: errcode, ExtraData: extra},
+	}
+}
+
+func TestSendQueueRateLimit(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	failing := &failingClient{fakeHomeserver: hs, errors: []error{
+		httpError(http.StatusTooManyRequests, "M_LIMIT_EXCEEDED", map[string]interface{}{"retry_after_ms": float64(100)}),
+	}}
+	matrixClient = failing
+
+	start := time.Now()
+	results := make(chan string, 2)
+	go func() {
+		resp, err := SendMessage(testSendRoom, &mevent.MessageEventContent{MsgType: mevent.MsgText, Body: "first"})
+		if err != nil {
+			t.Error(err)
+		}
+		results <- resp.EventID.String()
+	}()
+	// Give the first message time to be queued.
+	time.Sleep(10 * time.Millisecond)
+	if _, err := SendMessage(testSendRoom, &mevent.MessageEventContent{MsgType: mevent.MsgText, Body: "second"}); err != nil {
+		t.Fatal(err)
+	}
+	<-results
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected to wait for the rate limit, only waited %s", elapsed)
+	}
+	messages := hs.messages(testSendRoom)
+	if len(messages) != 2 || messages[0].Content.AsMessage().Body != "first" || messages[1].Content.AsMessage().Body != "second" {
+		t.Errorf("Expected the messages to be sent in order, got %v", messages)
+	}
+	if failing.transactionIDs[0] != failing.transactionIDs[1] {
+		t.Errorf("Expected the retry to use the same transaction ID, got %v", failing.transactionIDs)
+	}
+	if outbox := stateStore.GetOutgoingMessages(); len(outbox) != 0 {
+		t.Errorf("Expected the outbox to be empty, got %v", outbox)
+	}
+}
+
+func TestSendQueuePermanentError(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	matrixClient = &failingClient{fakeHomeserver: hs, errors: []error{
+		httpError(http.StatusForbidden, "M_FORBIDDEN", nil),
+	}}
+
+	if _, err := SendMessage(testSendRoom, &mevent.MessageEventContent{MsgType: mevent.MsgText, Body: "hello"}); err == nil {
+		t.Error("Expected sending to fail")
+	}
+	if outbox := stateStore.GetOutgoingMessages(); len(outbox) != 0 {
+		t.Errorf("Expected the message to be dropped, got %v", outbox)
+	}
+}
+
+func TestResumeSendQueues(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	user := testUser
+	content, _ := json.Marshal(&mevent.Content{
+		Raw:    map[string]interface{}{"space.nevarro.msc3464.on_behalf_of": testUser},
+		Parsed: &mevent.MessageEventContent{MsgType: mevent.MsgText, Body: "from before"},
+	})
+	if err := stateStore.QueueOutgoingMessage(store.OutgoingMessage{
+		TransactionID: "txn1",
+		RoomID:        testSendRoom,
+		OnBehalfOf:    &user,
+		Content:       content,
+		QueuedAt:      time.Now(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	resetShutdown(t)
+	ResumeSendQueues()
+	// The shutdown waits until the message is sent and removed from the
+	// outbox.
+	if !waitForInFlight(5 * time.Second) {
+		t.Fatal("The queued message was not sent")
+	}
+	if outbox := stateStore.GetOutgoingMessages(); len(outbox) != 0 {
+		t.Errorf("Expected the outbox to be empty, got %v", outbox)
+	}
+	assertContains(t, hs.lastMessage(t, testSendRoom).Content.AsMessage().Body, "from before")
+	var sent map[string]interface{}
+	sentJSON, _ := json.Marshal(&hs.lastMessage(t, testSendRoom).Content)
+	if err := json.Unmarshal(sentJSON, &sent); err != nil {
+		t.Fatal(err)
+	}
+	if onBehalfOf := sent["space.nevarro.msc3464.on_behalf_of"]; onBehalfOf != testUser.String() {
+		t.Errorf("Expected the resumed message to be on behalf of %s, got %v in %s", testUser, onBehalfOf, sentJSON)
+	}
+
+	// Messages that are queued during the shutdown stay in the outbox.
+	if _, err := SendMessage(testSendRoom, &mevent.MessageEventContent{MsgType: mevent.MsgText, Body: "too late"}); err != errShuttingDown {
+		t.Errorf("Expected sending during the shutdown to fail with %v, got %v", errShuttingDown, err)
+	}
+	if outbox := stateStore.GetOutgoingMessages(); len(outbox) != 1 {
+		t.Errorf("Expected the message to stay in the outbox, got %v", outbox)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// How long to wait for the in-flight handlers to finish when shutting down.
+const shutdownTimeout = 30 * time.Second
+
+// shutdownCtx is cancelled when the bot starts shutting down. The sync loop and
+// the other loops stop when it is done.
+var shutdownCtx, startShutdown = context.WithCancel(context.Background())
+
+// The event handlers and retries that are in progress. Once the bot is
+// stopping, no new ones are tracked.
+var inFlight = struct {
+	sync.Mutex
+	wg       *sync.WaitGroup
+	stopping bool
+}{wg: &sync.WaitGroup{}}
+
+// track registers a unit of work that the shutdown waits for. It returns false
+// if the bot is already shutting down.
+func track() (done func(), ok bool) {
+	inFlight.Lock()
+	defer inFlight.Unlock()
+	if inFlight.stopping {
+		return nil, false
+	}
+	wg := inFlight.wg
+	wg.Add(1)
+	return wg.Done, true
+}
+
+// goTracked runs fn in a goroutine that the shutdown waits for. If the bot is
+// shutting down, fn is not run and it returns false.
+func goTracked(fn func()) bool {
+	done, ok := track()
+	if !ok {
+		log.Debug("Shutting down, not starting a new handler")
+		return false
+	}
+	go func() {
+		defer done()
+		fn()
+	}()
+	return true
+}
+
+// sleepContext sleeps for the duration and returns false if the context is
+// done before that.
+func sleepContext(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// waitForInFlight stops tracking new work and waits until the in-flight work
+// is done or the timeout passes. It returns whether all of the work finished.
+func waitForInFlight(timeout time.Duration) bool {
+	inFlight.Lock()
+	inFlight.stopping = true
+	wg := inFlight.wg
+	inFlight.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Shutdown stops receiving events, waits for the in-flight handlers, saves the
+// current standup flows and closes the database.
+func Shutdown(db *sql.DB, flowsPath string) {
+	log.Info("Shutting down")
+	startShutdown()
+	if client != nil {
+		client.StopSync()
+	}
+	if appService != nil {
+		appService.Stop()
+	}
+
+	if waitForInFlight(shutdownTimeout) {
+		log.Info("All handlers finished")
+	} else {
+		log.Warnf("Some handlers did not finish within %s", shutdownTimeout)
+	}
+
+	if err := SaveFlows(flowsPath, currentStandupFlows); err != nil {
+		log.Errorf("Failed to save the current standup flows: %+v", err)
+	} else {
+		log.Info("Saved current flows to disk.")
+	}
+	db.Close()
+}
+
+// handleShutdownSignals shuts down the bot and exits when one of the signals
+// arrives. A second signal exits immediately.
+func handleShutdownSignals(signals <-chan os.Signal, db *sql.DB, flowsPath string) {
+	<-signals
+	go func() {
+		<-signals
+		log.Warn("Received a second signal, exiting immediately")
+		os.Exit(1)
+	}()
+	Shutdown(db, flowsPath)
+	os.Exit(0)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func resetShutdown(t *testing.T) {
+	reset := func() {
+		shutdownCtx, startShutdown = context.WithCancel(context.Background())
+		inFlight.Lock()
+		defer inFlight.Unlock()
+		// A handler that timed out may still be running, so its wait group
+		// is not reused.
+		inFlight.wg = &sync.WaitGroup{}
+		inFlight.stopping = false
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestShutdownWaitsForHandlers(t *testing.T) {
+	setupTest(t, tuesday)
+	resetShutdown(t)
+	flowsPath := filepath.Join(t.TempDir(), "current-flows.json")
+	currentStandupFlows[testUser] = BlankStandupFlow()
+
+	release := make(chan struct{})
+	goTracked(func() { <-release })
+
+	stopped := make(chan struct{})
+	go func() {
+		Shutdown(stateStore.DB, flowsPath)
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("Shutdown returned before the handler finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if shutdownCtx.Err() == nil {
+		t.Error("Expected the shutdown context to be cancelled")
+	}
+
+	// New handlers are not started once the bot is shutting down.
+	goTracked(func() { t.Error("Handler started during shutdown") })
+
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return after the handler finished")
+	}
+
+	flows, err := LoadFlows(flowsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := flows[testUser]; !found {
+		t.Errorf("Expected the flows to be saved, got %v", flows)
+	}
+	if err := stateStore.DB.Ping(); err == nil {
+		t.Error("Expected the database to be closed")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	resetShutdown(t)
+	release := make(chan struct{})
+	goTracked(func() { <-release })
+
+	if waitForInFlight(10 * time.Millisecond) {
+		t.Error("Expected waiting for a stuck handler to time out")
+	}
+	close(release)
+	if !waitForInFlight(5 * time.Second) {
+		t.Error("Expected the handler to finish after it was released")
+	}
+}
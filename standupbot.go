@@ -2,22 +2,16 @@ package main
 
 import (
 	"database/sql"
-	"encoding/json"
 	"flag"
 	"io"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
-	"time"
 
-	"github.com/kyoh86/xdg"
-	_ "github.com/mattn/go-sqlite3"
 	log "github.com/sirupsen/logrus"
 	"maunium.net/go/mautrix"
 	mcrypto "maunium.net/go/mautrix/crypto"
 	mevent "maunium.net/go/mautrix/event"
-	"maunium.net/go/mautrix/format"
 	mid "maunium.net/go/mautrix/id"
 
 	"github.com/beeper/standupbot/store"
@@ -25,6 +19,7 @@ import (
 )
 
 var client *mautrix.Client
+var matrixClient MatrixClient
 var configuration Configuration
 var olmMachine *mcrypto.OlmMachine
 var stateStore *store.StateStore
@@ -33,9 +28,11 @@ var VERSION = "0.4.1"
 
 func main() {
 	// Arg parsing
-	configPath := flag.String("config", "./config.json", "config file location")
+	configPathFlag := flag.String("config", "./config.json", "config file location")
 	logLevelStr := flag.String("loglevel", "debug", "the log level")
 	logFilename := flag.String("logfile", "", "the log file to use (defaults to '' meaning no log file)")
+	ssoLogin := flag.Bool("sso-login", false, "log in using SSO instead of the configured password")
+	generateRegistration := flag.Bool("generate-registration", false, "generate the appservice registration file and exit")
 	flag.Parse()
 
 	// Configure logging
@@ -60,177 +57,166 @@ func main() {
 	log.Info("standupbot starting...")
 
 	// Load configuration
-	log.Infof("reading config from %s...", *configPath)
-	configJson, err := os.ReadFile(*configPath)
+	configPath = *configPathFlag
+	log.Infof("reading config from %s...", configPath)
+	configuration, err = LoadConfiguration(configPath)
 	if err != nil {
-		log.Fatalf("Could not read config from %s: %s", *configPath, err)
+		log.Fatalf("Could not load config from %s: %s", configPath, err)
 	}
-
-	err = json.Unmarshal(configJson, &configuration)
+	configuration.ApplyLogLevel()
 	username := mid.UserID(configuration.Username)
+	CompileCommandPatterns()
+	types.SetNamespace(configuration.GetStateEventNamespace())
+
+	if *generateRegistration {
+		if !configuration.Appservice.Enabled() {
+			log.Fatal("Appservice.RegistrationFile must be set to generate a registration")
+		}
+		if err := GenerateRegistration(configuration.Appservice.RegistrationFile); err != nil {
+			log.Fatalf("Failed to generate registration: %+v", err)
+		}
+		log.Infof("Wrote appservice registration to %s", configuration.Appservice.RegistrationFile)
+		return
+	}
 
-	// Create directory /test
-	dataDir := xdg.DataHome() + "/standupbot"
+	dataDir := configuration.GetDataDir()
 	if err = os.MkdirAll(dataDir, os.ModePerm); err != nil {
 		log.Fatalf("Could not create data directory %s: %s", dataDir, err)
 	}
 
 	// Open the config database
-	db, err := sql.Open("sqlite3", dataDir+"/standupbot.db")
+	db, dialect, err := store.Open(configuration.GetDatabaseURI())
 	if err != nil {
-		log.Fatal("Could not open standupbot database.")
+		log.Fatalf("Could not open standupbot database: %v", err)
 	}
 
-	currentStandupFlowsJson, err := os.ReadFile(dataDir + "/current-flows.json")
-	if err != nil {
-		log.Warn("Couldn't open the current-flows JSON.")
-	} else {
-		err = json.Unmarshal(currentStandupFlowsJson, &currentStandupFlows)
-		if err != nil {
-			log.Warnf("Failed to unmarshal the current flows JSON: %+v", err)
-		} else {
-			log.Info("Loaded current flows from disk.")
+	flowsPath := dataDir + "/current-flows.json"
+	switch flag.Arg(0) {
+	case "":
+		// Run the bot.
+	case "rekey":
+		if err := RekeyCryptoStore(db, flag.Arg(1)); err != nil {
+			log.Fatalf("Failed to rekey the crypto store: %+v", err)
+		}
+		return
+	case "migrate-state":
+		// Handled after logging in.
+	case "doctor":
+		if err := RunDoctor(os.Stdout, db, dialect, flowsPath); err != nil {
+			log.Fatal(err)
 		}
+		return
+	case "export":
+		if err := ExportData(os.Stdout, db, dialect, flowsPath); err != nil {
+			log.Fatalf("Failed to export the data: %+v", err)
+		}
+		return
+	case "flows":
+		if err := RunFlowsCommand(os.Stdout, flowsPath, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "db":
+		if err := RunDBCommand(os.Stdout, db, dialect, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	default:
+		log.Fatalf("Unknown subcommand %s", flag.Arg(0))
+	}
+
+	if problems := configuration.Validate(); len(problems) > 0 {
+		for _, problem := range problems {
+			log.Error(problem)
+		}
+		log.Fatalf("Found %d problems in the config at %s", len(problems), configPath)
+	}
+
+	if flows, err := LoadFlows(flowsPath); err != nil {
+		log.Warnf("Couldn't load the current flows: %v", err)
+	} else {
+		currentStandupFlows = flows
+		log.Info("Loaded current flows from disk.")
 	}
 
 	// Make sure to exit cleanly
-	c := make(chan os.Signal, 1)
+	c := make(chan os.Signal, 2)
 	signal.Notify(c,
 		os.Interrupt,
-		os.Kill,
 		syscall.SIGABRT,
-		syscall.SIGHUP,
 		syscall.SIGINT,
 		syscall.SIGQUIT,
 		syscall.SIGTERM,
 	)
+	go handleShutdownSignals(c, db, flowsPath)
+
+	stateStore = store.NewStateStore(db, dialect)
+	stateStore.SetDefaults(configuration.Defaults.UserDefaults())
+	stateStore.OnReminderSettingsChanged = rescheduleUser
+
+	// Reload the configuration on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	go func() {
-		for range c { // when the process is killed
-			log.Info("Cleaning up")
-			db.Close()
-			bytes, err := json.Marshal(currentStandupFlows)
-			if err != nil {
-				log.Error("Failed to serialize current standup flows!")
-			} else {
-				currentStandupFlowsFile, err := os.OpenFile(dataDir+"/current-flows.json", os.O_CREATE|os.O_WRONLY, 0600)
-				if err != nil {
-					log.Error("Failed to open current standup flows JSON file!")
-				} else {
-					_, err = currentStandupFlowsFile.Write(bytes)
-					if err != nil {
-						log.Error("Failed to write current standup flows JSON to file!")
-					} else {
-						log.Info("Saved current flows to disk.")
-					}
-				}
-				currentStandupFlowsFile.Close()
+		for range hup {
+			log.Info("Received SIGHUP, reloading the configuration")
+			if err := ReloadConfiguration(); err != nil {
+				log.Errorf("Failed to reload the configuration: %v", err)
 			}
-			os.Exit(0)
 		}
 	}()
-
-	stateStore = store.NewStateStore(db)
-	if err := stateStore.CreateTables(); err != nil {
-		log.Fatalf("Failed to create the tables for standupbot: %v", err)
+	if err := stateStore.Upgrade(); err != nil {
+		log.Fatalf("Failed to upgrade the database: %v", err)
 	}
 
-	// login to homeserver
-	log.Info("Logging in")
-	password, err := configuration.GetPassword()
-	if err != nil {
-		log.Fatalf("Could not read password from %s", configuration.PasswordFile)
-	}
 	deviceID := FindDeviceID(db, username.String())
 	if len(deviceID) > 0 {
 		log.Info("Found existing device ID in database:", deviceID)
 	}
-	client, err = mautrix.NewClient(configuration.Homeserver, "", "")
-	if err != nil {
-		panic(err)
-	}
-	_, err = DoRetry("login", func() (interface{}, error) {
-		return client.Login(&mautrix.ReqLogin{
-			Type: mautrix.AuthTypePassword,
-			Identifier: mautrix.UserIdentifier{
-				Type: mautrix.IdentifierTypeUser,
-				User: username.String(),
-			},
-			Password:                 password,
-			InitialDeviceDisplayName: "standupbot",
-			DeviceID:                 deviceID,
-			StoreCredentials:         true,
-		})
-	})
-	if err != nil {
-		log.Fatalf("Couldn't login to the homeserver.")
+	if configuration.Appservice.Enabled() {
+		log.Info("Initializing appservice")
+		client, err = InitAppservice(deviceID)
+		if err != nil {
+			log.Fatalf("Couldn't initialize the appservice: %+v", err)
+		}
+	} else {
+		log.Info("Logging in")
+		client, err = Login(deviceID, *ssoLogin)
+		if err != nil {
+			log.Fatalf("Couldn't login to the homeserver: %+v", err)
+		}
 	}
 	log.Infof("Logged in as %s/%s", client.UserID, client.DeviceID)
 
 	// set the client store on the client.
 	client.Store = stateStore
 	stateStore.Client = client
-
-	// Load state from all of the rooms that we are joined to in case the
-	// database died.
-	log.Info("Loading state from joined rooms...")
-	joinedRooms, err := client.JoinedRooms()
-	if err == nil {
-		for _, roomID := range joinedRooms.JoinedRooms {
-			members, err := client.Members(roomID)
-			potentialUsers := make([]mid.UserID, 0)
-			if err != nil {
-				continue
-			}
-			for _, membershipEvent := range members.Chunk {
-				potentialUsers = append(potentialUsers, membershipEvent.Sender)
-			}
-
-			for _, userID := range potentialUsers {
-				stateKey := strings.TrimPrefix(userID.String(), "@")
-
-				var tzSettingEventContent types.TzSettingEventContent
-				if err := client.StateEvent(roomID, types.StateTzSetting, stateKey, &tzSettingEventContent); err == nil {
-					if location, err := time.LoadLocation(tzSettingEventContent.TzString); err == nil {
-						log.Infof("Loaded timezone (%s) for %s from state", location, userID)
-						stateStore.SetConfigRoom(userID, roomID)
-						stateStore.SetTimezone(userID, location.String())
-					}
-				}
-
-				var notifyEventContent types.NotifyEventContent
-				if err := client.StateEvent(roomID, types.StateNotify, stateKey, &notifyEventContent); err == nil {
-					if notifyEventContent.MinutesAfterMidnight != nil {
-						log.Infof("Loaded notification minutes after midnight (%d) for %s from state", *notifyEventContent.MinutesAfterMidnight, userID)
-						stateStore.SetConfigRoom(userID, roomID)
-						stateStore.SetNotify(userID, *notifyEventContent.MinutesAfterMidnight)
-					}
-				}
-
-				var sendRoomEventContent types.SendRoomEventContent
-				if err := client.StateEvent(roomID, types.StateSendRoom, stateKey, &sendRoomEventContent); err == nil {
-					log.Infof("Loaded send room (%s) for %s from state", sendRoomEventContent.SendRoomID, userID)
-					stateStore.SetConfigRoom(userID, roomID)
-					stateStore.SetSendRoomId(userID, sendRoomEventContent.SendRoomID)
-				}
-
-				var useThreadsEventContent types.UseThreadsEventContent
-				if err := client.StateEvent(roomID, types.StateUseThreads, stateKey, &useThreadsEventContent); err == nil {
-					log.Infof("Loaded thread usage setting (%t) for %s from state", useThreadsEventContent.UseThreads, userID)
-					stateStore.SetConfigRoom(userID, roomID)
-					stateStore.SetUseThreads(userID, useThreadsEventContent.UseThreads)
-				}
-			}
+	matrixClient = client
+
+	if flag.Arg(0) == "migrate-state" {
+		migrateFlags := flag.NewFlagSet("migrate-state", flag.ExitOnError)
+		from := migrateFlags.String("from", types.DefaultNamespace, "the namespace to copy the settings from")
+		dryRun := migrateFlags.Bool("dry-run", false, "only log the settings that would be copied")
+		migrateFlags.Parse(flag.Args()[1:])
+		if err := MigrateState(*from, *dryRun); err != nil {
+			log.Fatalf("Failed to migrate the state events: %+v", err)
 		}
+		return
 	}
-	log.Info("Finished loading state from joined rooms")
+
+	LoadSettings()
 
 	// Setup the crypto store
+	pickleKey, err := configuration.GetPickleKey()
+	if err != nil {
+		log.Fatalf("Could not read the pickle key: %+v", err)
+	}
 	sqlCryptoStore := mcrypto.NewSQLCryptoStore(
 		db,
-		"sqlite3",
+		string(dialect),
 		username.String(),
 		client.DeviceID,
-		[]byte("standupbot_cryptostore_key"),
+		pickleKey,
 		CryptoLogger{},
 	)
 	err = sqlCryptoStore.CreateTables()
@@ -244,94 +230,105 @@ func main() {
 		log.Errorf("Could not initialize encryption support. Encrypted rooms will not work.")
 	}
 
-	syncer := client.Syncer.(*mautrix.DefaultSyncer)
+	if configuration.RecoveryKeyFile != "" {
+		keyBackup, err := SetupKeyBackup()
+		if err != nil {
+			log.Errorf("Could not set up key backup: %+v", err)
+		} else {
+			if err := keyBackup.Restore(); err != nil {
+				log.Errorf("Failed to restore sessions from key backup: %+v", err)
+			}
+			keyBackup.StartKeyBackupLoop()
+		}
+	}
+
+	olmMachine.AcceptVerificationFrom = AcceptVerificationFrom
+	if configuration.CrossSigningRecoveryKeyFile != "" {
+		if err := SetupCrossSigning(); err != nil {
+			log.Errorf("Could not set up cross-signing: %+v", err)
+		}
+	}
+
+	// Send the messages that were not sent before the bot stopped
+	ResumeSendQueues()
+
+	syncer := client.Syncer.(mautrix.ExtensibleSyncer)
 	// Hook up the OlmMachine into the Matrix client so it receives e2ee
 	// keys and other such things.
 	syncer.OnSync(func(resp *mautrix.RespSync, since string) bool {
 		olmMachine.ProcessSyncResponse(resp, since)
+		syncSucceeded()
+		StartRetryingUndecryptableEvents()
 		return true
 	})
 
-	syncer.OnEventType(mevent.StateMember, func(_ mautrix.EventSource, event *mevent.Event) {
+	// Notification loop
+	notificationLoopDone, _ := track()
+	go func() {
+		defer notificationLoopDone()
+		RunReminderLoop(shutdownCtx)
+	}()
+
+	if configuration.Appservice.Enabled() {
+		StartAppservice(RegisterEventHandlers)
+		// The signal handler exits once it has finished shutting down.
+		select {}
+	}
+
+	RegisterEventHandlers(func(eventType mevent.Type, handler func(*mevent.Event)) {
+		syncer.OnEventType(eventType, func(_ mautrix.EventSource, event *mevent.Event) { handler(event) })
+	})
+	RunSyncLoop(shutdownCtx, "sync")
+	// The signal handler exits once it has finished shutting down.
+	select {}
+}
+
+// RegisterEventHandlers hooks up the event handlers to a transport, either the
+// /sync loop or the appservice transactions.
+func RegisterEventHandlers(on func(mevent.Type, func(*mevent.Event))) {
+	username := mid.UserID(configuration.Username)
+
+	on(mevent.StateMember, func(event *mevent.Event) {
 		olmMachine.HandleMemberEvent(event)
 		stateStore.SetMembership(event)
 
 		if event.GetStateKey() == username.String() && event.Content.AsMember().Membership == mevent.MembershipInvite {
-			log.Info("Joining ", event.RoomID)
-			_, err := DoRetry("join room", func() (interface{}, error) {
-				return client.JoinRoomByID(event.RoomID)
-			})
-			if err != nil {
-				log.Errorf("Could not join channel %s. Error %+v", event.RoomID.String(), err)
-			} else {
-				log.Infof("Joined %s sucessfully", event.RoomID.String())
-			}
+			goTracked(func() { HandleInvite(event) })
 		} else if event.GetStateKey() == username.String() && event.Content.AsMember().Membership.IsLeaveOrBan() {
 			log.Infof("Left or banned from %s", event.RoomID)
 		}
 	})
 
-	syncer.OnEventType(mevent.StateEncryption, func(_ mautrix.EventSource, event *mevent.Event) {
+	on(mevent.StateTombstone, func(event *mevent.Event) { goTracked(func() { HandleTombstone(event) }) })
+
+	on(mevent.StateEncryption, func(event *mevent.Event) {
 		stateStore.SetEncryptionEvent(event)
 	})
 
-	syncer.OnEventType(mevent.EventReaction, func(source mautrix.EventSource, event *mevent.Event) { go HandleReaction(source, event) })
-
-	syncer.OnEventType(mevent.EventMessage, func(source mautrix.EventSource, event *mevent.Event) { go HandleMessage(source, event) })
-
-	syncer.OnEventType(mevent.EventRedaction, func(source mautrix.EventSource, event *mevent.Event) { go HandleRedaction(source, event) })
+	on(mevent.EventReaction, func(event *mevent.Event) { goTracked(func() { HandleReaction(event) }) })
 
-	syncer.OnEventType(mevent.EventEncrypted, func(source mautrix.EventSource, event *mevent.Event) {
-		decryptedEvent, err := olmMachine.DecryptMegolmEvent(event)
-		if err != nil {
-			log.Errorf("Failed to decrypt message from %s in %s: %+v", event.Sender, event.RoomID, err)
+	on(mevent.EventMessage, func(event *mevent.Event) {
+		if isVerificationRequest(event) {
+			HandleInRoomVerification(event)
 		} else {
-			log.Debugf("Received encrypted event from %s in %s", event.Sender, event.RoomID)
-			if decryptedEvent.Type == mevent.EventMessage {
-				go HandleMessage(source, decryptedEvent)
-			} else if decryptedEvent.Type == mevent.EventReaction {
-				go HandleReaction(source, decryptedEvent)
-			} else if decryptedEvent.Type == mevent.EventRedaction {
-				go HandleRedaction(source, decryptedEvent)
-			}
+			goTracked(func() { HandleMessage(event) })
 		}
 	})
 
-	// Notification loop
-	go func() {
-		log.Debugf("Starting notification loop")
-		for {
-			h, m, _ := time.Now().UTC().Clock()
-			currentMinutesAfterMidnight := h*60 + m
-			usersForCurrentMinute := stateStore.GetNotifyUsersForMinutesAfterUtcForToday()[currentMinutesAfterMidnight]
-
-			for userID, roomID := range usersForCurrentMinute {
-				log.Infof("Notifying %s", userID)
-				if currentFlow, found := currentStandupFlows[userID]; !found || currentFlow.State == FlowNotStarted || currentFlow.State == Sent {
-					SendMessage(roomID, &mevent.MessageEventContent{
-						MsgType: mevent.MsgText,
-						Body:    "Time to write your standup post!",
-					})
-					currentStandupFlows[userID] = BlankStandupFlow()
-					go CreatePost(roomID, userID)
-				} else {
-					content := format.RenderMarkdown("Looks like you are already writing a standup post! If you want to start over, type `!standupbot new`", true, false)
-					SendMessage(roomID, &content)
-				}
-			}
+	for _, eventType := range []mevent.Type{
+		mevent.InRoomVerificationStart,
+		mevent.InRoomVerificationReady,
+		mevent.InRoomVerificationAccept,
+		mevent.InRoomVerificationKey,
+		mevent.InRoomVerificationMAC,
+		mevent.InRoomVerificationCancel,
+	} {
+		on(eventType, HandleInRoomVerification)
+	}
 
-			// Sleep until the next minute comes around
-			time.Sleep(time.Duration(60-time.Now().Second()) * time.Second)
-		}
-	}()
+	on(mevent.EventRedaction, func(event *mevent.Event) { goTracked(func() { HandleRedaction(event) }) })
 
-	for {
-		log.Debugf("Running sync...")
-		err = client.Sync()
-		if err != nil {
-			log.Errorf("Sync failed. %+v", err)
-		}
-	}
+	on(mevent.EventEncrypted, HandleEncrypted)
 }
 
 func FindDeviceID(db *sql.DB, accountID string) (deviceID mid.DeviceID) {
@@ -1,10 +1,13 @@
 package store
 
 import (
+	"errors"
+	"sort"
 	"strings"
 	"time"
 
-	log "github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix"
+	mevent "maunium.net/go/mautrix/event"
 	mid "maunium.net/go/mautrix/id"
 
 	"github.com/beeper/standupbot/types"
@@ -12,16 +15,69 @@ import (
 
 // Setting which room to look for as the config room for a given user.
 func (store *StateStore) SetConfigRoom(userID mid.UserID, roomID mid.RoomID) {
+	if store.UserConfigRooms[userID] != roomID {
+		store.saveUserSetting(userID, "config_room_id", roomID)
+	}
 	store.UserConfigRooms[userID] = roomID
+	store.reminderSettingsChanged(userID)
 }
 
 func (store *StateStore) GetConfigRoomId(userID mid.UserID) mid.RoomID {
 	return store.UserConfigRooms[userID]
 }
 
+// UserSettings are the cached settings of a user. Settings that are not
+// cached are left empty.
+type UserSettings struct {
+	UserID                     mid.UserID
+	ConfigRoomID               mid.RoomID
+	Timezone                   string
+	NotifyMinutesAfterMidnight *int
+	SendRoomID                 mid.RoomID
+	WeeklyRoomID               mid.RoomID
+	UseThreads                 *bool
+	Language                   string
+}
+
+// GetKnownUsers returns the cached settings of every user that has a config
+// room, sorted by user ID.
+func (store *StateStore) GetKnownUsers() []UserSettings {
+	users := make([]UserSettings, 0, len(store.UserConfigRooms))
+	for userID, configRoomID := range store.UserConfigRooms {
+		settings := UserSettings{
+			UserID:       userID,
+			ConfigRoomID: configRoomID,
+			Timezone:     store.userTimezoneCache[userID],
+			SendRoomID:   store.userSendRoomCache[userID],
+			WeeklyRoomID: store.userWeeklyRoomCache[userID],
+			Language:     store.userLanguageCache[userID],
+		}
+		if minutes, found := store.userNotifyTimeCache[userID]; found {
+			settings.NotifyMinutesAfterMidnight = &minutes
+		}
+		if useThreads, found := store.userUseThreadsCache[userID]; found {
+			settings.UseThreads = &useThreads
+		}
+		users = append(users, settings)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].UserID < users[j].UserID })
+	return users
+}
+
+// stateEvent loads a setting that is not cached from room state. Once the
+// settings of all users were loaded from room state, the settings that are not
+// cached are not set, so it returns M_NOT_FOUND without asking the homeserver.
+func (store *StateStore) stateEvent(roomID mid.RoomID, eventType mevent.Type, stateKey string, outContent interface{}) error {
+	if store.settingsCached {
+		return mautrix.MNotFound
+	}
+	return store.Client.StateEvent(roomID, eventType, stateKey, outContent)
+}
+
 // Use threads or not?
 func (store *StateStore) SetUseThreads(userID mid.UserID, useThreads bool) {
 	store.userUseThreadsCache[userID] = useThreads
+	store.saveUserSetting(userID, "use_threads", useThreads)
 }
 
 func (store *StateStore) GetUseThreads(userID mid.UserID) (bool, error) {
@@ -30,9 +86,11 @@ func (store *StateStore) GetUseThreads(userID mid.UserID) (bool, error) {
 		roomID := store.GetConfigRoomId(userID)
 		stateKey := strings.TrimPrefix(userID.String(), "@")
 		var useThreadsEventContent types.UseThreadsEventContent
-		if err := store.Client.StateEvent(roomID, types.StateUseThreads, stateKey, &useThreadsEventContent); err == nil {
+		if err := store.stateEvent(roomID, types.StateUseThreads, stateKey, &useThreadsEventContent); err == nil {
 			useThreads = useThreadsEventContent.UseThreads
 			store.userUseThreadsCache[userID] = useThreads
+		} else if store.Defaults().UseThreads {
+			return true, nil
 		} else {
 			return false, err
 		}
@@ -44,6 +102,8 @@ func (store *StateStore) GetUseThreads(userID mid.UserID) (bool, error) {
 
 func (store *StateStore) SetTimezone(userID mid.UserID, timezone string) {
 	store.userTimezoneCache[userID] = timezone
+	store.saveUserSetting(userID, "timezone", timezone)
+	store.reminderSettingsChanged(userID)
 }
 
 func (store *StateStore) GetTimezone(userID mid.UserID) *time.Location {
@@ -52,11 +112,14 @@ func (store *StateStore) GetTimezone(userID mid.UserID) *time.Location {
 		roomID := store.GetConfigRoomId(userID)
 		stateKey := strings.TrimPrefix(userID.String(), "@")
 		var tzSettingEventContent types.TzSettingEventContent
-		if err := store.Client.StateEvent(roomID, types.StateTzSetting, stateKey, &tzSettingEventContent); err == nil {
+		if err := store.stateEvent(roomID, types.StateTzSetting, stateKey, &tzSettingEventContent); err == nil {
 			timezone = tzSettingEventContent.TzString
 			store.userTimezoneCache[userID] = timezone
 		}
 	}
+	if timezone == "" {
+		timezone = store.Defaults().Timezone
+	}
 
 	if location, err := time.LoadLocation(timezone); err == nil {
 		return location
@@ -66,10 +129,20 @@ func (store *StateStore) GetTimezone(userID mid.UserID) *time.Location {
 
 func (store *StateStore) RemoveNotify(userID mid.UserID) {
 	delete(store.userNotifyTimeCache, userID)
+	store.saveUserSetting(userID, "notify_minutes", nil)
+	store.reminderSettingsChanged(userID)
 }
 
 func (store *StateStore) SetNotify(userID mid.UserID, minutesAfterMidnight int) {
 	store.userNotifyTimeCache[userID] = minutesAfterMidnight
+	store.saveUserSetting(userID, "notify_minutes", minutesAfterMidnight)
+	store.reminderSettingsChanged(userID)
+}
+
+func (store *StateStore) reminderSettingsChanged(userID mid.UserID) {
+	if store.OnReminderSettingsChanged != nil {
+		store.OnReminderSettingsChanged(userID)
+	}
 }
 
 func (store *StateStore) GetNotify(userID mid.UserID) (int, error) {
@@ -78,11 +151,14 @@ func (store *StateStore) GetNotify(userID mid.UserID) (int, error) {
 		roomID := store.GetConfigRoomId(userID)
 		stateKey := strings.TrimPrefix(userID.String(), "@")
 		var notifyEventContent types.NotifyEventContent
-		if err := store.Client.StateEvent(roomID, types.StateNotify, stateKey, &notifyEventContent); err == nil {
+		if err := store.stateEvent(roomID, types.StateNotify, stateKey, &notifyEventContent); err == nil {
 			if notifyEventContent.MinutesAfterMidnight != nil {
 				minutesAfterMidnight = *notifyEventContent.MinutesAfterMidnight
 				store.userNotifyTimeCache[userID] = minutesAfterMidnight
 			}
+		} else if defaults := store.Defaults(); errors.Is(err, mautrix.MNotFound) && defaults.NotifyMinutesAfterMidnight != 0 {
+			// The user never set a notification time, so use the default.
+			return defaults.NotifyMinutesAfterMidnight, nil
 		} else {
 			// No notify time
 			return 0, err
@@ -93,6 +169,7 @@ func (store *StateStore) GetNotify(userID mid.UserID) (int, error) {
 
 func (store *StateStore) SetSendRoomId(userID mid.UserID, sendRoomID mid.RoomID) {
 	store.userSendRoomCache[userID] = sendRoomID
+	store.saveUserSetting(userID, "send_room_id", sendRoomID)
 }
 
 func (store *StateStore) GetSendRoomId(userID mid.UserID) (mid.RoomID, error) {
@@ -101,7 +178,7 @@ func (store *StateStore) GetSendRoomId(userID mid.UserID) (mid.RoomID, error) {
 		roomID := store.GetConfigRoomId(userID)
 		stateKey := strings.TrimPrefix(userID.String(), "@")
 		var sendRoomEventContent types.SendRoomEventContent
-		if err := store.Client.StateEvent(roomID, types.StateSendRoom, stateKey, &sendRoomEventContent); err == nil {
+		if err := store.stateEvent(roomID, types.StateSendRoom, stateKey, &sendRoomEventContent); err == nil {
 			sendRoomID = sendRoomEventContent.SendRoomID
 			store.userSendRoomCache[userID] = sendRoomID
 		} else {
@@ -112,48 +189,81 @@ func (store *StateStore) GetSendRoomId(userID mid.UserID) (mid.RoomID, error) {
 	return sendRoomID, nil
 }
 
-func (store *StateStore) GetCurrentWeekdayInUserTimezone(userID mid.UserID) time.Weekday {
-	timezone, found := store.userTimezoneCache[userID]
-	if !found {
-		return time.Now().UTC().Weekday()
-	}
+func (store *StateStore) SetWeeklyRoomId(userID mid.UserID, weeklyRoomID mid.RoomID) {
+	store.userWeeklyRoomCache[userID] = weeklyRoomID
+	store.saveUserSetting(userID, "weekly_room_id", weeklyRoomID)
+}
 
-	location, err := time.LoadLocation(timezone)
-	if err != nil {
-		return time.Now().UTC().Weekday()
+// GetWeeklyRoomId returns the room that the user's weekly summaries are sent
+// to, or an empty room ID if they are sent to the send room.
+func (store *StateStore) GetWeeklyRoomId(userID mid.UserID) mid.RoomID {
+	weeklyRoomID, found := store.userWeeklyRoomCache[userID]
+	if !found {
+		roomID := store.GetConfigRoomId(userID)
+		stateKey := strings.TrimPrefix(userID.String(), "@")
+		var weeklyRoomEventContent types.WeeklyRoomEventContent
+		if err := store.stateEvent(roomID, types.StateWeeklyRoom, stateKey, &weeklyRoomEventContent); err == nil {
+			weeklyRoomID = weeklyRoomEventContent.WeeklyRoomID
+			store.userWeeklyRoomCache[userID] = weeklyRoomID
+		}
 	}
-	return time.Now().In(location).Weekday()
+	return weeklyRoomID
 }
 
-func (store *StateStore) GetNotifyUsersForMinutesAfterUtcForToday() map[int]map[mid.UserID]mid.RoomID {
-	notifyTimes := make(map[int]map[mid.UserID]mid.RoomID)
+// Language handling
 
-	for userID := range store.UserConfigRooms {
-		location := store.GetTimezone(userID)
-		now := time.Now()
-		midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+func (store *StateStore) SetLanguage(userID mid.UserID, language string) {
+	store.userLanguageCache[userID] = language
+	store.saveUserSetting(userID, "language", language)
+}
 
-		// Don't add a notification time if it's on the weekend
-		if midnight.Weekday() == time.Saturday || midnight.Weekday() == time.Sunday {
-			log.Debugf("It is the weekend in %s, not including the notification time in the dictionary.", location)
-			continue
+// GetLanguage returns the language code that the user chose, or an empty
+// string if they did not choose one.
+func (store *StateStore) GetLanguage(userID mid.UserID) string {
+	language, found := store.userLanguageCache[userID]
+	if !found {
+		roomID := store.GetConfigRoomId(userID)
+		stateKey := strings.TrimPrefix(userID.String(), "@")
+		var languageEventContent types.LanguageEventContent
+		if err := store.stateEvent(roomID, types.StateLanguage, stateKey, &languageEventContent); err == nil {
+			language = languageEventContent.Language
+			store.userLanguageCache[userID] = language
 		}
+	}
+	return language
+}
 
-		minutesAfterMidnight, err := store.GetNotify(userID)
-		if err != nil || minutesAfterMidnight == 0 {
-			continue
+func (store *StateStore) SetRoomLanguage(roomID mid.RoomID, language string) {
+	store.roomLanguageCache[roomID] = language
+	store.saveRoomLanguage(roomID, language)
+}
+
+// GetRoomLanguage returns the language code that is configured for the send
+// room, or an empty string if none is configured.
+func (store *StateStore) GetRoomLanguage(roomID mid.RoomID) string {
+	language, found := store.roomLanguageCache[roomID]
+	if !found {
+		var languageEventContent types.LanguageEventContent
+		if err := store.stateEvent(roomID, types.StateRoomLanguage, "", &languageEventContent); err == nil {
+			language = languageEventContent.Language
+			store.roomLanguageCache[roomID] = language
 		}
-		notifyTime := midnight.Add(time.Duration(minutesAfterMidnight) * time.Minute)
+	}
+	return language
+}
 
-		h, m, _ := notifyTime.UTC().Clock()
-		minutesAfterUtcMidnight := h*60 + m
+// Now returns the current time. It can be replaced in tests.
+var Now = time.Now
 
-		if _, exists := notifyTimes[minutesAfterUtcMidnight]; !exists {
-			notifyTimes[minutesAfterUtcMidnight] = make(map[mid.UserID]mid.RoomID)
-		}
-		roomID := store.UserConfigRooms[userID]
-		notifyTimes[minutesAfterUtcMidnight][userID] = roomID
+func (store *StateStore) GetCurrentWeekdayInUserTimezone(userID mid.UserID) time.Weekday {
+	timezone, found := store.userTimezoneCache[userID]
+	if !found || timezone == "" {
+		timezone = store.Defaults().Timezone
 	}
 
-	return notifyTimes
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return Now().UTC().Weekday()
+	}
+	return Now().In(location).Weekday()
 }
@@ -19,7 +19,7 @@ func (store *StateStore) IsEncrypted(roomID mid.RoomID) bool {
 }
 
 func (store *StateStore) GetEncryptionEvent(roomId mid.RoomID) *mevent.EncryptionEventContent {
-	row := store.DB.QueryRow("SELECT encryption_event FROM rooms WHERE room_id = ?", roomId)
+	row := store.DB.QueryRow("SELECT encryption_event FROM rooms WHERE room_id = $1", roomId)
 
 	var encryptionEventJson []byte
 	if err := row.Scan(&encryptionEventJson); err != nil {
@@ -37,7 +37,7 @@ func (store *StateStore) GetEncryptionEvent(roomId mid.RoomID) *mevent.Encryptio
 }
 
 func (store *StateStore) FindSharedRooms(userId mid.UserID) []mid.RoomID {
-	rows, err := store.DB.Query("SELECT room_id FROM room_members WHERE user_id = ?", userId)
+	rows, err := store.DB.Query("SELECT room_id FROM room_members WHERE user_id = $1", userId)
 	rooms := make([]mid.RoomID, 0)
 	if err != nil {
 		return rooms
@@ -62,12 +62,12 @@ func (store *StateStore) SetMembership(event *mevent.Event) {
 	}
 	membershipEvent := event.Content.AsMember()
 	if membershipEvent.Membership.IsInviteOrJoin() {
-		insert := "INSERT OR IGNORE INTO room_members VALUES (?, ?)"
+		insert := "INSERT INTO room_members VALUES ($1, $2) ON CONFLICT (room_id, user_id) DO NOTHING"
 		if _, err := tx.Exec(insert, event.RoomID, event.GetStateKey()); err != nil {
 			log.Errorf("Failed to insert membership row for %s in %s", event.GetStateKey(), event.RoomID)
 		}
 	} else {
-		del := "DELETE FROM room_members WHERE room_id = ? AND user_id = ?"
+		del := "DELETE FROM room_members WHERE room_id = $1 AND user_id = $2"
 		if _, err := tx.Exec(del, event.RoomID, event.GetStateKey()); err != nil {
 			log.Errorf("Failed to delete membership row for %s in %s", event.GetStateKey(), event.RoomID)
 		}
@@ -76,47 +76,22 @@ func (store *StateStore) SetMembership(event *mevent.Event) {
 }
 
 func (store *StateStore) upsertEncryptionEvent(roomId mid.RoomID, encryptionEvent *mevent.Event) error {
-	tx, err := store.DB.Begin()
-	if err != nil {
-		tx.Rollback()
-		return nil
-	}
-
-	update := "UPDATE rooms SET encryption_event = ? WHERE room_id = ?"
-	var encryptionEventJson []byte
-	if encryptionEvent == nil {
-		encryptionEventJson = nil
-	}
-	encryptionEventJson, err = json.Marshal(encryptionEvent)
-	if err != nil {
-		encryptionEventJson = nil
-	}
-
-	if _, err := tx.Exec(update, encryptionEventJson, roomId); err != nil {
-		tx.Rollback()
-		return err
+	var encryptionEventJson interface{}
+	if content, err := json.Marshal(encryptionEvent); err == nil {
+		encryptionEventJson = string(content)
 	}
 
-	insert := "INSERT OR IGNORE INTO rooms VALUES (?, ?)"
-	if _, err := tx.Exec(insert, roomId, encryptionEventJson); err != nil {
-		tx.Rollback()
-		return err
-	}
-
-	return tx.Commit()
+	upsert := `
+		INSERT INTO rooms (room_id, encryption_event) VALUES ($1, $2)
+		ON CONFLICT (room_id) DO UPDATE SET encryption_event = excluded.encryption_event
+	`
+	_, err := store.DB.Exec(upsert, roomId, encryptionEventJson)
+	return err
 }
 
 func (store *StateStore) SetEncryptionEvent(event *mevent.Event) {
 	log.Debugf("Updating encryption_event for %s", event.RoomID)
-	tx, err := store.DB.Begin()
-	if err != nil {
-		tx.Rollback()
-		return
-	}
-	err = store.upsertEncryptionEvent(event.RoomID, event)
-	if err != nil {
+	if err := store.upsertEncryptionEvent(event.RoomID, event); err != nil {
 		log.Errorf("Upsert encryption event failed %s", err)
 	}
-
-	tx.Commit()
 }
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Dialect is the SQL dialect of the database. It is also the name of the
+// database/sql driver. Queries use $1-style placeholders and ON CONFLICT
+// upserts, which work in both dialects, so only the queries that inspect the
+// database itself depend on the dialect.
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite3"
+	Postgres Dialect = "postgres"
+)
+
+// ParseDatabaseURI returns the dialect of the database with the given URI and
+// the data source name to open it with. SQLite databases use sqlite:// URIs,
+// like sqlite:///data/standupbot.db, and Postgres databases use postgres://
+// URIs.
+func ParseDatabaseURI(uri string) (Dialect, string, error) {
+	switch {
+	case strings.HasPrefix(uri, "postgres://"), strings.HasPrefix(uri, "postgresql://"):
+		return Postgres, uri, nil
+	case strings.HasPrefix(uri, "sqlite://"):
+		return SQLite, strings.TrimPrefix(uri, "sqlite://"), nil
+	case strings.HasPrefix(uri, "sqlite3://"):
+		return SQLite, strings.TrimPrefix(uri, "sqlite3://"), nil
+	}
+	return "", "", fmt.Errorf("unsupported database URI %q. Use a sqlite:// or postgres:// URI", uri)
+}
+
+// Open opens the database with the given URI.
+func Open(uri string) (*sql.DB, Dialect, error) {
+	dialect, dataSourceName, err := ParseDatabaseURI(uri)
+	if err != nil {
+		return nil, "", err
+	}
+	db, err := sql.Open(string(dialect), dataSourceName)
+	if err != nil {
+		return nil, "", err
+	}
+	return db, dialect, nil
+}
+
+// TableExists returns whether the table exists in the database.
+func (dialect Dialect) TableExists(db *sql.DB, table string) (bool, error) {
+	query := "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = $1"
+	if dialect == Postgres {
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1"
+	}
+	var count int
+	if err := db.QueryRow(query, table).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// DatabaseSize returns the size of the database in bytes.
+func (dialect Dialect) DatabaseSize(db *sql.DB) (int64, error) {
+	var size int64
+	if dialect == Postgres {
+		err := db.QueryRow("SELECT pg_database_size(current_database())").Scan(&size)
+		return size, err
+	}
+
+	var pageCount, pageSize int64
+	if err := db.QueryRow("PRAGMA page_count").Scan(&pageCount); err != nil {
+		return 0, err
+	}
+	if err := db.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	return pageCount * pageSize, nil
+}
@@ -0,0 +1,221 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A migration upgrades the database schema by one version.
+type migration struct {
+	description string
+	upgrade     func(tx *sql.Tx) error
+}
+
+// execQueries returns a migration function that runs the given queries.
+func execQueries(queries ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, query := range queries {
+			if _, err := tx.Exec(query); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// migrations are the schema migrations in order. Running migrations[i]
+// upgrades the database from version i to version i+1. Never change a
+// migration that has been released. Add a new one to the end instead.
+var migrations = []migration{
+	{
+		// Databases from before the schema was versioned already have these
+		// tables, so the queries must work if they exist.
+		description: "create the initial tables",
+		upgrade: execQueries(
+			`
+			CREATE TABLE IF NOT EXISTS user_filter_ids (
+				user_id    VARCHAR(255) PRIMARY KEY,
+				filter_id  VARCHAR(255)
+			)
+			`,
+			`
+			CREATE TABLE IF NOT EXISTS user_batch_tokens (
+				user_id           VARCHAR(255) PRIMARY KEY,
+				next_batch_token  VARCHAR(255)
+			)
+			`,
+			`
+			CREATE TABLE IF NOT EXISTS user_sessions (
+				user_id       VARCHAR(255) PRIMARY KEY,
+				device_id     VARCHAR(255),
+				access_token  VARCHAR(255)
+			)
+			`,
+			`
+			CREATE TABLE IF NOT EXISTS undecryptable_events (
+				event_id    VARCHAR(255) PRIMARY KEY,
+				room_id     VARCHAR(255),
+				sender_key  VARCHAR(255),
+				session_id  VARCHAR(255),
+				event       TEXT,
+				queued_at   BIGINT
+			)
+			`,
+			`
+			CREATE TABLE IF NOT EXISTS rooms (
+				room_id           VARCHAR(255) PRIMARY KEY,
+				encryption_event  VARCHAR(65535) NULL
+			)
+			`,
+			`
+			CREATE TABLE IF NOT EXISTS room_members (
+				room_id  VARCHAR(255),
+				user_id  VARCHAR(255),
+				PRIMARY KEY (room_id, user_id)
+			)
+			`,
+			`
+			DROP TABLE IF EXISTS standupbot_meta;
+			`,
+			`
+			DROP TABLE IF EXISTS user_config_room
+			`,
+		),
+	},
+	{
+		description: "add the outbox for messages that have not been sent yet",
+		upgrade: execQueries(`
+			CREATE TABLE outbox (
+				transaction_id  VARCHAR(255) PRIMARY KEY,
+				room_id         VARCHAR(255) NOT NULL,
+				on_behalf_of    VARCHAR(255) NOT NULL,
+				content         TEXT NOT NULL,
+				queued_at       BIGINT NOT NULL
+			)
+		`),
+	},
+	{
+		description: "add the last minute that reminders were sent for",
+		upgrade: execQueries(`
+			CREATE TABLE reminder_progress (
+				id      INTEGER PRIMARY KEY CHECK (id = 1),
+				minute  BIGINT NOT NULL
+			)
+		`),
+	},
+	{
+		description: "add the settings cache",
+		upgrade: execQueries(
+			`
+			CREATE TABLE user_settings (
+				user_id         VARCHAR(255) PRIMARY KEY,
+				config_room_id  VARCHAR(255) NULL,
+				timezone        VARCHAR(255) NULL,
+				notify_minutes  INTEGER NULL,
+				send_room_id    VARCHAR(255) NULL,
+				use_threads     BOOLEAN NULL,
+				language        VARCHAR(255) NULL
+			)
+			`,
+			`
+			CREATE TABLE room_settings (
+				room_id   VARCHAR(255) PRIMARY KEY,
+				language  VARCHAR(255) NOT NULL
+			)
+			`,
+		),
+	},
+	{
+		description: "add the post history and the weekly summary room",
+		upgrade: execQueries(
+			`
+			CREATE TABLE posts (
+				user_id  VARCHAR(255) NOT NULL,
+				flow_id  VARCHAR(255) NOT NULL,
+				sent_at  BIGINT NOT NULL,
+				content  TEXT NOT NULL,
+				PRIMARY KEY (user_id, flow_id)
+			)
+			`,
+			`
+			ALTER TABLE user_settings ADD COLUMN weekly_room_id VARCHAR(255) NULL
+			`,
+		),
+	},
+	{
+		description: "record whether the settings were loaded from room state",
+		upgrade: execQueries(`
+			CREATE TABLE settings_progress (
+				id                 INTEGER PRIMARY KEY CHECK (id = 1),
+				loaded_from_state  BOOLEAN NOT NULL
+			)
+		`),
+	},
+}
+
+// LatestSchemaVersion is the schema version that this version of the bot
+// upgrades the database to.
+var LatestSchemaVersion = len(migrations)
+
+// SchemaVersion returns the schema version of the database, or 0 if the
+// schema has not been created yet.
+func (store *StateStore) SchemaVersion() (int, error) {
+	if exists, err := store.Dialect.TableExists(store.DB, "standupbot_version"); err != nil || !exists {
+		return 0, err
+	}
+	return getSchemaVersion(store.DB.QueryRow("SELECT version FROM standupbot_version"))
+}
+
+func getSchemaVersion(row *sql.Row) (int, error) {
+	var version int
+	if err := row.Scan(&version); err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	return version, nil
+}
+
+// Upgrade runs the migrations that have not been run on the database yet in a
+// single transaction. It refuses to touch databases that were upgraded by a
+// newer version of the bot.
+func (store *StateStore) Upgrade() error {
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("CREATE TABLE IF NOT EXISTS standupbot_version (version INTEGER NOT NULL)"); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	version, err := getSchemaVersion(tx.QueryRow("SELECT version FROM standupbot_version"))
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if version > LatestSchemaVersion {
+		_ = tx.Rollback()
+		return fmt.Errorf("the database has schema version %d, but this version of standupbot only supports up to version %d. Upgrade standupbot", version, LatestSchemaVersion)
+	} else if version == LatestSchemaVersion {
+		return tx.Rollback()
+	}
+
+	for ; version < LatestSchemaVersion; version++ {
+		log.Infof("Upgrading the database schema to version %d: %s", version+1, migrations[version].description)
+		if err := migrations[version].upgrade(tx); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to upgrade the database schema to version %d: %w", version+1, err)
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM standupbot_version"); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO standupbot_version (version) VALUES ($1)", version); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,54 @@
+package store
+
+import "testing"
+
+func TestUpgrade(t *testing.T) {
+	testDialects(t, func(t *testing.T, store *StateStore) {
+		if version, err := store.SchemaVersion(); err != nil || version != 0 {
+			t.Fatalf("Expected a new database to have version 0, got %d (%v)", version, err)
+		}
+
+		for i := 0; i < 2; i++ {
+			if err := store.Upgrade(); err != nil {
+				t.Fatal(err)
+			}
+			if version, err := store.SchemaVersion(); err != nil || version != LatestSchemaVersion {
+				t.Fatalf("Expected version %d, got %d (%v)", LatestSchemaVersion, version, err)
+			}
+		}
+		if _, err := store.DB.Exec("INSERT INTO user_sessions VALUES ('@bot:example.com', 'DEVICE', 'token')"); err != nil {
+			t.Errorf("Expected the tables to be created: %v", err)
+		}
+	})
+}
+
+func TestUpgradeUnversionedDatabase(t *testing.T) {
+	testDialects(t, func(t *testing.T, store *StateStore) {
+		if _, err := store.DB.Exec("CREATE TABLE user_sessions (user_id VARCHAR(255) PRIMARY KEY, device_id VARCHAR(255), access_token VARCHAR(255))"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.DB.Exec("INSERT INTO user_sessions VALUES ('@bot:example.com', 'DEVICE', 'token')"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Upgrade(); err != nil {
+			t.Fatal(err)
+		}
+		if deviceID, _ := store.LoadSession("@bot:example.com"); deviceID != "DEVICE" {
+			t.Errorf("Expected the existing session to be kept, got %q", deviceID)
+		}
+	})
+}
+
+func TestUpgradeRefusesNewerDatabase(t *testing.T) {
+	testDialects(t, func(t *testing.T, store *StateStore) {
+		if err := store.Upgrade(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.DB.Exec("UPDATE standupbot_version SET version = $1", LatestSchemaVersion+1); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Upgrade(); err == nil {
+			t.Errorf("Expected upgrading a newer database to fail")
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mid "maunium.net/go/mautrix/id"
+)
+
+// OutgoingMessage is a message that is waiting to be sent to a room.
+type OutgoingMessage struct {
+	TransactionID string
+	RoomID        mid.RoomID
+	// The user that the message is sent on behalf of, if any.
+	OnBehalfOf *mid.UserID
+	Content    json.RawMessage
+	QueuedAt   time.Time
+}
+
+// QueueOutgoingMessage stores a message so that it is still sent if the bot
+// restarts before sending it.
+func (store *StateStore) QueueOutgoingMessage(message OutgoingMessage) error {
+	onBehalfOf := ""
+	if message.OnBehalfOf != nil {
+		onBehalfOf = message.OnBehalfOf.String()
+	}
+	insert := "INSERT INTO outbox VALUES ($1, $2, $3, $4, $5) ON CONFLICT (transaction_id) DO NOTHING"
+	_, err := store.DB.Exec(insert, message.TransactionID, message.RoomID, onBehalfOf, string(message.Content), message.QueuedAt.UnixNano())
+	return err
+}
+
+// GetOutgoingMessages returns the messages that have not been sent yet in the
+// order that they were queued in.
+func (store *StateStore) GetOutgoingMessages() []OutgoingMessage {
+	rows, err := store.DB.Query("SELECT transaction_id, room_id, on_behalf_of, content, queued_at FROM outbox ORDER BY queued_at, transaction_id")
+	messages := make([]OutgoingMessage, 0)
+	if err != nil {
+		log.Errorf("Failed to query outgoing messages: %+v", err)
+		return messages
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var message OutgoingMessage
+		var onBehalfOf, content string
+		var queuedAt int64
+		if err := rows.Scan(&message.TransactionID, &message.RoomID, &onBehalfOf, &content, &queuedAt); err != nil {
+			log.Errorf("Failed to scan outgoing message: %+v", err)
+			continue
+		}
+		if onBehalfOf != "" {
+			user := mid.UserID(onBehalfOf)
+			message.OnBehalfOf = &user
+		}
+		message.Content = json.RawMessage(content)
+		message.QueuedAt = time.Unix(0, queuedAt)
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+func (store *StateStore) RemoveOutgoingMessage(transactionID string) {
+	if _, err := store.DB.Exec("DELETE FROM outbox WHERE transaction_id = $1", transactionID); err != nil {
+		log.Errorf("Failed to remove outgoing message %s: %+v", transactionID, err)
+	}
+}
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+
+	mid "maunium.net/go/mautrix/id"
+)
+
+// Post is a standup post that a user sent, kept so that it can be included in
+// their weekly summary.
+type Post struct {
+	UserID mid.UserID
+	FlowID string
+	SentAt time.Time
+	// The sections of the post.
+	Content json.RawMessage
+}
+
+// SavePost adds the post to the post history. If the post was edited, the
+// content is replaced, but it keeps the time that it was first sent at.
+func (store *StateStore) SavePost(post Post) error {
+	upsert := `
+		INSERT INTO posts (user_id, flow_id, sent_at, content) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, flow_id) DO UPDATE SET content = excluded.content
+	`
+	_, err := store.DB.Exec(upsert, post.UserID, post.FlowID, post.SentAt.Unix(), string(post.Content))
+	return err
+}
+
+// GetPosts returns the posts that the user sent at or after the given time, in
+// the order that they were sent in.
+func (store *StateStore) GetPosts(userID mid.UserID, since time.Time) ([]Post, error) {
+	return store.queryPosts("SELECT user_id, flow_id, sent_at, content FROM posts WHERE user_id = $1 AND sent_at >= $2 ORDER BY sent_at, flow_id", userID, since.Unix())
+}
+
+// GetAllPosts returns the post history of all users.
+func (store *StateStore) GetAllPosts() ([]Post, error) {
+	return store.queryPosts("SELECT user_id, flow_id, sent_at, content FROM posts ORDER BY user_id, sent_at, flow_id")
+}
+
+func (store *StateStore) queryPosts(query string, args ...interface{}) ([]Post, error) {
+	rows, err := store.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := make([]Post, 0)
+	for rows.Next() {
+		var post Post
+		var sentAt int64
+		var content string
+		if err := rows.Scan(&post.UserID, &post.FlowID, &sentAt, &content); err != nil {
+			return nil, err
+		}
+		post.SentAt = time.Unix(sentAt, 0)
+		post.Content = json.RawMessage(content)
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+// RemovePost removes the post from the post history, for example after the
+// user undid sending it.
+func (store *StateStore) RemovePost(userID mid.UserID, flowID string) error {
+	_, err := store.DB.Exec("DELETE FROM posts WHERE user_id = $1 AND flow_id = $2", userID, flowID)
+	return err
+}
+
+// RemovePostsBefore removes the posts that were sent before the given time
+// from the post history.
+func (store *StateStore) RemovePostsBefore(before time.Time) error {
+	_, err := store.DB.Exec("DELETE FROM posts WHERE sent_at < $1", before.Unix())
+	return err
+}
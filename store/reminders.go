@@ -0,0 +1,29 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// GetLastReminderMinute returns the last minute that the reminders were sent
+// for, if any.
+func (store *StateStore) GetLastReminderMinute() (time.Time, bool, error) {
+	var minute int64
+	err := store.DB.QueryRow("SELECT minute FROM reminder_progress WHERE id = 1").Scan(&minute)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	} else if err != nil {
+		return time.Time{}, false, err
+	}
+	return time.Unix(minute*60, 0).UTC(), true, nil
+}
+
+// SetLastReminderMinute records that the reminders for the minute were sent.
+func (store *StateStore) SetLastReminderMinute(minute time.Time) error {
+	upsert := `
+		INSERT INTO reminder_progress (id, minute) VALUES (1, $1)
+		ON CONFLICT (id) DO UPDATE SET minute = excluded.minute
+	`
+	_, err := store.DB.Exec(upsert, minute.Unix()/60)
+	return err
+}
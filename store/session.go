@@ -0,0 +1,33 @@
+package store
+
+import (
+	log "github.com/sirupsen/logrus"
+	mid "maunium.net/go/mautrix/id"
+)
+
+// SaveSession stores the device ID and access token that the bot is logged in
+// with so that logins which cannot be repeated non-interactively (such as SSO
+// logins) survive restarts.
+func (store *StateStore) SaveSession(userID mid.UserID, deviceID mid.DeviceID, accessToken string) error {
+	log.Debug("Upserting row into user_sessions")
+	upsert := `
+		INSERT INTO user_sessions (user_id, device_id, access_token) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET device_id = excluded.device_id, access_token = excluded.access_token
+	`
+	_, err := store.DB.Exec(upsert, userID, deviceID, accessToken)
+	return err
+}
+
+func (store *StateStore) LoadSession(userID mid.UserID) (deviceID mid.DeviceID, accessToken string) {
+	row := store.DB.QueryRow("SELECT device_id, access_token FROM user_sessions WHERE user_id = $1", userID)
+	if err := row.Scan(&deviceID, &accessToken); err != nil {
+		return "", ""
+	}
+	return
+}
+
+func (store *StateStore) DeleteSession(userID mid.UserID) {
+	if _, err := store.DB.Exec("DELETE FROM user_sessions WHERE user_id = $1", userID); err != nil {
+		log.Errorf("Failed to delete session for %s: %+v", userID, err)
+	}
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	mid "maunium.net/go/mautrix/id"
+)
+
+// saveUserSetting stores one of the user's settings in the settings cache in
+// the database, so that it does not have to be loaded from room state after a
+// restart. A nil value means that the setting is not set.
+func (store *StateStore) saveUserSetting(userID mid.UserID, column string, value interface{}) {
+	upsert := fmt.Sprintf(`
+		INSERT INTO user_settings (user_id, %[1]s) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET %[1]s = excluded.%[1]s
+	`, column)
+	if _, err := store.DB.Exec(upsert, userID, value); err != nil {
+		log.Errorf("Failed to save the %s setting of %s: %+v", column, userID, err)
+	}
+}
+
+func (store *StateStore) saveRoomLanguage(roomID mid.RoomID, language string) {
+	upsert := `
+		INSERT INTO room_settings (room_id, language) VALUES ($1, $2)
+		ON CONFLICT (room_id) DO UPDATE SET language = excluded.language
+	`
+	if _, err := store.DB.Exec(upsert, roomID, language); err != nil {
+		log.Errorf("Failed to save the language of %s: %+v", roomID, err)
+	}
+}
+
+// SettingsLoadedFromState returns whether the settings of all users were
+// loaded from room state. If they were, the settings that are not cached are
+// not loaded from room state anymore.
+func (store *StateStore) SettingsLoadedFromState() (bool, error) {
+	var loaded bool
+	err := store.DB.QueryRow("SELECT loaded_from_state FROM settings_progress WHERE id = 1").Scan(&loaded)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	store.settingsCached = loaded
+	return loaded, nil
+}
+
+// SetSettingsLoadedFromState records that the settings of all users were
+// loaded from room state.
+func (store *StateStore) SetSettingsLoadedFromState() error {
+	upsert := `
+		INSERT INTO settings_progress (id, loaded_from_state) VALUES (1, $1)
+		ON CONFLICT (id) DO UPDATE SET loaded_from_state = excluded.loaded_from_state
+	`
+	if _, err := store.DB.Exec(upsert, true); err != nil {
+		return err
+	}
+	store.settingsCached = true
+	return nil
+}
+
+// LoadSettings fills the settings cache from the database and returns the
+// number of users whose config room is known.
+func (store *StateStore) LoadSettings() (int, error) {
+	rows, err := store.DB.Query("SELECT user_id, config_room_id, timezone, notify_minutes, send_room_id, use_threads, language, weekly_room_id FROM user_settings")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	users := 0
+	for rows.Next() {
+		var userID mid.UserID
+		var configRoomID, timezone, sendRoomID, language, weeklyRoomID sql.NullString
+		var notifyMinutes sql.NullInt64
+		var useThreads sql.NullBool
+		if err := rows.Scan(&userID, &configRoomID, &timezone, &notifyMinutes, &sendRoomID, &useThreads, &language, &weeklyRoomID); err != nil {
+			return users, err
+		}
+		if configRoomID.Valid {
+			store.UserConfigRooms[userID] = mid.RoomID(configRoomID.String)
+			users++
+		}
+		if timezone.Valid {
+			store.userTimezoneCache[userID] = timezone.String
+		}
+		if notifyMinutes.Valid {
+			store.userNotifyTimeCache[userID] = int(notifyMinutes.Int64)
+		}
+		if sendRoomID.Valid {
+			store.userSendRoomCache[userID] = mid.RoomID(sendRoomID.String)
+		}
+		if useThreads.Valid {
+			store.userUseThreadsCache[userID] = useThreads.Bool
+		}
+		if language.Valid {
+			store.userLanguageCache[userID] = language.String
+		}
+		if weeklyRoomID.Valid {
+			store.userWeeklyRoomCache[userID] = mid.RoomID(weeklyRoomID.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return users, err
+	}
+
+	roomRows, err := store.DB.Query("SELECT room_id, language FROM room_settings")
+	if err != nil {
+		return users, err
+	}
+	defer roomRows.Close()
+	for roomRows.Next() {
+		var roomID mid.RoomID
+		var language string
+		if err := roomRows.Scan(&roomID, &language); err != nil {
+			return users, err
+		}
+		store.roomLanguageCache[roomID] = language
+	}
+	return users, roomRows.Err()
+}
@@ -2,87 +2,83 @@ package store
 
 import (
 	"database/sql"
+	"sync"
 
-	"maunium.net/go/mautrix"
+	mevent "maunium.net/go/mautrix/event"
 	mid "maunium.net/go/mautrix/id"
 )
 
+// StateEventClient is the part of the Matrix client that the store uses to
+// load settings that are not cached yet from room state.
+type StateEventClient interface {
+	StateEvent(roomID mid.RoomID, eventType mevent.Type, stateKey string, outContent interface{}) error
+}
+
+// UserDefaults are the settings of users who have not chosen their own.
+type UserDefaults struct {
+	Timezone string
+	// 0 means that users are not reminded.
+	NotifyMinutesAfterMidnight int
+	UseThreads                 bool
+}
+
 type StateStore struct {
 	DB              *sql.DB
-	Client          *mautrix.Client
+	Dialect         Dialect
+	Client          StateEventClient
 	UserConfigRooms map[mid.UserID]mid.RoomID
 
+	// The defaults can be replaced while the settings are read, when the
+	// configuration is reloaded.
+	defaultsLock sync.RWMutex
+	defaults     UserDefaults
+
+	// Whether the settings cache has the settings of every user because they
+	// were loaded from room state, so that settings that are not cached are
+	// not set.
+	settingsCached bool
+
+	// Called when a setting that affects when a user is reminded changes.
+	OnReminderSettingsChanged func(userID mid.UserID)
+
 	// Caches for configuration.
 	// If these become too large, we can make these LRU caches, but for
 	// now, they are small enough they don't matter.
 	userTimezoneCache   map[mid.UserID]string
 	userNotifyTimeCache map[mid.UserID]int
 	userSendRoomCache   map[mid.UserID]mid.RoomID
+	userWeeklyRoomCache map[mid.UserID]mid.RoomID
 	userUseThreadsCache map[mid.UserID]bool
+	userLanguageCache   map[mid.UserID]string
+	roomLanguageCache   map[mid.RoomID]string
 }
 
-func NewStateStore(db *sql.DB) *StateStore {
+func NewStateStore(db *sql.DB, dialect Dialect) *StateStore {
 	return &StateStore{
 		DB:              db,
+		Dialect:         dialect,
 		UserConfigRooms: map[mid.UserID]mid.RoomID{},
 
 		userTimezoneCache:   map[mid.UserID]string{},
 		userNotifyTimeCache: map[mid.UserID]int{},
 		userSendRoomCache:   map[mid.UserID]mid.RoomID{},
+		userWeeklyRoomCache: map[mid.UserID]mid.RoomID{},
 		userUseThreadsCache: map[mid.UserID]bool{},
+		userLanguageCache:   map[mid.UserID]string{},
+		roomLanguageCache:   map[mid.RoomID]string{},
 	}
 }
 
-func (store *StateStore) CreateTables() error {
-	tx, err := store.DB.Begin()
-	if err != nil {
-		return err
-	}
-
-	queries := []string{
-		`
-		CREATE TABLE IF NOT EXISTS user_filter_ids (
-			user_id    VARCHAR(255) PRIMARY KEY,
-			filter_id  VARCHAR(255)
-		)
-		`,
-		`
-		CREATE TABLE IF NOT EXISTS user_batch_tokens (
-			user_id           VARCHAR(255) PRIMARY KEY,
-			next_batch_token  VARCHAR(255)
-		)
-		`,
-		`
-		CREATE TABLE IF NOT EXISTS rooms (
-			room_id           VARCHAR(255) PRIMARY KEY,
-			encryption_event  VARCHAR(65535) NULL
-		)
-		`,
-		`
-		CREATE TABLE IF NOT EXISTS room_members (
-			room_id  VARCHAR(255),
-			user_id  VARCHAR(255),
-			PRIMARY KEY (room_id, user_id)
-		)
-		`,
-		`
-		DROP TABLE IF EXISTS standupbot_meta;
-		`,
-		`
-		DROP TABLE IF EXISTS user_config_room
-		`,
-	}
-
-	for _, query := range queries {
-		if _, err := tx.Exec(query); err != nil {
-			_ = tx.Rollback()
-			return err
-		}
-	}
-
-	if err = tx.Commit(); err != nil {
-		return err
-	}
+// Defaults returns the settings of users who have not chosen their own.
+func (store *StateStore) Defaults() UserDefaults {
+	store.defaultsLock.RLock()
+	defer store.defaultsLock.RUnlock()
+	return store.defaults
+}
 
-	return nil
+// SetDefaults replaces the settings of users who have not chosen their own.
+func (store *StateStore) SetDefaults(defaults UserDefaults) {
+	store.defaultsLock.Lock()
+	defer store.defaultsLock.Unlock()
+	store.defaults = defaults
 }
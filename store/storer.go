@@ -12,29 +12,17 @@ import (
 
 func (store *StateStore) SaveFilterID(userID mid.UserID, filterID string) {
 	log.Debug("Upserting row into user_filter_ids")
-	tx, err := store.DB.Begin()
-	if err != nil {
-		tx.Rollback()
-		return
-	}
-
-	update := "UPDATE user_filter_ids SET filter_id = ? WHERE user_id = ?"
-	if _, err := tx.Exec(update, filterID, userID); err != nil {
-		tx.Rollback()
-		return
+	upsert := `
+		INSERT INTO user_filter_ids (user_id, filter_id) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET filter_id = excluded.filter_id
+	`
+	if _, err := store.DB.Exec(upsert, userID, filterID); err != nil {
+		log.Errorf("Failed to upsert row into user_filter_ids: %+v", err)
 	}
-
-	insert := "INSERT OR IGNORE INTO user_filter_ids VALUES (?, ?)"
-	if _, err := tx.Exec(insert, userID, filterID); err != nil {
-		tx.Rollback()
-		return
-	}
-
-	tx.Commit()
 }
 
 func (store *StateStore) LoadFilterID(userID mid.UserID) string {
-	row := store.DB.QueryRow("SELECT filter_id FROM user_filter_ids WHERE user_id = ?", userID)
+	row := store.DB.QueryRow("SELECT filter_id FROM user_filter_ids WHERE user_id = $1", userID)
 	var filterID string
 	if err := row.Scan(&filterID); err != nil {
 		return ""
@@ -44,29 +32,17 @@ func (store *StateStore) LoadFilterID(userID mid.UserID) string {
 
 func (store *StateStore) SaveNextBatch(userID mid.UserID, nextBatchToken string) {
 	log.Debug("Upserting row into user_batch_tokens")
-	tx, err := store.DB.Begin()
-	if err != nil {
-		tx.Rollback()
-		return
-	}
-
-	update := "UPDATE user_batch_tokens SET next_batch_token = ? WHERE user_id = ?"
-	if _, err := tx.Exec(update, nextBatchToken, userID); err != nil {
-		tx.Rollback()
-		return
+	upsert := `
+		INSERT INTO user_batch_tokens (user_id, next_batch_token) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET next_batch_token = excluded.next_batch_token
+	`
+	if _, err := store.DB.Exec(upsert, userID, nextBatchToken); err != nil {
+		log.Errorf("Failed to upsert row into user_batch_tokens: %+v", err)
 	}
-
-	insert := "INSERT OR IGNORE INTO user_batch_tokens VALUES (?, ?)"
-	if _, err := tx.Exec(insert, userID, nextBatchToken); err != nil {
-		tx.Rollback()
-		return
-	}
-
-	tx.Commit()
 }
 
 func (store *StateStore) LoadNextBatch(userID mid.UserID) string {
-	row := store.DB.QueryRow("SELECT next_batch_token FROM user_batch_tokens WHERE user_id = ?", userID)
+	row := store.DB.QueryRow("SELECT next_batch_token FROM user_batch_tokens WHERE user_id = $1", userID)
 	var batchToken string
 	if err := row.Scan(&batchToken); err != nil {
 		return ""
@@ -75,7 +51,7 @@ func (store *StateStore) LoadNextBatch(userID mid.UserID) string {
 }
 
 func (store *StateStore) GetRoomMembers(roomId mid.RoomID) []mid.UserID {
-	rows, err := store.DB.Query("SELECT user_id FROM room_members WHERE room_id = ?", roomId)
+	rows, err := store.DB.Query("SELECT user_id FROM room_members WHERE room_id = $1", roomId)
 	users := make([]mid.UserID, 0)
 	if err != nil {
 		return users
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+)
+
+// UndecryptableEvent is an encrypted event that is waiting for the Megolm
+// session needed to decrypt it.
+type UndecryptableEvent struct {
+	Event     *mevent.Event
+	SenderKey mid.SenderKey
+	SessionID mid.SessionID
+	QueuedAt  time.Time
+}
+
+// QueueUndecryptableEvent stores an encrypted event so that decrypting it can
+// be retried once the keys for it arrive.
+func (store *StateStore) QueueUndecryptableEvent(event *mevent.Event) error {
+	content := event.Content.AsEncrypted()
+	eventJson, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	insert := "INSERT INTO undecryptable_events VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (event_id) DO NOTHING"
+	_, err = store.DB.Exec(insert, event.ID, event.RoomID, content.SenderKey, content.SessionID, string(eventJson), time.Now().Unix())
+	return err
+}
+
+func (store *StateStore) GetUndecryptableEvents() []UndecryptableEvent {
+	rows, err := store.DB.Query("SELECT sender_key, session_id, event, queued_at FROM undecryptable_events")
+	undecryptableEvents := make([]UndecryptableEvent, 0)
+	if err != nil {
+		log.Errorf("Failed to query undecryptable events: %+v", err)
+		return undecryptableEvents
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var undecryptable UndecryptableEvent
+		var eventJson string
+		var queuedAt int64
+		if err := rows.Scan(&undecryptable.SenderKey, &undecryptable.SessionID, &eventJson, &queuedAt); err != nil {
+			log.Errorf("Failed to scan undecryptable event: %+v", err)
+			continue
+		}
+		var event mevent.Event
+		if err := json.Unmarshal([]byte(eventJson), &event); err != nil {
+			log.Errorf("Failed to unmarshal undecryptable event: %+v", err)
+			continue
+		}
+		event.Type.Class = mevent.MessageEventType
+		if err := event.Content.ParseRaw(event.Type); err != nil {
+			log.Errorf("Failed to parse undecryptable event %s: %+v", event.ID, err)
+			continue
+		}
+		undecryptable.Event = &event
+		undecryptable.QueuedAt = time.Unix(queuedAt, 0)
+		undecryptableEvents = append(undecryptableEvents, undecryptable)
+	}
+	return undecryptableEvents
+}
+
+func (store *StateStore) RemoveUndecryptableEvent(eventID mid.EventID) {
+	if _, err := store.DB.Exec("DELETE FROM undecryptable_events WHERE event_id = $1", eventID); err != nil {
+		log.Errorf("Failed to remove undecryptable event %s: %+v", eventID, err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix"
+)
+
+// The delays between retries of /sync after it fails. The delay doubles with
+// each failure, up to maxSyncBackoff.
+const (
+	minSyncBackoff = 1 * time.Second
+	maxSyncBackoff = 5 * time.Minute
+)
+
+// The number of times in a row that /sync has failed, and the last error.
+var syncFailures int64
+var lastSyncError atomic.Value
+
+// failFastSyncer makes Sync return when a /sync request fails instead of
+// retrying after a fixed delay, so that RunSyncLoop can back off and handle
+// logouts.
+type failFastSyncer struct {
+	mautrix.Syncer
+}
+
+func (s failFastSyncer) OnFailedSync(_ *mautrix.RespSync, err error) (time.Duration, error) {
+	return 0, err
+}
+
+// syncSucceeded records a successful /sync.
+func syncSucceeded() {
+	atomic.StoreInt64(&lastSyncAt, time.Now().Unix())
+	if failures := atomic.SwapInt64(&syncFailures, 0); failures > 0 {
+		log.Infof("Sync recovered after %d failures", failures)
+	}
+}
+
+// syncBackoff returns how long to wait before syncing again after the given
+// number of failures in a row. The delay is randomized so that many bots do
+// not retry at the same time after an outage.
+func syncBackoff(failures int64) time.Duration {
+	delay := maxSyncBackoff
+	if failures < 20 {
+		delay = minSyncBackoff << uint(failures-1)
+		if delay > maxSyncBackoff {
+			delay = maxSyncBackoff
+		}
+	}
+	// Wait between half and all of the delay.
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// softLogout returns whether the error is an M_UNKNOWN_TOKEN error with
+// soft_logout set, which means that the device still exists and only the
+// access token has to be replaced.
+func softLogout(err error) bool {
+	var httpErr mautrix.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.RespError == nil {
+		return false
+	}
+	soft, _ := httpErr.RespError.ExtraData["soft_logout"].(bool)
+	return soft
+}
+
+// RunSyncLoop syncs until the context is done. When /sync fails, it backs off
+// exponentially, and when the access token is no longer valid, it logs in
+// again using the same device ID.
+func RunSyncLoop(ctx context.Context, description string) {
+	client.Syncer = failFastSyncer{client.Syncer}
+	for ctx.Err() == nil {
+		log.Debugf("Running %s...", description)
+		err := client.SyncWithContext(ctx)
+		if ctx.Err() != nil {
+			return
+		} else if err == nil {
+			continue
+		}
+
+		failures := atomic.AddInt64(&syncFailures, 1)
+		lastSyncError.Store(err.Error())
+		if errors.Is(err, mautrix.MUnknownToken) {
+			soft := softLogout(err)
+			log.Warnf("The access token is no longer valid (soft logout: %t), logging in again", soft)
+			if err := Relogin(soft); err != nil {
+				log.Errorf("Failed to log in again: %+v", err)
+			} else {
+				log.Infof("Logged in again as %s/%s", client.UserID, client.DeviceID)
+				continue
+			}
+		}
+
+		delay := syncBackoff(failures)
+		log.Errorf("%s failed %d times in a row, retrying in %s. %+v", description, failures, delay.Round(time.Second), err)
+		if !sleepContext(ctx, delay) {
+			return
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix"
+)
+
+func TestSyncBackoff(t *testing.T) {
+	for failures, maxDelay := range map[int64]time.Duration{
+		1:   time.Second,
+		3:   4 * time.Second,
+		10:  maxSyncBackoff,
+		100: maxSyncBackoff,
+	} {
+		for i := 0; i < 10; i++ {
+			if delay := syncBackoff(failures); delay < maxDelay/2 || delay > maxDelay {
+				t.Errorf("Expected the delay after %d failures to be between %s and %s, got %s", failures, maxDelay/2, maxDelay, delay)
+			}
+		}
+	}
+}
+
+func TestSyncReloginAfterSoftLogout(t *testing.T) {
+	setupTest(t, tuesday)
+	resetShutdown(t)
+	atomic.StoreInt64(&syncFailures, 0)
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(passwordFile, []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	configuration.PasswordFile = passwordFile
+
+	var loginRequest mautrix.ReqLogin
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/filter"):
+			w.Write([]byte(`{"filter_id": "1"}`))
+		case strings.HasSuffix(r.URL.Path, "/login"):
+			json.NewDecoder(r.Body).Decode(&loginRequest)
+			w.Write([]byte(`{"access_token": "new", "device_id": "DEVICE", "user_id": "@standupbot:example.com"}`))
+		case strings.HasSuffix(r.URL.Path, "/sync") && r.Header.Get("Authorization") == "Bearer new":
+			w.Write([]byte(`{"next_batch": "s1"}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"errcode": "M_UNKNOWN_TOKEN", "error": "Unknown token", "soft_logout": true}`))
+		}
+	}))
+	defer server.Close()
+
+	var err error
+	client, err = mautrix.NewClient(server.URL, testBotUser, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { client = nil }()
+	client.DeviceID = "DEVICE"
+	client.Store = mautrix.NewInMemoryStore()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client.Syncer.(mautrix.ExtensibleSyncer).OnSync(func(resp *mautrix.RespSync, since string) bool {
+		syncSucceeded()
+		cancel()
+		return true
+	})
+
+	RunSyncLoop(ctx, "sync")
+	if client.AccessToken != "new" {
+		t.Errorf("Expected to log in again, the access token is %s", client.AccessToken)
+	}
+	if loginRequest.DeviceID != "DEVICE" || loginRequest.Password != "hunter2" {
+		t.Errorf("Expected to log in with the same device ID and the password, got %+v", loginRequest)
+	}
+	if failures := atomic.LoadInt64(&syncFailures); failures != 0 {
+		t.Errorf("Expected the failures to be reset after a successful sync, got %d", failures)
+	}
+}
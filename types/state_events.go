@@ -5,10 +5,65 @@ import (
 	mid "maunium.net/go/mautrix/id"
 )
 
-var StateTzSetting = mevent.Type{Type: "com.nevarro.standupbot.timezone", Class: mevent.StateEventType}
-var StateNotify = mevent.Type{Type: "com.nevarro.standupbot.notify", Class: mevent.StateEventType}
-var StateSendRoom = mevent.Type{Type: "com.nevarro.standupbot.send_room", Class: mevent.StateEventType}
-var StateUseThreads = mevent.Type{Type: "com.nevarro.standupbot.use_threads", Class: mevent.StateEventType}
+// DefaultNamespace is the default prefix of the types of the bot's state
+// events.
+const DefaultNamespace = "com.nevarro.standupbot"
+
+// The names of the state events that are stored in the config room with the
+// user's localpart as the state key.
+var UserStateEventNames = []string{"timezone", "notify", "send_room", "use_threads", "language", "previous_post", "weekly_room"}
+
+// The names of the state events that are stored in the send room with an empty
+// state key.
+var RoomStateEventNames = []string{"room_language"}
+
+// The current prefix of the types of the bot's state events. Change it using
+// SetNamespace.
+var Namespace string
+
+var StateTzSetting mevent.Type
+var StateNotify mevent.Type
+var StateSendRoom mevent.Type
+var StateUseThreads mevent.Type
+var StateLanguage mevent.Type
+var StatePreviousPost mevent.Type
+var StateWeeklyRoom mevent.Type
+
+// StateRoomLanguage is stored in the send room with an empty state key.
+var StateRoomLanguage mevent.Type
+
+func init() {
+	SetNamespace(DefaultNamespace)
+}
+
+// StateEventType returns the type of the state event with the given name in
+// the given namespace.
+func StateEventType(namespace, name string) mevent.Type {
+	return mevent.Type{Type: namespace + "." + name, Class: mevent.StateEventType}
+}
+
+// SetNamespace changes the prefix of the types of the bot's state events.
+func SetNamespace(namespace string) {
+	Namespace = namespace
+	StateTzSetting = StateEventType(namespace, "timezone")
+	StateNotify = StateEventType(namespace, "notify")
+	StateSendRoom = StateEventType(namespace, "send_room")
+	StateUseThreads = StateEventType(namespace, "use_threads")
+	StateLanguage = StateEventType(namespace, "language")
+	StatePreviousPost = StateEventType(namespace, "previous_post")
+	StateWeeklyRoom = StateEventType(namespace, "weekly_room")
+	StateRoomLanguage = StateEventType(namespace, "room_language")
+}
+
+// UserStateEventTypes returns the types of the state events that are stored in
+// the config room in the current namespace.
+func UserStateEventTypes() []mevent.Type {
+	eventTypes := make([]mevent.Type, 0, len(UserStateEventNames))
+	for _, name := range UserStateEventNames {
+		eventTypes = append(eventTypes, StateEventType(Namespace, name))
+	}
+	return eventTypes
+}
 
 type TzSettingEventContent struct {
 	TzString string
@@ -22,6 +77,16 @@ type SendRoomEventContent struct {
 	SendRoomID mid.RoomID
 }
 
+// WeeklyRoomEventContent is the room that the weekly summaries are sent to.
+// If it is empty, they are sent to the send room.
+type WeeklyRoomEventContent struct {
+	WeeklyRoomID mid.RoomID
+}
+
 type UseThreadsEventContent struct {
 	UseThreads bool
 }
+
+type LanguageEventContent struct {
+	Language string
+}
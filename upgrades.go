@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+
+	"github.com/beeper/standupbot/types"
+)
+
+// HandleTombstone follows the upgrade of a config room, a send room or a
+// weekly room to its replacement room. Upgrades of other rooms are ignored.
+func HandleTombstone(event *mevent.Event) {
+	oldRoomID := event.RoomID
+	newRoomID := event.Content.AsTombstone().ReplacementRoom
+	if newRoomID == "" {
+		return
+	}
+
+	var configRoomUsers, sendRoomUsers, weeklyRoomUsers []mid.UserID
+	for userID, configRoomID := range stateStore.UserConfigRooms {
+		if configRoomID == oldRoomID {
+			configRoomUsers = append(configRoomUsers, userID)
+		}
+		if sendRoomID, err := stateStore.GetSendRoomId(userID); err == nil && sendRoomID == oldRoomID {
+			sendRoomUsers = append(sendRoomUsers, userID)
+		}
+		if stateStore.GetWeeklyRoomId(userID) == oldRoomID {
+			weeklyRoomUsers = append(weeklyRoomUsers, userID)
+		}
+	}
+	if len(configRoomUsers) == 0 && len(sendRoomUsers) == 0 && len(weeklyRoomUsers) == 0 {
+		log.Debugf("Ignoring the upgrade of %s because it is not a config room, a send room or a weekly room", oldRoomID)
+		return
+	}
+	sort.Slice(configRoomUsers, func(i, j int) bool { return configRoomUsers[i] < configRoomUsers[j] })
+	sort.Slice(sendRoomUsers, func(i, j int) bool { return sendRoomUsers[i] < sendRoomUsers[j] })
+	sort.Slice(weeklyRoomUsers, func(i, j int) bool { return weeklyRoomUsers[i] < weeklyRoomUsers[j] })
+
+	log.Infof("%s was upgraded to %s. Joining the replacement room", oldRoomID, newRoomID)
+	_, err := DoRetry("join replacement room", func() (interface{}, error) {
+		return matrixClient.JoinRoom(newRoomID.String(), serverName(event.Sender.String()), nil)
+	})
+	if err != nil {
+		log.Errorf("Could not join the replacement room %s. Error %+v", newRoomID, err)
+		for _, userID := range append(append(configRoomUsers, sendRoomUsers...), weeklyRoomUsers...) {
+			sendNotice(stateStore.GetConfigRoomId(userID), T(languageFor(userID), "upgrade.join_failed", oldRoomID, newRoomID, err))
+		}
+		return
+	}
+
+	for _, userID := range configRoomUsers {
+		moveConfigRoom(userID, oldRoomID, newRoomID)
+	}
+	if len(sendRoomUsers) > 0 {
+		if language := stateStore.GetRoomLanguage(oldRoomID); language != "" {
+			_, err := matrixClient.SendStateEvent(newRoomID, types.StateRoomLanguage, "", types.LanguageEventContent{Language: language})
+			if err != nil {
+				log.Errorf("Failed to copy the language of %s to %s: %+v", oldRoomID, newRoomID, err)
+			} else {
+				stateStore.SetRoomLanguage(newRoomID, language)
+			}
+		}
+		for _, userID := range sendRoomUsers {
+			moveSendRoom(userID, oldRoomID, newRoomID)
+		}
+	}
+	for _, userID := range weeklyRoomUsers {
+		moveWeeklyRoom(userID, oldRoomID, newRoomID)
+	}
+}
+
+// moveConfigRoom copies the user's settings from the old config room to its
+// replacement and makes the replacement the user's config room.
+func moveConfigRoom(userID mid.UserID, oldRoomID, newRoomID mid.RoomID) {
+	lang := languageFor(userID)
+	stateKey := strings.TrimPrefix(userID.String(), "@")
+	for _, eventType := range types.UserStateEventTypes() {
+		var content json.RawMessage
+		if err := matrixClient.StateEvent(oldRoomID, eventType, stateKey, &content); err != nil {
+			// The setting is not set.
+			continue
+		}
+		if _, err := matrixClient.SendStateEvent(newRoomID, eventType, stateKey, content); err != nil {
+			log.Errorf("Failed to copy %s of %s to %s: %+v", eventType.Type, userID, newRoomID, err)
+			sendNotice(oldRoomID, T(lang, "upgrade.config_room_failed", newRoomID, err))
+			return
+		}
+	}
+
+	log.Infof("Moved the config room of %s from %s to %s", userID, oldRoomID, newRoomID)
+	stateStore.SetConfigRoom(userID, newRoomID)
+	sendNotice(newRoomID, T(lang, "upgrade.config_room", oldRoomID))
+}
+
+// moveSendRoom points the user's send room setting at the replacement of the
+// old send room.
+func moveSendRoom(userID mid.UserID, oldRoomID, newRoomID mid.RoomID) {
+	lang := languageFor(userID)
+	stateKey := strings.TrimPrefix(userID.String(), "@")
+	configRoomID := stateStore.GetConfigRoomId(userID)
+	_, err := matrixClient.SendStateEvent(configRoomID, types.StateSendRoom, stateKey, types.SendRoomEventContent{
+		SendRoomID: newRoomID,
+	})
+	if err != nil {
+		log.Errorf("Failed to update the send room of %s to %s: %+v", userID, newRoomID, err)
+		sendNotice(configRoomID, T(lang, "upgrade.send_room_failed", oldRoomID, newRoomID, err))
+		return
+	}
+
+	log.Infof("Moved the send room of %s from %s to %s", userID, oldRoomID, newRoomID)
+	stateStore.SetSendRoomId(userID, newRoomID)
+	sendNotice(configRoomID, T(lang, "upgrade.send_room", oldRoomID, newRoomID))
+}
+
+// moveWeeklyRoom points the user's weekly room setting at the replacement of
+// the old weekly room.
+func moveWeeklyRoom(userID mid.UserID, oldRoomID, newRoomID mid.RoomID) {
+	lang := languageFor(userID)
+	stateKey := strings.TrimPrefix(userID.String(), "@")
+	configRoomID := stateStore.GetConfigRoomId(userID)
+	_, err := matrixClient.SendStateEvent(configRoomID, types.StateWeeklyRoom, stateKey, types.WeeklyRoomEventContent{
+		WeeklyRoomID: newRoomID,
+	})
+	if err != nil {
+		log.Errorf("Failed to update the weekly room of %s to %s: %+v", userID, newRoomID, err)
+		sendNotice(configRoomID, T(lang, "upgrade.weekly_room_failed", oldRoomID, newRoomID, err))
+		return
+	}
+
+	log.Infof("Moved the weekly room of %s from %s to %s", userID, oldRoomID, newRoomID)
+	stateStore.SetWeeklyRoomId(userID, newRoomID)
+	sendNotice(configRoomID, T(lang, "upgrade.weekly_room", oldRoomID, newRoomID))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+
+	"github.com/beeper/standupbot/types"
+)
+
+func tombstone(roomID, replacement mid.RoomID) *mevent.Event {
+	return &mevent.Event{
+		RoomID:  roomID,
+		Sender:  testUser,
+		Type:    mevent.StateTombstone,
+		Content: mevent.Content{Parsed: &mevent.TombstoneEventContent{ReplacementRoom: replacement}},
+	}
+}
+
+func TestConfigRoomUpgrade(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	newConfigRoom := mid.RoomID("!newconfig:example.com")
+
+	hs.sendText("!su tz Europe/Berlin")
+	hs.sendText("!su room " + testSendRoom.String())
+	HandleTombstone(tombstone(testConfigRoom, newConfigRoom))
+
+	if configRoomID := stateStore.GetConfigRoomId(testUser); configRoomID != newConfigRoom {
+		t.Errorf("Expected the config room to be %s, got %s", newConfigRoom, configRoomID)
+	}
+	var tz types.TzSettingEventContent
+	if err := hs.StateEvent(newConfigRoom, types.StateTzSetting, "alice:example.com", &tz); err != nil || tz.TzString != "Europe/Berlin" {
+		t.Errorf("Expected the timezone to be copied to the new room, got %+v (%v)", tz, err)
+	}
+	var sendRoom types.SendRoomEventContent
+	if err := hs.StateEvent(newConfigRoom, types.StateSendRoom, "alice:example.com", &sendRoom); err != nil || sendRoom.SendRoomID != testSendRoom {
+		t.Errorf("Expected the send room to be copied to the new room, got %+v (%v)", sendRoom, err)
+	}
+	assertContains(t, hs.lastMessage(t, newConfigRoom).Content.AsMessage().Body, "This room replaces !config:example.com.")
+}
+
+func TestSendRoomUpgrade(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	newSendRoom := mid.RoomID("!newsend:example.com")
+
+	hs.sendText("!su room " + testSendRoom.String())
+	hs.sendText("!su roomlang de")
+	HandleTombstone(tombstone(testSendRoom, newSendRoom))
+
+	if sendRoomID, _ := stateStore.GetSendRoomId(testUser); sendRoomID != newSendRoom {
+		t.Errorf("Expected the send room to be %s, got %s", newSendRoom, sendRoomID)
+	}
+	if language := stateStore.GetRoomLanguage(newSendRoom); language != "de" {
+		t.Errorf("Expected the room language to be copied to the new room, got %q", language)
+	}
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "Standup posts will now be sent to !newsend:example.com.")
+}
+
+func TestUnrelatedRoomUpgrade(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	hs.sendText("!su room " + testSendRoom.String())
+
+	HandleTombstone(tombstone("!other:example.com", "!newother:example.com"))
+	for _, roomID := range hs.joinedRooms {
+		if roomID == "!newother:example.com" {
+			t.Errorf("Expected the bot not to follow the upgrade of an unrelated room")
+		}
+	}
+}
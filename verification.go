@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mcrypto "maunium.net/go/mautrix/crypto"
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+)
+
+// SetupCrossSigning loads the bot's cross-signing keys from secret storage
+// using the recovery key in CrossSigningRecoveryKeyFile and signs the bot's
+// device with them. If the file does not exist, new cross-signing keys are
+// generated and the recovery key for them is written to the file.
+func SetupCrossSigning() error {
+	recoveryKey, err := readSecretFile(configuration.CrossSigningRecoveryKeyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Info("No cross-signing recovery key found, bootstrapping cross-signing")
+		// Uploading the cross-signing keys requires user-interactive
+		// authentication with the account password.
+		password, err := configuration.GetPassword()
+		if err != nil {
+			return fmt.Errorf("bootstrapping cross-signing requires PasswordFile to be set: %w", err)
+		}
+		recoveryKey, err = olmMachine.GenerateAndUploadCrossSigningKeys(password, "")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(configuration.CrossSigningRecoveryKeyFile, []byte(recoveryKey+"\n"), 0600); err != nil {
+			return fmt.Errorf("failed to save the cross-signing recovery key: %w", err)
+		}
+		log.Infof("Generated cross-signing keys and saved the recovery key to %s", configuration.CrossSigningRecoveryKeyFile)
+	} else if err != nil {
+		return err
+	} else {
+		_, keyData, err := olmMachine.SSSS.GetDefaultKeyData()
+		if err != nil {
+			return fmt.Errorf("failed to get the secret storage key: %w", err)
+		}
+		key, err := keyData.VerifyRecoveryKey(recoveryKey)
+		if err != nil {
+			return fmt.Errorf("invalid cross-signing recovery key: %w", err)
+		}
+		if err := olmMachine.FetchCrossSigningKeysFromSSSS(key); err != nil {
+			return fmt.Errorf("failed to fetch cross-signing keys: %w", err)
+		}
+		log.Info("Loaded cross-signing keys from secret storage")
+	}
+
+	if err := olmMachine.SignOwnDevice(olmMachine.OwnIdentity()); err != nil {
+		return fmt.Errorf("failed to sign own device: %w", err)
+	}
+	if err := olmMachine.SignOwnMasterKey(); err != nil {
+		return fmt.Errorf("failed to sign own master key: %w", err)
+	}
+	return nil
+}
+
+// How long to wait for the user to confirm whether the SAS matches.
+const sasConfirmationTimeout = 5 * time.Minute
+
+// The SAS notices that are waiting for the user to react with whether the SAS
+// matches, by their event ID.
+var pendingSASConfirmations = struct {
+	sync.Mutex
+	notices map[mid.EventID]*pendingSASConfirmation
+}{notices: map[mid.EventID]*pendingSASConfirmation{}}
+
+type pendingSASConfirmation struct {
+	userID  mid.UserID
+	matches chan bool
+}
+
+// AcceptVerificationFrom accepts SAS verification requests from the bot's own
+// account and from the admins, if they have a config room with the bot or
+// start the verification in a room with the bot.
+func AcceptVerificationFrom(transactionID string, otherDevice *mcrypto.DeviceIdentity, inRoomID mid.RoomID) (mcrypto.VerificationRequestResponse, mcrypto.VerificationHooks) {
+	if config := currentConfiguration(); otherDevice.UserID.String() != config.Username && !config.IsAdmin(otherDevice.UserID) {
+		log.Infof("Rejecting verification request %s from %s: only the bot's own account and the admins can verify the bot", transactionID, otherDevice.UserID)
+		return mcrypto.RejectRequest, nil
+	}
+	roomID := inRoomID
+	if roomID == "" {
+		roomID = stateStore.GetConfigRoomId(otherDevice.UserID)
+	}
+	if roomID == "" {
+		log.Infof("Rejecting verification request %s from %s: no room to show the SAS in", transactionID, otherDevice.UserID)
+		return mcrypto.RejectRequest, nil
+	}
+	log.Infof("Accepting verification request %s from %s/%s", transactionID, otherDevice.UserID, otherDevice.DeviceID)
+	return mcrypto.AcceptRequest, &sasVerificationHooks{userID: otherDevice.UserID, roomID: roomID}
+}
+
+// sasVerificationHooks echoes the SAS in a room so that the user can compare
+// it with the SAS shown by their client, and waits for them to react with
+// whether it matches.
+type sasVerificationHooks struct {
+	userID mid.UserID
+	roomID mid.RoomID
+}
+
+var _ mcrypto.VerificationHooks = &sasVerificationHooks{}
+
+func (h *sasVerificationHooks) VerifySASMatch(otherDevice *mcrypto.DeviceIdentity, sas mcrypto.SASData) bool {
+	lang := languageFor(otherDevice.UserID)
+	deviceHtml := "<code>" + html.EscapeString(otherDevice.DeviceID.String()) + "</code>"
+	var noticeText, noticeHtml string
+	switch data := sas.(type) {
+	case mcrypto.EmojiSASData:
+		emojis := make([]string, 0)
+		descriptions := make([]string, 0)
+		for _, emoji := range data {
+			emojis = append(emojis, string(emoji.GetEmoji()))
+			descriptions = append(descriptions, emoji.GetDescription())
+		}
+		noticeText = fmt.Sprintf("%s\n\n%s\n(%s)",
+			T(lang, "verification.emoji", otherDevice.DeviceID), strings.Join(emojis, " "), strings.Join(descriptions, ", "))
+		noticeHtml = fmt.Sprintf("%s<br><br><font size=\"6\">%s</font><br>(%s)",
+			T(lang, "verification.emoji", deviceHtml), strings.Join(emojis, " "), strings.Join(descriptions, ", "))
+	case mcrypto.DecimalSASData:
+		noticeText = fmt.Sprintf("%s %d %d %d",
+			T(lang, "verification.decimal", otherDevice.DeviceID), data[0], data[1], data[2])
+		noticeHtml = fmt.Sprintf("%s <b>%d %d %d</b>",
+			T(lang, "verification.decimal", deviceHtml), data[0], data[1], data[2])
+	default:
+		log.Warnf("Unknown SAS type %s", sas.Type())
+		return false
+	}
+	confirm := T(lang, "verification.confirm", CHECKMARK, RED_X)
+	noticeText += "\n\n" + confirm
+	noticeHtml += "<br><br>" + confirm
+
+	resp, err := SendMessage(h.roomID, &mevent.MessageEventContent{
+		MsgType:       mevent.MsgNotice,
+		Body:          noticeText,
+		Format:        mevent.FormatHTML,
+		FormattedBody: noticeHtml,
+	})
+	if err != nil {
+		log.Errorf("Failed to send the SAS for %s/%s: %+v", otherDevice.UserID, otherDevice.DeviceID, err)
+		return false
+	}
+
+	// The bot does not add the reactions itself, because a reaction from the
+	// bot's own account would confirm the verification of its other devices.
+	confirmation := &pendingSASConfirmation{userID: otherDevice.UserID, matches: make(chan bool, 1)}
+	pendingSASConfirmations.Lock()
+	pendingSASConfirmations.notices[resp.EventID] = confirmation
+	pendingSASConfirmations.Unlock()
+	defer func() {
+		pendingSASConfirmations.Lock()
+		defer pendingSASConfirmations.Unlock()
+		delete(pendingSASConfirmations.notices, resp.EventID)
+	}()
+
+	select {
+	case matches := <-confirmation.matches:
+		return matches
+	case <-time.After(sasConfirmationTimeout):
+		log.Infof("%s did not confirm the SAS for %s within %s", otherDevice.UserID, otherDevice.DeviceID, sasConfirmationTimeout)
+		return false
+	case <-shutdownCtx.Done():
+		return false
+	}
+}
+
+// HandleSASReaction passes the user's answer on to the verification if the
+// reaction is to a SAS notice, and returns whether it was.
+func HandleSASReaction(event *mevent.Event) bool {
+	relatesTo := event.Content.AsReaction().RelatesTo
+	pendingSASConfirmations.Lock()
+	defer pendingSASConfirmations.Unlock()
+	confirmation, found := pendingSASConfirmations.notices[relatesTo.EventID]
+	if !found {
+		return false
+	} else if event.Sender != confirmation.userID || (relatesTo.Key != CHECKMARK && relatesTo.Key != RED_X) {
+		return true
+	}
+	delete(pendingSASConfirmations.notices, relatesTo.EventID)
+	confirmation.matches <- relatesTo.Key == CHECKMARK
+	return true
+}
+
+func (h *sasVerificationHooks) VerificationMethods() []mcrypto.VerificationMethod {
+	return []mcrypto.VerificationMethod{
+		mcrypto.VerificationMethodEmoji{},
+		mcrypto.VerificationMethodDecimal{},
+	}
+}
+
+func (h *sasVerificationHooks) OnCancel(cancelledByUs bool, reason string, reasonCode mevent.VerificationCancelCode) {
+	log.Infof("Verification cancelled (by us: %t): %s (%s)", cancelledByUs, reason, reasonCode)
+	SendMessage(h.roomID, &mevent.MessageEventContent{
+		MsgType: mevent.MsgNotice,
+		Body:    T(languageFor(h.userID), "verification.cancelled", reason),
+	})
+}
+
+func (h *sasVerificationHooks) OnSuccess() {
+	SendMessage(h.roomID, &mevent.MessageEventContent{
+		MsgType: mevent.MsgNotice,
+		Body:    T(languageFor(h.userID), "verification.success"),
+	})
+}
+
+// HandleInRoomVerification passes in-room verification events to the
+// OlmMachine.
+func HandleInRoomVerification(event *mevent.Event) {
+	if content, ok := event.Content.Parsed.(*mevent.MessageEventContent); ok && content.RelatesTo == nil {
+		// The verification request message does not have a relation, but
+		// ProcessInRoomVerification expects one on every event.
+		content.RelatesTo = &mevent.RelatesTo{}
+	}
+	if err := olmMachine.ProcessInRoomVerification(event); err != nil {
+		log.Errorf("Failed to process in-room verification event %s: %+v", event.ID, err)
+	}
+}
+
+func isVerificationRequest(event *mevent.Event) bool {
+	content, ok := event.Content.Parsed.(*mevent.MessageEventContent)
+	return ok && content.MsgType == mevent.MsgVerificationRequest
+}
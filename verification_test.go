@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	mcrypto "maunium.net/go/mautrix/crypto"
+	mevent "maunium.net/go/mautrix/event"
+	mid "maunium.net/go/mautrix/id"
+)
+
+// startSASVerification accepts a verification from the test user in their
+// config room and compares the SAS in the background. It returns the SAS
+// notice and a channel with the result of the comparison.
+func startSASVerification(t *testing.T, hs *fakeHomeserver) (mid.EventID, chan bool) {
+	t.Helper()
+	device := &mcrypto.DeviceIdentity{UserID: testUser, DeviceID: "DEVICE"}
+	response, hooks := AcceptVerificationFrom("txn", device, testConfigRoom)
+	if response != mcrypto.AcceptRequest {
+		t.Fatal("Expected the verification request to be accepted")
+	}
+
+	result := make(chan bool, 1)
+	go func() { result <- hooks.VerifySASMatch(device, mcrypto.DecimalSASData{1234, 5678, 4321}) }()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if messages := hs.messages(testConfigRoom); len(messages) > 0 {
+			noticeID := messages[len(messages)-1].ID
+			pendingSASConfirmations.Lock()
+			_, pending := pendingSASConfirmations.notices[noticeID]
+			pendingSASConfirmations.Unlock()
+			if pending {
+				return noticeID, result
+			}
+		}
+	}
+	t.Fatal("The SAS was not sent")
+	return "", nil
+}
+
+func TestVerificationOnlyFromAdmins(t *testing.T) {
+	setupTest(t, tuesday)
+	device := &mcrypto.DeviceIdentity{UserID: testUser, DeviceID: "DEVICE"}
+	if response, _ := AcceptVerificationFrom("txn", device, testConfigRoom); response != mcrypto.RejectRequest {
+		t.Error("Expected the verification request of a user who is not an admin to be rejected")
+	}
+
+	configuration.Admins = []mid.UserID{testUser}
+	if response, _ := AcceptVerificationFrom("txn", device, testConfigRoom); response != mcrypto.AcceptRequest {
+		t.Error("Expected the verification request of an admin to be accepted")
+	}
+	device.UserID = testBotUser
+	if response, _ := AcceptVerificationFrom("txn", device, testConfigRoom); response != mcrypto.AcceptRequest {
+		t.Error("Expected the verification request of the bot's own account to be accepted")
+	}
+}
+
+func TestVerifySASMatch(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	configuration.Admins = []mid.UserID{testUser}
+
+	for _, test := range []struct {
+		key     string
+		matches bool
+	}{{CHECKMARK, true}, {RED_X, false}} {
+		noticeID, result := startSASVerification(t, hs)
+		assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "1234 5678 4321")
+
+		// Other reactions do not answer the comparison.
+		hs.react(noticeID, "👍")
+		select {
+		case <-result:
+			t.Fatal("The SAS comparison finished before the user confirmed it")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		hs.react(noticeID, test.key)
+		select {
+		case matches := <-result:
+			if matches != test.matches {
+				t.Errorf("Expected %s to answer %t, got %t", test.key, test.matches, matches)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("The SAS comparison did not finish after reacting with %s", test.key)
+		}
+	}
+}
+
+func TestVerificationLanguage(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	configuration.Admins = []mid.UserID{testUser}
+	stateStore.SetLanguage(testUser, "de")
+
+	noticeID, result := startSASVerification(t, hs)
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body,
+		"Verifiziere DEVICE.", "1234 5678 4321", "Reagiere mit ✅, wenn sie übereinstimmen, oder mit ❌, wenn nicht.")
+	hs.react(noticeID, CHECKMARK)
+	<-result
+
+	_, hooks := AcceptVerificationFrom("txn", &mcrypto.DeviceIdentity{UserID: testUser, DeviceID: "DEVICE"}, testConfigRoom)
+	hooks.OnSuccess()
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "Verifizierung erfolgreich!")
+	hooks.OnCancel(false, "timed out", mevent.VerificationCancelByTimeout)
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "Verifizierung abgebrochen: timed out")
+}
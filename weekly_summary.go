@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mevent "maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	mid "maunium.net/go/mautrix/id"
+
+	"github.com/beeper/standupbot/store"
+)
+
+// How long the sent posts are kept for the weekly summaries.
+const postHistoryRetention = 5 * 7 * 24 * time.Hour
+
+// WeeklyBlocker is a blocker that was raised during the week.
+type WeeklyBlocker struct {
+	StandupItem
+	// Whether the blocker was no longer listed in the last post of the week.
+	Cleared bool
+}
+
+// WeeklySummary is the summary of the standup posts that a user sent during a
+// week.
+type WeeklySummary struct {
+	// The start of the week in the user's timezone.
+	WeekOf time.Time
+	// The items of the Yesterday and Today sections, without duplicates.
+	Items    []StandupItem
+	Blockers []WeeklyBlocker
+	Posts    int
+}
+
+// The weekly summaries that are waiting for the users to confirm them. They
+// are not saved when the bot stops. Users can compile theirs again using
+// `!su weekly`.
+var pendingWeeklySummaries = struct {
+	sync.Mutex
+	users map[mid.UserID]*pendingWeeklySummary
+}{users: map[mid.UserID]*pendingWeeklySummary{}}
+
+type pendingWeeklySummary struct {
+	PreviewEventID mid.EventID
+	Summary        *WeeklySummary
+}
+
+// recordPost adds the post that the user sent to their post history.
+func recordPost(userID mid.UserID, flow *StandupFlow) {
+	content, err := json.Marshal(flow)
+	if err != nil {
+		log.Errorf("Failed to encode the post of %s: %+v", userID, err)
+		return
+	}
+	now := store.Now()
+	if err := stateStore.SavePost(store.Post{UserID: userID, FlowID: flow.FlowID.String(), SentAt: now, Content: content}); err != nil {
+		log.Errorf("Failed to add the post of %s to the post history: %+v", userID, err)
+	}
+	if err := stateStore.RemovePostsBefore(now.Add(-postHistoryRetention)); err != nil {
+		log.Errorf("Failed to remove old posts from the post history: %+v", err)
+	}
+}
+
+// startOfWeek returns midnight on the Monday of the week that contains t in
+// the given timezone.
+func startOfWeek(t time.Time, location *time.Location) time.Time {
+	local := t.In(location)
+	daysSinceMonday := (int(local.Weekday()) + 6) % 7
+	return time.Date(local.Year(), local.Month(), local.Day()-daysSinceMonday, 0, 0, 0, 0, location)
+}
+
+// CompileWeeklySummary summarizes the posts that the user sent this week.
+func CompileWeeklySummary(userID mid.UserID, now time.Time) (*WeeklySummary, error) {
+	weekOf := startOfWeek(now, stateStore.GetTimezone(userID))
+	posts, err := stateStore.GetPosts(userID, weekOf)
+	if err != nil {
+		return nil, err
+	}
+	flows := make([]*StandupFlow, 0, len(posts))
+	for _, post := range posts {
+		var flow StandupFlow
+		if err := json.Unmarshal(post.Content, &flow); err != nil {
+			log.Warnf("Ignoring post %s of %s in the post history: %v", post.FlowID, userID, err)
+			continue
+		}
+		flows = append(flows, &flow)
+	}
+	return compileWeeklySummary(weekOf, flows), nil
+}
+
+// compileWeeklySummary summarizes the posts, which are in the order that they
+// were sent in. The Friday and Weekend sections of Monday's post are about the
+// previous week, so they are left out. A blocker is cleared if it is not in
+// the last post.
+func compileWeeklySummary(weekOf time.Time, posts []*StandupFlow) *WeeklySummary {
+	summary := &WeeklySummary{WeekOf: weekOf, Items: make([]StandupItem, 0), Blockers: make([]WeeklyBlocker, 0), Posts: len(posts)}
+	seenItems := map[string]bool{}
+	blockerIndexes := map[string]int{}
+	for i, post := range posts {
+		for _, item := range append(append([]StandupItem{}, post.Yesterday...), post.Today...) {
+			if key := itemKey(item); !seenItems[key] {
+				seenItems[key] = true
+				summary.Items = append(summary.Items, item)
+			}
+		}
+		for _, item := range post.Blockers {
+			key := itemKey(item)
+			index, found := blockerIndexes[key]
+			if !found {
+				index = len(summary.Blockers)
+				blockerIndexes[key] = index
+				summary.Blockers = append(summary.Blockers, WeeklyBlocker{StandupItem: item})
+			}
+			summary.Blockers[index].Cleared = i < len(posts)-1
+		}
+	}
+	return summary
+}
+
+// itemKey returns the text of the item that is compared to find duplicates.
+// Items that only differ in case or whitespace are the same.
+func itemKey(item StandupItem) string {
+	return strings.ToLower(strings.Join(strings.Fields(item.Body), " "))
+}
+
+// FormatWeeklySummary formats the summary like a standup post. The preview
+// asks the user whether to send it.
+func FormatWeeklySummary(userID mid.UserID, summary *WeeklySummary, preview bool) *mevent.MessageEventContent {
+	lang := languageFor(userID)
+	postLang := postLanguageFor(userID)
+	weekOf := summary.WeekOf.Format("2006-01-02")
+	postText := T(postLang, "weekly.header", userID, weekOf) + "\n\n"
+	postHtml := T(postLang, "weekly.header", fmt.Sprintf(`<a href="https://matrix.to/#/%s">%s</a>`, userID, userID), weekOf) + "<br><br>"
+
+	if len(summary.Items) > 0 {
+		header := T(postLang, "weekly.items")
+		plain, html := formatList(summary.Items)
+		postText += "**" + header + "**\n" + plain
+		postHtml += "<b>" + header + "</b><br><ul>" + html + "</ul>"
+	}
+	if len(summary.Blockers) > 0 {
+		blockers := make([]StandupItem, 0, len(summary.Blockers))
+		for _, blocker := range summary.Blockers {
+			status := T(postLang, "weekly.blocker_open")
+			if blocker.Cleared {
+				status = T(postLang, "weekly.blocker_cleared")
+			}
+			item := blocker.StandupItem
+			if item.FormattedBody == "" {
+				item.FormattedBody = item.Body
+			}
+			item.Body += " " + status
+			item.FormattedBody += " <i>" + status + "</i>"
+			blockers = append(blockers, item)
+		}
+		if len(summary.Items) > 0 {
+			postText += "\n"
+		}
+		header := T(postLang, "section.blockers")
+		plain, html := formatList(blockers)
+		postText += "**" + header + "**\n" + plain
+		postHtml += "<b>" + header + "</b><br><ul>" + html + "</ul>"
+	}
+
+	if !preview {
+		return previewContent(postText, postHtml, "", "")
+	}
+	return previewContent(postText, postHtml, T(lang, "weekly.preview_header"), T(lang, "preview.send", CHECKMARK, RED_X))
+}
+
+// ShowWeeklySummary compiles the user's weekly summary and shows it in their
+// config room for them to confirm. If the user did not ask for it and did not
+// send any posts this week, nothing is shown.
+func ShowWeeklySummary(userID mid.UserID, roomID mid.RoomID, now time.Time, requested bool) {
+	lang := languageFor(userID)
+	summary, err := CompileWeeklySummary(userID, now)
+	if err != nil {
+		log.Errorf("Failed to compile the weekly summary of %s: %+v", userID, err)
+		if requested {
+			SendMessage(roomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: T(lang, "weekly.compile_failed", err)})
+		}
+		return
+	} else if summary.Posts == 0 {
+		log.Debugf("Not compiling a weekly summary for %s because they did not send any posts this week", userID)
+		if requested {
+			SendMessage(roomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: T(lang, "weekly.no_posts")})
+		}
+		return
+	}
+
+	log.Infof("Showing the weekly summary of %s", userID)
+	resp, err := SendMessage(roomID, FormatWeeklySummary(userID, summary, true))
+	if err != nil {
+		log.Errorf("Failed to send the weekly summary preview to %s: %+v", userID, err)
+		return
+	}
+	SendReaction(roomID, resp.EventID, CHECKMARK)
+	SendReaction(roomID, resp.EventID, RED_X)
+
+	pendingWeeklySummaries.Lock()
+	defer pendingWeeklySummaries.Unlock()
+	pendingWeeklySummaries.users[userID] = &pendingWeeklySummary{PreviewEventID: resp.EventID, Summary: summary}
+}
+
+// HandleWeeklySummaryReaction sends or cancels the weekly summary if the
+// reaction is to its preview, and returns whether it was.
+func HandleWeeklySummaryReaction(event *mevent.Event) bool {
+	relatesTo := event.Content.AsReaction().RelatesTo
+	pendingWeeklySummaries.Lock()
+	pending, found := pendingWeeklySummaries.users[event.Sender]
+	if !found || pending.PreviewEventID != relatesTo.EventID || (relatesTo.Key != CHECKMARK && relatesTo.Key != RED_X) {
+		pendingWeeklySummaries.Unlock()
+		return found && pending.PreviewEventID == relatesTo.EventID
+	}
+	delete(pendingWeeklySummaries.users, event.Sender)
+	pendingWeeklySummaries.Unlock()
+
+	// Mark the reaction as read after we've handled it.
+	defer matrixClient.MarkRead(event.RoomID, event.ID)
+
+	if relatesTo.Key == CHECKMARK {
+		SendWeeklySummary(event.RoomID, event.Sender, pending.Summary)
+	} else {
+		SendMessage(event.RoomID, &mevent.MessageEventContent{MsgType: mevent.MsgNotice, Body: T(languageFor(event.Sender), "weekly.cancelled")})
+	}
+	return true
+}
+
+// SendWeeklySummary sends the summary to the user's weekly room, or to their
+// send room if they did not set one, and tells them in the config room.
+func SendWeeklySummary(roomID mid.RoomID, userID mid.UserID, summary *WeeklySummary) {
+	lang := languageFor(userID)
+	weeklyRoomID := stateStore.GetWeeklyRoomId(userID)
+	if weeklyRoomID == "" {
+		sendRoomID, err := stateStore.GetSendRoomId(userID)
+		if err != nil {
+			content := format.RenderMarkdown(T(lang, "send.no_room"), true, false)
+			SendMessage(roomID, &content)
+			return
+		}
+		weeklyRoomID = sendRoomID
+	}
+
+	if !isRoomMember(weeklyRoomID, userID) {
+		content := format.RenderMarkdown(T(lang, "weekly.not_member", weeklyRoomID), true, false)
+		SendMessage(roomID, &content)
+		return
+	}
+
+	_, err := SendMessageOnBehalfOf(&userID, weeklyRoomID, FormatWeeklySummary(userID, summary, false))
+	if err != nil {
+		log.Errorf("Failed to send the weekly summary of %s to %s: %+v", userID, weeklyRoomID, err)
+		content := format.RenderMarkdown(T(lang, "weekly.failed", weeklyRoomID, weeklyRoomID), true, false)
+		SendMessage(roomID, &content)
+		return
+	}
+	content := format.RenderMarkdown(T(lang, "weekly.sent", weeklyRoomID, weeklyRoomID), true, false)
+	content.MsgType = mevent.MsgNotice
+	SendMessage(roomID, &content)
+}
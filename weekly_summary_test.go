@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/beeper/standupbot/store"
+)
+
+var friday = time.Date(2022, time.March, 18, 15, 0, 0, 0, time.UTC)
+
+func TestCompileWeeklySummary(t *testing.T) {
+	summary := compileWeeklySummary(monday, []*StandupFlow{
+		{
+			Friday:   []StandupItem{{Body: "Last week"}},
+			Today:    []StandupItem{{Body: "Fix bugs"}},
+			Blockers: []StandupItem{{Body: "Waiting for review"}, {Body: "Flaky CI"}},
+		},
+		{
+			Yesterday: []StandupItem{{Body: "fix  bugs"}},
+			Today:     []StandupItem{{Body: "Ship it"}},
+			Blockers:  []StandupItem{{Body: "Flaky CI"}},
+		},
+	})
+	if len(summary.Items) != 2 || summary.Items[0].Body != "Fix bugs" || summary.Items[1].Body != "Ship it" {
+		t.Errorf("Expected the items without duplicates, got %+v", summary.Items)
+	}
+	if len(summary.Blockers) != 2 || !summary.Blockers[0].Cleared || summary.Blockers[1].Cleared {
+		t.Errorf("Expected the first blocker to be cleared and the second to be open, got %+v", summary.Blockers)
+	}
+}
+
+func TestWeeklySummary(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	hs.sendText("!su room " + testSendRoom.String())
+	hs.sendText("!su weekly")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "You have not sent any standup posts this week")
+
+	hs.sendText("!su new")
+	answerQuestion(t, hs, "What did you do yesterday?", "Wrote tests")
+	answerQuestion(t, hs, "What are you planning to do today?", "Fix bugs")
+	answerQuestion(t, hs, "Do you have any blockers?", "Waiting for review")
+	answerQuestion(t, hs, "Do you have any other notes?")
+	confirmPreview(t, hs)
+
+	// Wednesday's post repeats Tuesday's plan and no longer has the blocker.
+	content, _ := json.Marshal(StandupFlow{
+		Yesterday: []StandupItem{{Body: "fix bugs"}},
+		Today:     []StandupItem{{Body: "Ship it"}},
+	})
+	if err := stateStore.SavePost(store.Post{UserID: testUser, FlowID: "wednesday", SentAt: tuesday.Add(24 * time.Hour), Content: content}); err != nil {
+		t.Fatal(err)
+	}
+
+	hs.sendText("!su weekly")
+	preview := hs.lastMessage(t, testConfigRoom)
+	assertContains(t, preview.Content.AsMessage().Body,
+		"Weekly summary preview:",
+		"@alice:example.com's weekly summary for the week of 2022-03-14:",
+		"**This week**\n- Wrote tests\n- Fix bugs\n- Ship it",
+		"**Blockers**\n- Waiting for review (cleared)",
+		"Send (✅) or Cancel (❌)?")
+	if reactions := hs.reactions(preview.ID); len(reactions) != 2 {
+		t.Fatalf("Expected reactions to the preview, got %v", reactions)
+	}
+
+	hs.react(preview.ID, CHECKMARK)
+	posts := hs.messages(testSendRoom)
+	if len(posts) != 2 {
+		t.Fatalf("Expected the daily post and the weekly summary in the send room, got %d messages", len(posts))
+	}
+	summary := posts[1].Content.AsMessage().Body
+	assertContains(t, summary, "weekly summary for the week of 2022-03-14", "- Ship it")
+	assertNotContains(t, summary, "preview", "Send (✅)")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "Sent weekly summary to")
+
+	// Reacting again does not send it twice.
+	hs.react(preview.ID, CHECKMARK)
+	if len(hs.messages(testSendRoom)) != 2 {
+		t.Error("Expected the weekly summary to be sent only once")
+	}
+}
+
+func TestWeeklySummaryAfterUndo(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	hs.sendText("!su room " + testSendRoom.String())
+	hs.sendText("!su new")
+	answerQuestion(t, hs, "What did you do yesterday?", "Wrote tests")
+	answerQuestion(t, hs, "What are you planning to do today?", "Fix bugs")
+	answerQuestion(t, hs, "Do you have any blockers?")
+	answerQuestion(t, hs, "Do you have any other notes?")
+	confirmPreview(t, hs)
+	hs.sendText("!su undo")
+
+	hs.sendText("!su weekly")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "You have not sent any standup posts this week")
+	if posts, err := stateStore.GetPosts(testUser, time.Time{}); err != nil || len(posts) != 0 {
+		t.Errorf("Expected the undone post to be removed, got %+v (%v)", posts, err)
+	}
+}
+
+func TestWeeklyRoom(t *testing.T) {
+	hs := setupTest(t, tuesday)
+	hs.sendText("!su weeklyroom")
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "Weekly summaries are sent to your send room")
+
+	hs.sendText("!su weeklyroom " + testSendRoom.String())
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "set that as the room for your weekly summaries")
+	if roomID := stateStore.GetWeeklyRoomId(testUser); roomID != testSendRoom {
+		t.Errorf("Expected the weekly room to be %s, got %s", testSendRoom, roomID)
+	}
+
+	hs.sendText("!su weeklyroom none")
+	if roomID := stateStore.GetWeeklyRoomId(testUser); roomID != "" {
+		t.Errorf("Expected the weekly room to be cleared, got %s", roomID)
+	}
+}
+
+func TestScheduledWeeklySummary(t *testing.T) {
+	hs := setupReminderTest(t, tuesday)
+	configuration.WeeklySummary = WeeklySummaryConfiguration{Enabled: true}
+	content, _ := json.Marshal(StandupFlow{Today: []StandupItem{{Body: "Fix bugs"}}})
+	if err := stateStore.SavePost(store.Post{UserID: testUser, FlowID: "tuesday", SentAt: tuesday, Content: content}); err != nil {
+		t.Fatal(err)
+	}
+
+	sendDueReminders(friday.Add(-time.Minute))
+	if at, ok, _ := nextWeeklySummaryTime(testUser, tuesday); !ok || !at.Equal(friday) {
+		t.Fatalf("Expected the weekly summary to be scheduled at %s, got %s", friday, at)
+	}
+	messages := len(hs.messages(testConfigRoom))
+	sendDueReminders(friday)
+	if len(hs.messages(testConfigRoom)) != messages+1 {
+		t.Fatalf("Expected the weekly summary preview to be sent")
+	}
+	assertContains(t, hs.lastMessage(t, testConfigRoom).Content.AsMessage().Body, "Weekly summary preview:", "- Fix bugs")
+
+	configuration.WeeklySummary = WeeklySummaryConfiguration{Enabled: true, Day: "Monday", Time: "9:00"}
+	if at, ok, _ := nextWeeklySummaryTime(testUser, friday); !ok || !at.Equal(time.Date(2022, time.March, 21, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the weekly summary to be scheduled on Monday at 9:00, got %s", at)
+	}
+}